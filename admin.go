@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/racingmars/go3270"
+)
+
+// How often the admin session list refreshes itself
+const adminRefreshInterval = 10 * time.Second
+
+// Rows available for the session list (rows 3-19); row 20 is left for the
+// "... and N more" note and row 21 for messages
+const adminMaxRows = 17
+
+// ShowAdminConsole lists all active sessions and lets an admin disconnect
+// one by entering its number, or switch maintenance mode with F9. It
+// returns when the admin presses F3.
+func ShowAdminConsole(conn net.Conn, config *Config, authSession *authSession) error {
+	th := config.Theme
+
+	message := ""
+	lastInput := time.Now()
+
+	for {
+		sessions := sessionRegistry.List()
+
+		screen := go3270.Screen{
+			{Row: 0, Col: getCenteredPosition("Secure3270Proxy - Active Sessions", 79), Content: "Secure3270Proxy - Active Sessions", Color: th.title(go3270.White), Intense: true},
+			{Row: 2, Col: 1, Content: fmt.Sprintf("%-4s %-12s %-24s %-20s %s", "NR", "USER", "SOURCE", "HOST", "TIME"), Color: th.label(go3270.Turquoise)},
+		}
+
+		for i, session := range sessions {
+			if i >= adminMaxRows {
+				screen = append(screen, go3270.Field{
+					Row:     3 + adminMaxRows,
+					Col:     1,
+					Content: fmt.Sprintf("... and %d more", len(sessions)-adminMaxRows),
+					Color:   go3270.Yellow,
+				})
+				break
+			}
+
+			host := session.host
+			if host == "" {
+				host = "(menu)"
+			}
+			line := fmt.Sprintf("%-4d %-12.12s %-24.24s %-20.20s %s", session.id, session.username,
+				session.remoteAddr, host, time.Since(session.started).Round(time.Second))
+			screen = append(screen, go3270.Field{Row: 3 + i, Col: 1, Content: line, Color: go3270.Green})
+		}
+
+		maintenance := "F9=Maintenance mode"
+		if on, since := inMaintenance(); on {
+			maintenance = "F9=End maintenance"
+			screen = append(screen, go3270.Field{Row: 1, Col: 1,
+				Content: fmt.Sprintf("Maintenance mode since %s, new logons are refused", since.Format("2006-01-02 15:04:05")),
+				Color:   go3270.Yellow, Intense: true})
+		}
+
+		screen = append(screen,
+			go3270.Field{Row: 21, Col: 1, Content: message, Color: th.error(go3270.Red), Intense: true},
+			go3270.Field{Row: 22, Col: 1, Content: "F3=Return  Enter=Refresh  " + maintenance, Color: go3270.Blue},
+			go3270.Field{Row: 23, Col: 1, Content: "Disconnect session number:", Color: th.label(go3270.White)},
+			go3270.Field{Row: 23, Col: 28, Name: "session", Write: true, NumericOnly: true, Color: th.input(go3270.Green), Highlighting: go3270.Underscore},
+			go3270.Field{Row: 23, Col: 35, Autoskip: true},
+		)
+
+		// Refresh periodically, like the clock screen does
+		conn.SetReadDeadline(screenDeadline(config, authSession, time.Now().Add(adminRefreshInterval), lastInput))
+		resp, err := go3270.ShowScreenOpts(screen, nil, conn,
+			go3270.ScreenOpts{CursorRow: 23, CursorCol: 29})
+		conn.SetReadDeadline(time.Time{})
+
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if sessionExpired(config, authSession) {
+					return nil
+				}
+				if idleExpired(config, lastInput) {
+					return errSessionIdle
+				}
+				continue
+			}
+			return fmt.Errorf("error showing admin console: %v", err)
+		}
+		lastInput = time.Now()
+
+		if resp.AID == go3270.AIDPF3 {
+			return nil
+		}
+
+		message = ""
+		if resp.AID == go3270.AIDPF9 {
+			on, _ := inMaintenance()
+			setMaintenance(!on, "admin "+authSession.username)
+			continue
+		}
+		if resp.AID != go3270.AIDEnter || resp.Values["session"] == "" {
+			continue
+		}
+
+		id, err := strconv.Atoi(resp.Values["session"])
+		if err != nil {
+			message = "Invalid session number"
+			continue
+		}
+		if id == authSession.sessionID {
+			message = "You can't disconnect your own session"
+			continue
+		}
+
+		if sessionRegistry.Terminate(id) {
+			log.Printf("Admin %s forcibly disconnected session %d", authSession.username, id)
+			auditLog("ADMIN_DISCONNECT", "admin=%q session=%d", authSession.username, id)
+			message = fmt.Sprintf("Session %d disconnected", id)
+		} else {
+			message = fmt.Sprintf("Session %d not found", id)
+		}
+	}
+}
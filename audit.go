@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	auditFile     *os.File
+	auditFilePath string
+	auditLock     sync.Mutex
+)
+
+// openAuditLog opens the audit file in append mode. Calling it again with
+// the same path reopens the file, which is what log rotation needs.
+func openAuditLog(path string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file %s: %v", path, err)
+	}
+
+	auditLock.Lock()
+	defer auditLock.Unlock()
+
+	if auditFile != nil {
+		auditFile.Close()
+	}
+	auditFile = file
+	auditFilePath = path
+
+	return nil
+}
+
+// closeAuditLog stops audit logging, for when auditfile is removed from
+// the config
+func closeAuditLog() {
+	auditLock.Lock()
+	defer auditLock.Unlock()
+
+	if auditFile != nil {
+		auditFile.Close()
+	}
+	auditFile = nil
+	auditFilePath = ""
+}
+
+// reopenAuditLog reopens the current audit file after it was rotated
+func reopenAuditLog() {
+	auditLock.Lock()
+	path := auditFilePath
+	auditLock.Unlock()
+
+	if path == "" {
+		return
+	}
+
+	if err := openAuditLog(path); err != nil {
+		log.Printf("Failed to reopen audit file: %v", err)
+		return
+	}
+	log.Printf("Reopened audit file %s", path)
+}
+
+// auditLog writes a single timestamped audit event. It is a no-op when
+// no audit file is configured. Values a client can choose, like the userid
+// typed at the logon screen, must be formatted with %q so they can't
+// forge fields of their own.
+func auditLog(event string, format string, args ...interface{}) {
+	auditLock.Lock()
+	defer auditLock.Unlock()
+
+	if auditFile == nil {
+		return
+	}
+
+	line := fmt.Sprintf("%s event=%s %s\n",
+		time.Now().UTC().Format(time.RFC3339), event, fmt.Sprintf(format, args...))
+	if _, err := auditFile.WriteString(line); err != nil {
+		log.Printf("Failed to write audit event: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCloseAuditLog(t *testing.T) {
+	t.Cleanup(closeAuditLog)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := openAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+	auditLog("LOGIN", "user=%q", "jdoe")
+	file := auditFile
+
+	closeAuditLog()
+	if _, err := file.WriteString("x"); err == nil {
+		t.Errorf("the audit file is still open after closeAuditLog")
+	}
+
+	// Nothing is written after closing, and SIGHUP doesn't open it again
+	auditLog("LOGIN", "user=%q", "alice")
+	reopenAuditLog()
+	if auditFile != nil {
+		t.Errorf("reopenAuditLog opened %s again after closeAuditLog", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 || !strings.HasSuffix(lines[0], `event=LOGIN user="jdoe"`) {
+		t.Errorf("audit file = %q, want only jdoe's event", data)
+	}
+}
@@ -18,13 +18,21 @@ v 0.6 selecing X or 99 from hosts view will disconnect session
 */
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"log"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/racingmars/go3270"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Field names for auth screens
@@ -35,151 +43,621 @@ const (
 )
 
 type User struct {
-	Username string
-	Password string
-	HostFile string // Path to user-specific host file
+	Username           string   `yaml:"username"`
+	Password           string   `yaml:"password"`
+	HostFile           string   `yaml:"hostfile"`        // Path to user-specific host file
+	HostFiles          []string `yaml:"hostfiles"`       // Other host files the user may switch to on the who am I screen
+	TOTPSecret         string   `yaml:"totpsecret"`      // Base32 TOTP secret; empty if no second factor
+	AllowedHosts       []string `yaml:"allowedhosts"`    // Host names or numbers this user may see (empty = all)
+	Admin              bool     `yaml:"admin"`           // May use the admin console
+	Record             bool     `yaml:"record"`          // Sessions are recorded when recorddir is set
+	ReadOnly           bool     `yaml:"readonly"`        // May browse the host menu but not connect
+	AutoConnect        string   `yaml:"autoconnect"`     // Host to connect to straight after logon
+	AfterHost          string   `yaml:"afterhost"`       // "menu" or "disconnect" after a host session, empty = afterhost setting
+	PasswordHistory    []string `yaml:"passwordhistory"` // bcrypt hashes of earlier passwords, newest first
+	MustChangePassword bool     `yaml:"-"`               // Password was marked with a leading "!"
+	Line               int      `yaml:"-"`               // Line in users.cnf, or entry number in a YAML users list
 }
 
 type authSession struct {
 	authenticated bool
 	username      string
-	hostFile      string // Store the host file for this user's session
+	hostFile      string               // Store the host file for this user's session
+	hostFiles     []string             // Other host files the user may switch to
+	allowedHosts  []string             // Restricts which entries of the host file are shown
+	admin         bool                 // User may open the admin console
+	record        bool                 // Host sessions are recorded
+	readOnly      bool                 // Host menu is view-only
+	autoConnect   string               // Host to skip the menu for
+	afterHost     string               // Per-user afterhost setting, empty to use the config
+	hostSessions  int                  // Host sessions completed since logon
+	tlsState      *tls.ConnectionState // TLS details of the client connection, nil for plain telnet
+	terminal      terminal             // Terminal type reported during telnet negotiation
+	sessionID     int                  // Entry in the session registry
+	ctx           context.Context      // Cancelled when the session is terminated
+	remoteAddr    string               // Source address of the client connection
+	loginTime     time.Time            // When the user authenticated
+	previousLogin *loginRecord         // The logon before this one, nil for the first (or when not recorded)
 }
 
-var (
-	authUsers     []User
-	authUsersLock sync.RWMutex
-)
+// authUsers points at the current users list. A stored list is never
+// modified: reloads and password changes build a new one and swap it in, so
+// a login always sees one complete, consistent list.
+var authUsers atomic.Pointer[[]User]
+
+// authUsersLock serializes the changes that replace the users list, so a
+// password change can't undo a reload that happened in the meantime
+var authUsersLock sync.Mutex
+
+// currentUsers returns the users list in effect. It must not be modified.
+func currentUsers() []User {
+	if users := authUsers.Load(); users != nil {
+		return *users
+	}
+	return nil
+}
+
+// The users file is in the same directory as the config file
+const usersFile = "users.cnf"
 
-// LoadAuthConfig loads the authentication configuration from users.cnf file
-func LoadAuthConfig(configFile string) error {
-	// The users file is in the same directory as the config file
-	usersFile := "users.cnf"
+// A users.cnf entry with this userid lets any userid that isn't listed log
+// in with its password, e.g. for training or guest access
+const wildcardUsername = "*"
 
-	file, err := os.Open(usersFile)
+// LoadAuthConfig loads the authentication configuration from users.cnf file,
+// or from the users section when the main config file is YAML
+func LoadAuthConfig(configFile string, config *Config) error {
+	source := usersFile
+	position := "lines"
+	var users []User
+	var err error
+	if isYAMLFile(configFile) && fileExists(configFile) {
+		source = configFile
+		position = "entries"
+		users, err = loadYAMLUsers(configFile)
+	} else {
+		users, err = loadUsersFile(usersFile)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to open users file: %v", err)
+		return err
+	}
+
+	if len(users) == 0 {
+		return fmt.Errorf("no valid users found in %s", source)
+	}
+
+	// Only the first of several entries for a userid can ever log in
+	if duplicates := duplicateUsers(users, config.CaseSensitiveUsers); len(duplicates) > 0 {
+		for _, dup := range duplicates {
+			msg := fmt.Sprintf("user %s appears more than once in %s (%s %s)",
+				dup.username, source, position, joinInts(dup.lines))
+			if config.DuplicateUsersFatal {
+				return fmt.Errorf("%s", msg)
+			}
+			log.Printf("Warning: %s, only the first is used", msg)
+		}
+	}
+
+	// Update the global users list
+	authUsersLock.Lock()
+	authUsers.Store(&users)
+	authUsersLock.Unlock()
+
+	return nil
+}
+
+// loadUsersFile parses the username/password/hostfile/totpsecret/allowedhosts/flags/history lines of users.cnf
+func loadUsersFile(filename string) ([]User, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open users file: %v", err)
 	}
 	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
 	var users []User
+	lineNumber := 0
 
 	for scanner.Scan() {
+		lineNumber++
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
 
-		parts := strings.SplitN(line, "/", 3)
+		// username/password/hostfile/totpsecret/allowedhosts/flags/history
+		parts, legacy := userFields(line)
+		if legacy {
+			log.Printf("Warning: reading %q on line %d of the users file as the host file; put host files with a \"/\" in double quotes", parts[2], lineNumber)
+		}
 		if len(parts) < 2 {
 			continue
 		}
 
-		username := strings.TrimSpace(parts[0])
-		password := strings.TrimSpace(parts[1])
+		user := User{
+			Username: parts[0],
+			Password: parts[1],
+			Line:     lineNumber,
+		}
 
 		// Get the host file if it exists, otherwise use the default
-		hostFile := ""
 		if len(parts) >= 3 {
-			hostFile = strings.TrimSpace(parts[2])
+			user.HostFile = parts[2]
+		}
+
+		// Get the optional TOTP secret for two-factor logins
+		if len(parts) >= 4 {
+			user.TOTPSecret = parts[3]
+		}
+
+		// Get the optional comma separated list of allowed host names or numbers
+		if len(parts) >= 5 {
+			for _, entry := range strings.Split(parts[4], ",") {
+				if entry = strings.TrimSpace(entry); entry != "" {
+					user.AllowedHosts = append(user.AllowedHosts, entry)
+				}
+			}
+		}
+
+		// Get the optional comma separated flags
+		if len(parts) >= 6 {
+			for _, flag := range strings.Split(parts[5], ",") {
+				switch strings.ToLower(strings.TrimSpace(flag)) {
+				case "":
+				case "admin":
+					user.Admin = true
+				case "record":
+					user.Record = true
+				case "readonly":
+					user.ReadOnly = true
+				default:
+					// autoconnect=<host> sends this user straight to one host
+					if name, ok := strings.CutPrefix(strings.TrimSpace(flag), "autoconnect="); ok {
+						user.AutoConnect = name
+						continue
+					}
+					// hostfile=<file> adds a host file the user can switch to
+					if file, ok := strings.CutPrefix(strings.TrimSpace(flag), "hostfile="); ok {
+						user.HostFiles = append(user.HostFiles, file)
+						continue
+					}
+					// afterhost=menu|disconnect overrides the global afterhost setting
+					if mode, ok := strings.CutPrefix(strings.TrimSpace(flag), "afterhost="); ok {
+						user.AfterHost = mode
+						continue
+					}
+					log.Printf("Warning: unknown flag %q for user %s", flag, user.Username)
+				}
+			}
+		}
+
+		// Hashes of earlier passwords, kept by the password change screen
+		if len(parts) >= 7 {
+			for _, hash := range strings.Split(parts[6], ",") {
+				if hash = strings.TrimSpace(hash); hash != "" {
+					user.PasswordHistory = append(user.PasswordHistory, hash)
+				}
+			}
 		}
 
-		if username != "" && password != "" {
-			users = append(users, User{
-				Username: username,
-				Password: password,
-				HostFile: hostFile,
-			})
+		if normalizeUser(&user) {
+			users = append(users, user)
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading users file: %v", err)
+		return nil, fmt.Errorf("error reading users file: %v", err)
 	}
 
-	if len(users) == 0 {
-		return fmt.Errorf("no valid users found in %s", usersFile)
+	return users, nil
+}
+
+// normalizeUser cleans up a user entry read from a config file and reports
+// whether it is usable
+func normalizeUser(user *User) bool {
+	user.Username = strings.TrimSpace(user.Username)
+	user.Password = strings.TrimSpace(user.Password)
+	user.HostFile = strings.TrimSpace(user.HostFile)
+	user.TOTPSecret = strings.TrimSpace(user.TOTPSecret)
+
+	// A leading "!" means the user must change the password at next logon
+	if strings.HasPrefix(user.Password, "!") {
+		user.MustChangePassword = true
+		user.Password = strings.TrimPrefix(user.Password, "!")
 	}
 
-	// Update the global users list
-	authUsersLock.Lock()
-	authUsers = users
-	authUsersLock.Unlock()
+	// Passwords kept in a secrets store are fetched now; a reload picks up
+	// rotated values
+	if isPasswordRef(user.Password) {
+		if user.MustChangePassword {
+			log.Printf("Warning: user %s has an external password, ignoring the change marker", user.Username)
+			user.MustChangePassword = false
+		}
+		password, err := resolvePasswordRef(user.Password)
+		if err != nil {
+			log.Printf("Warning: skipping user %s: %v", user.Username, err)
+			return false
+		}
+		user.Password = password
+	}
 
-	return nil
+	// The wildcard entry stands for many users, so it has no password of
+	// its own to change
+	if user.Username == wildcardUsername && user.MustChangePassword {
+		log.Printf("Warning: the wildcard user can't be forced to change its password, ignoring the change marker")
+		user.MustChangePassword = false
+	}
+
+	user.AfterHost = strings.ToLower(strings.TrimSpace(user.AfterHost))
+	if user.AfterHost != "" && user.AfterHost != "menu" && user.AfterHost != "disconnect" {
+		log.Printf("Warning: invalid afterhost %q for user %s, using the default", user.AfterHost, user.Username)
+		user.AfterHost = ""
+	}
+
+	if user.TOTPSecret != "" {
+		if _, err := decodeTOTPSecret(user.TOTPSecret); err != nil {
+			log.Printf("Warning: invalid TOTP secret for user %s, logins will fail: %v", user.Username, err)
+		}
+	}
+
+	return user.Username != "" && user.Password != ""
+}
+
+// isBcryptHash reports whether a stored password is a bcrypt hash rather than plaintext
+func isBcryptHash(password string) bool {
+	return strings.HasPrefix(password, "$2a$") ||
+		strings.HasPrefix(password, "$2b$") ||
+		strings.HasPrefix(password, "$2y$")
+}
+
+// splitUserFields splits a users.cnf line on "/" into at most n fields like
+// strings.SplitN, except that a bcrypt hash (which may itself contain "/")
+// is always kept together as a single field
+func splitUserFields(line string, n int) []string {
+	var fields []string
+	rest := line
+	for len(fields) < n-1 {
+		// A field in double quotes may contain "/", e.g. "@file:/run/secrets/jdoe"
+		if strings.HasPrefix(rest, "\"") {
+			if end := strings.Index(rest[1:], "\""); end >= 0 {
+				end++
+				if len(rest) == end+1 || rest[end+1] == '/' {
+					fields = append(fields, rest[1:end])
+					if len(rest) == end+1 {
+						return fields
+					}
+					rest = rest[end+2:]
+					continue
+				}
+			}
+		}
+
+		// bcrypt hashes are always 60 characters long, plus an optional
+		// "!" marking that the password must be changed
+		size := 60
+		if strings.HasPrefix(rest, "!") {
+			size++
+		}
+		if isBcryptHash(strings.TrimPrefix(rest, "!")) && len(rest) >= size &&
+			(len(rest) == size || rest[size] == '/') {
+			fields = append(fields, rest[:size])
+			if len(rest) == size {
+				return fields
+			}
+			rest = rest[size+1:]
+			continue
+		}
+
+		idx := strings.Index(rest, "/")
+		if idx < 0 {
+			break
+		}
+		fields = append(fields, rest[:idx])
+		rest = rest[idx+1:]
+	}
+	return append(fields, rest)
+}
+
+// minTOTPSecretBytes is the shortest TOTP secret, 80 bits, a users.cnf
+// field is read as; authenticator apps hand out at least that much
+const minTOTPSecretBytes = 10
+
+// userFields splits a users.cnf line into its fields. Lines written before
+// the TOTP secret field was added had the host file as the rest of the line,
+// so it could contain "/" without quotes. When the fields after the host
+// file don't read as a TOTP secret, allowed hosts, flags and history but the
+// rest of the line names host files that exist, the line is taken that way
+// and legacy is set. Otherwise a bad TOTP secret stays in place, so the
+// user's logins fail rather than skip the second factor.
+func userFields(line string) (parts []string, legacy bool) {
+	parts = splitUserFields(line, 7)
+	if len(parts) <= 3 {
+		return parts, false
+	}
+	old := splitUserFields(line, 3)
+	if strings.HasPrefix(old[2], "\"") {
+		return parts, false
+	}
+
+	valid := true
+	if parts[3] != "" {
+		key, err := decodeTOTPSecret(parts[3])
+		valid = err == nil && len(key) >= minTOTPSecretBytes
+	}
+	if valid && len(parts) >= 6 {
+		for _, flag := range strings.Split(parts[5], ",") {
+			if !knownUserFlag(flag) {
+				valid = false
+			}
+		}
+	}
+	if valid && len(parts) >= 7 {
+		for _, hash := range strings.Split(parts[6], ",") {
+			if hash = strings.TrimSpace(hash); hash != "" && !isBcryptHash(hash) {
+				valid = false
+			}
+		}
+	}
+	if valid {
+		return parts, false
+	}
+
+	for _, filename := range strings.Split(old[2], ",") {
+		if info, err := os.Stat(strings.TrimSpace(filename)); err != nil || info.IsDir() {
+			return parts, false
+		}
+	}
+	return old, true
+}
+
+// knownUserFlag reports whether a word of the users.cnf flags field is one
+// loadUsersFile understands
+func knownUserFlag(flag string) bool {
+	flag = strings.TrimSpace(flag)
+	switch strings.ToLower(flag) {
+	case "", "admin", "record", "readonly":
+		return true
+	}
+	for _, prefix := range []string{"autoconnect=", "hostfile=", "afterhost="} {
+		if strings.HasPrefix(flag, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPassword compares a submitted password against the stored one,
+// using bcrypt when the stored password is a hash
+func checkPassword(stored, password string) bool {
+	if isBcryptHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	}
+	return password == stored
+}
+
+// hashPassword returns a bcrypt hash suitable for the password field of users.cnf
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// canonicalUsername maps a typed userid to the name in users.cnf, ignoring
+// case unless caseSensitive is set. Unknown userids are returned unchanged.
+func canonicalUsername(username string, caseSensitive bool) string {
+	for _, user := range currentUsers() {
+		if username == user.Username || (!caseSensitive && strings.EqualFold(username, user.Username)) {
+			return user.Username
+		}
+	}
+	return username
+}
+
+// duplicateUser is a userid with more than one entry in the users list
+type duplicateUser struct {
+	username string
+	lines    []int
+}
+
+// duplicateUsers finds userids listed more than once, comparing them the
+// way logins do, in the order they first appear
+func duplicateUsers(users []User, caseSensitive bool) []duplicateUser {
+	var duplicates []duplicateUser
+	seen := make(map[string]int) // userid -> index in duplicates, -1 if seen once
+	first := make(map[string]User)
+	for _, user := range users {
+		key := user.Username
+		if !caseSensitive {
+			key = strings.ToLower(key)
+		}
+		index, ok := seen[key]
+		switch {
+		case !ok:
+			seen[key] = -1
+			first[key] = user
+		case index < 0:
+			seen[key] = len(duplicates)
+			duplicates = append(duplicates, duplicateUser{username: first[key].Username, lines: []int{first[key].Line, user.Line}})
+		default:
+			duplicates[index].lines = append(duplicates[index].lines, user.Line)
+		}
+	}
+	return duplicates
+}
+
+// joinInts lists numbers as "1, 2, 3"
+func joinInts(numbers []int) string {
+	text := make([]string, len(numbers))
+	for i, n := range numbers {
+		text[i] = strconv.Itoa(n)
+	}
+	return strings.Join(text, ", ")
+}
+
+// sameUsername compares two userids the way logins do
+func sameUsername(a, b string, caseSensitive bool) bool {
+	if caseSensitive {
+		return a == b
+	}
+	return strings.EqualFold(a, b)
 }
 
-// authenticateUser checks if the provided credentials are valid and returns the user's host file
-func authenticateUser(username, password string) (bool, string) {
-	authUsersLock.RLock()
-	defer authUsersLock.RUnlock()
+// authenticateUser checks if the provided credentials are valid and returns
+// the matching user. A userid that isn't in users.cnf falls back to the
+// wildcard entry, if there is one; a listed user never does.
+func authenticateUser(username, password string) (User, bool) {
+	users := currentUsers()
 
-	for _, user := range authUsers {
-		if username == user.Username && password == user.Password {
-			return true, user.HostFile
+	var wildcard *User
+	for i, user := range users {
+		if user.Username == wildcardUsername {
+			wildcard = &users[i]
+			continue
+		}
+		if username == user.Username {
+			return user, checkPassword(user.Password, password)
 		}
 	}
 
-	return false, ""
+	if wildcard != nil && username != "" && username != wildcardUsername && checkPassword(wildcard.Password, password) {
+		log.Printf("User %s logged in through the wildcard entry", username)
+		user := *wildcard
+		user.Username = username
+		return user, true
+	}
+
+	return User{}, false
+}
+
+// errAuthRejected means the LDAP or RADIUS server answered and refused the
+// login
+var errAuthRejected = errors.New("login rejected by the authentication server")
+
+// authenticateLogin checks the LDAP or RADIUS server, if one is configured,
+// or the users file and then the break-glass account. With a server the
+// users file is only used while it can't be reached and failopen allows it.
+func authenticateLogin(config *Config, conn net.Conn, username, password string) (User, bool) {
+	var user User
+	var err error
+	backend, failClosed := "", false
+	switch {
+	case config.LDAPURL != "":
+		backend, failClosed = "LDAP", config.LDAPFailClosed
+		user, err = authenticateLDAP(config, username, password)
+	case len(config.RADIUSServers) > 0:
+		backend, failClosed = "RADIUS", config.RADIUSFailClosed
+		user, err = authenticateRADIUS(config, conn, username, password)
+	}
+
+	useUsersFile := true
+	if backend != "" {
+		switch {
+		case err == nil:
+			return user, true
+		case errors.Is(err, errAuthRejected):
+			useUsersFile = false
+		case failClosed:
+			log.Printf("%s authentication for %s failed, refusing login: %v", backend, username, err)
+			useUsersFile = false
+		default:
+			log.Printf("%s authentication for %s failed, using users file: %v", backend, username, err)
+		}
+	}
+
+	if useUsersFile {
+		if user, ok := authenticateUser(username, password); ok {
+			return user, true
+		}
+	}
+	if breakGlassConfigured(config) {
+		return authenticateBreakGlass(config, conn, username, password)
+	}
+	return User{}, false
 }
 
-// HandleAuth manages the authentication flow using 3270 screens
-func HandleAuth(conn net.Conn) (*authSession, error) {
+// HandleAuth manages the authentication flow using 3270 screens.
+// certUser is the CN of a verified TLS client certificate, or empty.
+func HandleAuth(conn net.Conn, config *Config, certUser string) (*authSession, error) {
+	// While draining for maintenance nobody new gets past this point
+	if on, _ := inMaintenance(); on {
+		log.Printf("Refused logon from %s: maintenance mode", conn.RemoteAddr())
+		showDisconnectScreen(conn, config, "System in maintenance, please try again later.")
+		return nil, fmt.Errorf("maintenance mode, logon refused")
+	}
+
 	// Create field values map
 	fieldValues := make(map[string]string)
 
-	// Create login screen
+	// Pre-fill the username from the client certificate
+	if certUser != "" {
+		fieldValues[fieldUsername] = certUser
+	}
+
+	// Create login screen. The wording comes from the logon template, cut
+	// to the space available so it can't overlap the input fields.
+	text := getLogonText()
+	if config.LogonProcedure != "" {
+		text.Procedure = config.LogonProcedure
+	}
+	if config.LogonSize != "" {
+		text.Size = config.LogonSize
+	}
+	th := config.Theme
 	loginScreen := go3270.Screen{
 		// Title bar with dashes
-		{Row: 0, Col: 0, Content: strings.Repeat("-", 15) + " SECURE3270PROXY - TSO/E  LOGON " + strings.Repeat("-", 15), Color: go3270.White},
+		{Row: 0, Col: 0, Content: truncateText(text.Title, 79), Color: th.title(go3270.White)},
 
 		// Function key help line
-		{Row: 2, Col: 0, Content: "PF1/PF13 ==> Help   PF9 ==> Logoff", Color: go3270.White},
+		{Row: 2, Col: 0, Content: truncateText(text.Help, 79), Color: go3270.White},
 
 		// Main section headers
-		{Row: 4, Col: 3, Content: "ENTER LOGON PARAMETERS BELOW:", Color: go3270.White},
-		{Row: 4, Col: 39, Content: "RACF LOGON PARAMETERS:", Color: go3270.White},
+		{Row: 4, Col: 3, Content: truncateText(text.Header, 35), Color: go3270.White},
+		{Row: 4, Col: 39, Content: truncateText(text.RightHeader, 40), Color: go3270.White},
 
 		// Left column fields
-		{Row: 6, Col: 3, Content: "USERID    ", Color: go3270.Turquoise},
+		{Row: 6, Col: 3, Content: fmt.Sprintf("%-10s", truncateText(text.UseridLabel, 9)), Color: th.label(go3270.Turquoise)},
 		{Row: 6, Col: 13, Content: "===>", Color: go3270.White},
-		{Row: 6, Col: 19, Name: fieldUsername, Write: true, Color: go3270.Red},
+		{Row: 6, Col: 19, Name: fieldUsername, Write: certUser == "", Color: th.input(go3270.Red)},
 		{Row: 6, Col: 27, Autoskip: true},
 
-		{Row: 8, Col: 3, Content: "PASSWORD  ", Color: go3270.Turquoise},
+		{Row: 8, Col: 3, Content: fmt.Sprintf("%-10s", truncateText(text.PasswordLabel, 9)), Color: th.label(go3270.Turquoise)},
 		{Row: 8, Col: 13, Content: "===>", Color: go3270.White},
-		{Row: 8, Col: 19, Name: fieldPassword, Write: true, Hidden: true, Color: go3270.Red},
+		{Row: 8, Col: 19, Name: fieldPassword, Write: true, Hidden: true, Color: th.input(go3270.Red)},
 		{Row: 8, Col: 36, Autoskip: true},
 
-		{Row: 10, Col: 3, Content: "PROCEDURE ", Color: go3270.Turquoise},
+		{Row: 10, Col: 3, Content: "PROCEDURE ", Color: th.label(go3270.Turquoise)},
 		{Row: 10, Col: 13, Content: "===>", Color: go3270.White},
-		{Row: 10, Col: 19, Content: "TSOISPF", Color: go3270.Pink},
+		{Row: 10, Col: 19, Content: truncateText(text.Procedure, 19), Color: go3270.Pink},
 
-		{Row: 12, Col: 3, Content: "ACCT NMBR ", Color: go3270.Turquoise},
+		{Row: 12, Col: 3, Content: "ACCT NMBR ", Color: th.label(go3270.Turquoise)},
 		{Row: 12, Col: 13, Content: "===>", Color: go3270.White},
+		{Row: 12, Col: 19, Content: truncateText(text.AcctNmbr, 60), Color: go3270.Pink},
 
-		{Row: 14, Col: 3, Content: "SIZE      ", Color: go3270.Turquoise},
+		{Row: 14, Col: 3, Content: "SIZE      ", Color: th.label(go3270.Turquoise)},
 		{Row: 14, Col: 13, Content: "===>", Color: go3270.White},
-		{Row: 14, Col: 19, Content: "6144", Color: go3270.Pink},
+		{Row: 14, Col: 19, Content: truncateText(text.Size, 60), Color: go3270.Pink},
 
-		{Row: 16, Col: 3, Content: "PERFORM   ", Color: go3270.Turquoise},
+		{Row: 16, Col: 3, Content: "PERFORM   ", Color: th.label(go3270.Turquoise)},
 		{Row: 16, Col: 13, Content: "===>", Color: go3270.White},
+		{Row: 16, Col: 19, Content: truncateText(text.Perform, 60), Color: go3270.Pink},
 
-		{Row: 18, Col: 3, Content: "COMMAND   ", Color: go3270.Turquoise},
+		{Row: 18, Col: 3, Content: "COMMAND   ", Color: th.label(go3270.Turquoise)},
 		{Row: 18, Col: 13, Content: "===>", Color: go3270.White},
+		{Row: 18, Col: 19, Content: truncateText(text.Command, 60), Color: go3270.Pink},
 
 		// Right column fields
-		{Row: 10, Col: 39, Content: "GROUP IDENT  ", Color: go3270.Turquoise},
+		{Row: 10, Col: 39, Content: "GROUP IDENT  ", Color: th.label(go3270.Turquoise)},
 		{Row: 10, Col: 51, Content: "===>", Color: go3270.White},
 
 		// Options section
-		{Row: 21, Col: 3, Content: "ENTER AN 'S' BEFORE EACH OPTION DESIRED BELOW:", Color: go3270.White},
+		{Row: 21, Col: 3, Content: truncateText(text.OptionsHeader, 76), Color: go3270.White},
 
-		{Row: 23, Col: 11, Content: "-NOMAIL         -NONOTICE        -RECONNECT        -OIDCARD", Color: go3270.Turquoise},
+		{Row: 23, Col: 11, Content: truncateText(text.Options, 68), Color: th.label(go3270.Turquoise)},
 
-		// Error message field (hidden at bottom)
-		{Row: 24, Col: 0, Name: fieldErrorMsg, Color: go3270.Red, Intense: true},
+		// Error message field (row 24 is off screen, so use the empty row 19)
+		{Row: 19, Col: 3, Name: fieldErrorMsg, Color: th.error(go3270.Red), Intense: true},
 	}
 
 	// Define rules
@@ -188,44 +666,142 @@ func HandleAuth(conn net.Conn) (*authSession, error) {
 		fieldPassword: {Validator: go3270.NonBlank},
 	}
 
-	session := &authSession{}
+	// A userid taken from the client certificate can't be changed, so the
+	// cursor starts in the password field instead
+	cursorRow, cursorCol := 6, 20
+	if certUser != "" {
+		cursorRow = 8
+	}
+
+	session := &authSession{remoteAddr: conn.RemoteAddr().String()}
+	failedAttempts := 0
+	authTimeout := secondsOrDefault(config.AuthTimeout, 300*time.Second)
 
 	for {
+		// Give the user authtimeout to press Enter, starting over after each
+		// attempt, so abandoned logon screens don't hold connections open
+		conn.SetReadDeadline(time.Now().Add(authTimeout))
+
 		// Display the screen and get user input
 		resp, err := go3270.HandleScreen(
 			loginScreen,
 			rules,
 			fieldValues,
 			[]go3270.AID{go3270.AIDEnter},
-			[]go3270.AID{go3270.AIDPF9},
+			[]go3270.AID{go3270.AIDPF1, go3270.AIDPF13, go3270.AIDPF9},
 			fieldErrorMsg,
-			6, 20, // Position cursor at username field
+			cursorRow, cursorCol,
 			conn,
 		)
+		conn.SetReadDeadline(time.Time{})
 
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			log.Printf("Logon screen timed out after %v for %s", authTimeout, session.remoteAddr)
+			showDisconnectScreen(conn, config, "No logon received in time.")
+			return nil, fmt.Errorf("logon timed out")
+		}
 		if err != nil {
 			return nil, fmt.Errorf("screen show error: %v", err)
 		}
 
+		// Show the help, then the logon screen again with the userid kept
+		if resp.AID == go3270.AIDPF1 || resp.AID == go3270.AIDPF13 {
+			if err := ShowHelp(conn, config, helpLogon, time.Now().Add(authTimeout)); err != nil {
+				return nil, fmt.Errorf("help screen error: %v", err)
+			}
+			fieldValues[fieldUsername] = resp.Values[fieldUsername]
+			fieldValues[fieldErrorMsg] = ""
+			continue
+		}
+
 		// Check if user pressed PF9 (logoff)
 		if resp.AID == go3270.AIDPF9 {
 			return nil, fmt.Errorf("user requested logoff with PF9")
 		}
 
 		if resp.AID == go3270.AIDEnter {
-			username := resp.Values[fieldUsername]
+			// Userids are matched without surrounding blanks and, by default,
+			// regardless of case; passwords are always exact
+			username := strings.TrimSpace(resp.Values[fieldUsername])
+			if certUser != "" {
+				// The protected field isn't sent back by the terminal
+				username = certUser
+			}
+			if config.UppercaseUserid {
+				// Classic TSO behaviour: the userid is always uppercase
+				username = strings.ToUpper(username)
+			}
+			username = canonicalUsername(username, config.CaseSensitiveUsers)
 			password := resp.Values[fieldPassword]
 
-			authenticated, hostFile := authenticateUser(username, password)
-			if authenticated {
+			if config.ClientCertBind && certUser != "" && !sameUsername(username, certUser, config.CaseSensitiveUsers) {
+				// When bound to the client certificate, only its CN may log in
+				log.Printf("SECURITY: user %s does not match client certificate CN %s", username, certUser)
+				fieldValues[fieldErrorMsg] = "Userid does not match your client certificate."
+			} else if isLockedOut(username) {
+				// Locked accounts are rejected even with the correct password
+				log.Printf("SECURITY: rejected login for locked out user %s from %s", username, session.remoteAddr)
+				fieldValues[fieldErrorMsg] = "Account temporarily locked. Please try again later."
+			} else if user, authenticated := authenticateLogin(config, conn, username, password); authenticated {
+				// Users with a TOTP secret must also pass the second factor
+				if user.TOTPSecret != "" {
+					if err := HandleTOTP(conn, config, user); err != nil {
+						return nil, err
+					}
+				}
+
+				resetFailedLogins(username)
+				auditLog("LOGIN", "result=success user=%q ip=%s", username, session.remoteAddr)
+				hookLogin(config, username, session.remoteAddr)
+
+				// Expired passwords have to be changed before going any further
+				if user.MustChangePassword {
+					if err := HandlePasswordChange(conn, config, user.Username); err != nil {
+						return nil, err
+					}
+				}
+
 				session.authenticated = true
 				session.username = username
-				session.hostFile = hostFile
+				session.hostFile = user.HostFile
+				session.hostFiles = user.HostFiles
+				session.allowedHosts = user.AllowedHosts
+				session.admin = user.Admin
+				session.record = user.Record
+				session.readOnly = user.ReadOnly
+				session.autoConnect = user.AutoConnect
+				session.afterHost = user.AfterHost
+				session.loginTime = time.Now()
+				if lastLoginEnabled() {
+					session.previousLogin = recordLogin(user.Username, session.remoteAddr, session.loginTime, config.CaseSensitiveUsers)
+					if previous := session.previousLogin; previous != nil {
+						log.Printf("User %s logged on from %s, previous logon %s from %s", username, session.remoteAddr,
+							previous.Time.Format(time.RFC3339), previous.Source)
+					} else {
+						log.Printf("User %s logged on from %s for the first time", username, session.remoteAddr)
+					}
+				}
 				return session, nil
+			} else {
+				auditLog("LOGIN", "result=failure user=%q ip=%s", username, session.remoteAddr)
+				log.Printf("Failed login for user %s from %s", username, session.remoteAddr)
+
+				if recordFailedLogin(username, config) {
+					fieldValues[fieldErrorMsg] = "Account temporarily locked. Please try again later."
+				} else {
+					// Show invalid credentials message in the error field
+					fieldValues[fieldErrorMsg] = "Invalid userid or password. Please try again."
+				}
 			}
 
-			// Show invalid credentials message in the error field
-			fieldValues[fieldErrorMsg] = "Invalid userid or password. Please try again."
+			// Don't let a connection sit on the logon screen guessing forever
+			failedAttempts++
+			if config.MaxLoginAttempts > 0 && failedAttempts >= config.MaxLoginAttempts {
+				log.Printf("SECURITY: %d failed login attempts from %s, disconnecting", failedAttempts, session.remoteAddr)
+				auditLog("LOGIN_LIMIT", "ip=%s attempts=%d", session.remoteAddr, failedAttempts)
+				showDisconnectScreen(conn, config, "Too many failed login attempts.")
+				return nil, fmt.Errorf("too many failed login attempts from %s", session.remoteAddr)
+			}
 		}
 	}
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// A bcrypt hash with "/" in its salt and digest
+const slashHash = "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p9/ldGxad68LJZdL17lh/y"
+
+func TestSplitUserFields(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		n    int
+		want []string
+	}{
+		{"plain", "jdoe/secret/jdoe.list", 7, []string{"jdoe", "secret", "jdoe.list"}},
+		{"username only", "jdoe", 7, []string{"jdoe"}},
+		{"empty fields", "jdoe/secret//JBSWY3DP", 7, []string{"jdoe", "secret", "", "JBSWY3DP"}},
+		{"bcrypt hash", "jdoe/" + slashHash + "/jdoe.list", 7, []string{"jdoe", slashHash, "jdoe.list"}},
+		{"bcrypt hash at the end", "jdoe/" + slashHash, 7, []string{"jdoe", slashHash}},
+		{"bcrypt hash to change", "jdoe/!" + slashHash + "/jdoe.list", 7, []string{"jdoe", "!" + slashHash, "jdoe.list"}},
+		{"$2b$ and $2y$ hashes", "a/$2b$" + slashHash[4:] + "/$2y$" + slashHash[4:], 7, []string{"a", "$2b$" + slashHash[4:], "$2y$" + slashHash[4:]}},
+		{"too short for a hash", "jdoe/$2a$10$abc/def", 7, []string{"jdoe", "$2a$10$abc", "def"}},
+		{"quoted field", `jdoe/"@file:/run/secrets/jdoe"/jdoe.list`, 7, []string{"jdoe", "@file:/run/secrets/jdoe", "jdoe.list"}},
+		{"quoted field at the end", `jdoe/secret/"/etc/hosts/jdoe.list"`, 7, []string{"jdoe", "secret", "/etc/hosts/jdoe.list"}},
+		{"quote inside a field", `jdoe/se"cr/et`, 7, []string{"jdoe", `se"cr`, "et"}},
+		{"unterminated quote", `jdoe/"secret/x`, 7, []string{"jdoe", `"secret`, "x"}},
+		{"limit keeps the rest", "jdoe/" + slashHash + "/a/b/c", 3, []string{"jdoe", slashHash, "a/b/c"}},
+		{"limit of one", "jdoe/secret", 1, []string{"jdoe/secret"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitUserFields(tt.line, tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitUserFields(%q, %d) = %q, want %q", tt.line, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+// inDir runs the rest of a test in dir, where relative host files are found
+func inDir(t *testing.T, dir string) {
+	t.Helper()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+}
+
+func TestUserFieldsLegacyHostFile(t *testing.T) {
+	dir := t.TempDir()
+	inDir(t, dir)
+	for _, name := range []string{"hosts/jdoe.list", "hosts/common.list", "hosts/JBSWY3DPEHPK3PXP/x.list"} {
+		os.MkdirAll(filepath.Dir(name), 0755)
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	absolute := filepath.Join(dir, "hosts/jdoe.list")
+
+	tests := []struct {
+		name   string
+		line   string
+		want   []string
+		legacy bool
+	}{
+		{"host file with a /", "jdoe/pw/hosts/jdoe.list", []string{"jdoe", "pw", "hosts/jdoe.list"}, true},
+		{"absolute host file", "jdoe/pw/" + absolute, []string{"jdoe", "pw", absolute}, true},
+		{"several host files", "jdoe/pw/hosts/jdoe.list,hosts/common.list", []string{"jdoe", "pw", "hosts/jdoe.list,hosts/common.list"}, true},
+		{"bcrypt hash", "jdoe/" + slashHash + "/hosts/jdoe.list", []string{"jdoe", slashHash, "hosts/jdoe.list"}, true},
+		{"quoted host file", `jdoe/pw/"hosts/jdoe.list"/JBSWY3DPEHPK3PXP`, []string{"jdoe", "pw", "hosts/jdoe.list", "JBSWY3DPEHPK3PXP"}, false},
+		{"directory that reads as a secret", "jdoe/pw/hosts/JBSWY3DPEHPK3PXP/x.list", []string{"jdoe", "pw", "hosts", "JBSWY3DPEHPK3PXP", "x.list"}, false},
+		{"TOTP secret", "jdoe/pw/jdoe.list/JBSWY3DPEHPK3PXP", []string{"jdoe", "pw", "jdoe.list", "JBSWY3DPEHPK3PXP"}, false},
+		{"all fields", "jdoe/pw/jdoe.list/JBSWY3DPEHPK3PXP/mvs1/admin,afterhost=menu/" + slashHash, []string{"jdoe", "pw", "jdoe.list", "JBSWY3DPEHPK3PXP", "mvs1", "admin,afterhost=menu", slashHash}, false},
+		{"flags without a secret", "jdoe/pw/jdoe.list//mvs1/readonly", []string{"jdoe", "pw", "jdoe.list", "", "mvs1", "readonly"}, false},
+		// No such host file, so a bad secret must not be dropped
+		{"bad TOTP secret", "jdoe/pw/jdoe.list/not-base32", []string{"jdoe", "pw", "jdoe.list", "not-base32"}, false},
+		{"missing host file with a /", "jdoe/pw/hosts/nosuch.list", []string{"jdoe", "pw", "hosts", "nosuch.list"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, legacy := userFields(tt.line)
+			if !reflect.DeepEqual(got, tt.want) || legacy != tt.legacy {
+				t.Errorf("userFields(%q) = %q, %v, want %q, %v", tt.line, got, legacy, tt.want, tt.legacy)
+			}
+		})
+	}
+
+	// The whole users file reads the same way
+	if err := os.WriteFile("users.cnf", []byte("jdoe/pw/hosts/jdoe.list\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := loadUsersFile("users.cnf")
+	if err != nil {
+		t.Fatalf("loadUsersFile: %v", err)
+	}
+	if len(users) != 1 || users[0].HostFile != "hosts/jdoe.list" || users[0].TOTPSecret != "" {
+		t.Errorf("loadUsersFile = %+v", users)
+	}
+}
+
+// Logins during reloads must always see one whole users list, never a mix
+// of two. Run with -race.
+func TestAuthenticateUserDuringReload(t *testing.T) {
+	inDir(t, t.TempDir())
+	previous := authUsers.Load()
+	t.Cleanup(func() { authUsers.Store(previous) })
+
+	versions := []string{
+		"jdoe/one/one.list\nalice/one/one.list\n",
+		"jdoe/two/two.list\nalice/two/two.list\nbob/two/two.list\n",
+	}
+	config := &Config{}
+	if err := os.WriteFile(usersFile, []byte(versions[0]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadAuthConfig("secure3270.cnf", config); err != nil {
+		t.Fatalf("LoadAuthConfig: %v", err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for _, password := range []string{"one", "two"} {
+					user, ok := authenticateUser("jdoe", password)
+					if ok && user.HostFile != password+".list" {
+						t.Errorf("jdoe logged in with %s but got host file %s", password, user.HostFile)
+					}
+				}
+				users := currentUsers()
+				if n := len(users); n != 2 && n != 3 {
+					t.Errorf("users list has %d entries", n)
+				}
+				for _, user := range users {
+					if user.HostFile != users[0].HostFile {
+						t.Errorf("users list mixes %s and %s", users[0].HostFile, user.HostFile)
+					}
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		if err := os.WriteFile(usersFile+".tmp", []byte(versions[i%2]), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(usersFile+".tmp", usersFile); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadAuthConfig("secure3270.cnf", config); err != nil {
+			t.Errorf("LoadAuthConfig: %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+// The break-glass login is an emergency admin account that doesn't live in
+// users.cnf, so a broken or empty users file can still be fixed from a
+// terminal. It is only accepted over TLS or from admincidrs, and every use
+// is logged loudly.
+
+// breakGlassCredential returns the emergency userid and password (or bcrypt
+// hash) from breakglass, or from breakglassfile. ok is false when neither
+// is configured.
+func breakGlassCredential(config *Config) (username, password string, ok bool, err error) {
+	value := config.BreakGlass
+	if value == "" && config.BreakGlassFile != "" {
+		info, err := os.Stat(config.BreakGlassFile)
+		if err != nil {
+			return "", "", false, fmt.Errorf("break-glass file: %v", err)
+		}
+		if info.Mode().Perm()&0077 != 0 {
+			return "", "", false, fmt.Errorf("break-glass file %s must not be readable by group or others (mode %o)",
+				config.BreakGlassFile, info.Mode().Perm())
+		}
+		data, err := os.ReadFile(config.BreakGlassFile)
+		if err != nil {
+			return "", "", false, fmt.Errorf("break-glass file: %v", err)
+		}
+		value = strings.TrimSpace(string(data))
+	}
+	if value == "" {
+		return "", "", false, nil
+	}
+
+	username, password, found := strings.Cut(value, "/")
+	username, password = strings.TrimSpace(username), strings.TrimSpace(password)
+	if !found || username == "" || password == "" {
+		return "", "", false, fmt.Errorf("break-glass credential must be userid/password")
+	}
+	return username, password, true, nil
+}
+
+// breakGlassConfigured reports whether an emergency login is set up, so a
+// missing users.cnf doesn't have to stop the proxy from starting
+func breakGlassConfigured(config *Config) bool {
+	return config.BreakGlass != "" || config.BreakGlassFile != ""
+}
+
+// authenticateBreakGlass checks a login against the break-glass account. It
+// is only consulted when the users file didn't match.
+func authenticateBreakGlass(config *Config, conn net.Conn, username, password string) (User, bool) {
+	bgUser, bgPassword, ok, err := breakGlassCredential(config)
+	if err != nil {
+		log.Printf("SECURITY: break-glass login unavailable: %v", err)
+		return User{}, false
+	}
+	if !ok || !sameUsername(username, bgUser, config.CaseSensitiveUsers) || !checkPassword(bgPassword, password) {
+		return User{}, false
+	}
+
+	_, isTLS := conn.(*tls.Conn)
+	if !isTLS && !ipInNetworks(config.AdminCIDRs, conn.RemoteAddr()) {
+		log.Printf("SECURITY: refused BREAK-GLASS login for %s from %s: only allowed over TLS or from admincidrs",
+			username, conn.RemoteAddr())
+		auditLog("BREAKGLASS", "result=refused user=%q ip=%s", username, conn.RemoteAddr())
+		return User{}, false
+	}
+
+	log.Printf("SECURITY: *** BREAK-GLASS login as %s from %s (tls=%t) ***", username, conn.RemoteAddr(), isTLS)
+	auditLog("BREAKGLASS", "result=success user=%q ip=%s tls=%t", username, conn.RemoteAddr(), isTLS)
+	return User{Username: bgUser, HostFile: config.HostFile, Admin: true}, true
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// serverCertificate is the certificate of one or more TLS listeners.
+// Handshakes read it through getCertificate, so a renewed certificate can be
+// swapped in on SIGHUP while established connections keep the one they
+// negotiated.
+type serverCertificate struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+var (
+	serverCerts     = make(map[[2]string]*serverCertificate) // By certificate and key file
+	serverCertsLock sync.Mutex
+)
+
+// loadServerCertificate reads and checks a certificate and key pair
+func loadServerCertificate(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificates: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse TLS certificate %s: %v", certFile, err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		log.Printf("Warning: TLS certificate %s expired on %s", certFile, leaf.NotAfter.Format(time.RFC3339))
+	}
+	cert.Leaf = leaf
+	return &cert, nil
+}
+
+// useServerCertificate loads a listener's certificate and key pair.
+// Listeners using the same files share one serverCertificate, so it is only
+// reloaded once.
+func useServerCertificate(certFile, keyFile string) (*serverCertificate, error) {
+	cert, err := loadServerCertificate(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	serverCertsLock.Lock()
+	defer serverCertsLock.Unlock()
+
+	sc := serverCerts[[2]string{certFile, keyFile}]
+	if sc == nil {
+		sc = &serverCertificate{certFile: certFile, keyFile: keyFile}
+		serverCerts[[2]string{certFile, keyFile}] = sc
+	}
+	sc.cert.Store(cert)
+	return sc, nil
+}
+
+// sniCert is a certificate that is used when the client asks for its server
+// name (SNI) in the TLS handshake
+type sniCert struct {
+	ServerName string // Lower case; *.example.com matches any single label in front
+	CertFile   string
+	KeyFile    string
+}
+
+// parseSNICerts parses the tlssnicerts list of servername:certfile:keyfile
+// entries
+func parseSNICerts(value string) ([]sniCert, error) {
+	var certs []sniCert
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%q is not servername:certfile:keyfile", entry)
+		}
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+			if parts[i] == "" {
+				return nil, fmt.Errorf("%q is not servername:certfile:keyfile", entry)
+			}
+		}
+		certs = append(certs, sniCert{
+			ServerName: strings.ToLower(strings.TrimSuffix(parts[0], ".")),
+			CertFile:   parts[1],
+			KeyFile:    parts[2],
+		})
+	}
+	return certs, nil
+}
+
+// listenerCertificates are the certificates of one TLS listener: one per
+// configured server name, and the tlscert/tlskey pair for clients that ask
+// for another name or none at all
+type listenerCertificates struct {
+	byName   map[string]*serverCertificate
+	fallback *serverCertificate // nil if there is no default certificate
+}
+
+// loadListenerCertificates loads the default and SNI certificates of a listener
+func loadListenerCertificates(config *Config) (*listenerCertificates, error) {
+	certs := &listenerCertificates{byName: make(map[string]*serverCertificate)}
+	if config.TLSCert != "" && config.TLSKey != "" {
+		sc, err := useServerCertificate(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		certs.fallback = sc
+	}
+	for _, entry := range config.TLSSNICerts {
+		sc, err := useServerCertificate(entry.CertFile, entry.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", entry.ServerName, err)
+		}
+		certs.byName[entry.ServerName] = sc
+	}
+	if certs.fallback == nil && len(certs.byName) == 0 {
+		return nil, fmt.Errorf("no TLS certificate configured")
+	}
+	return certs, nil
+}
+
+// getCertificate is the listener's tls.Config.GetCertificate. It picks the
+// certificate for the server name the client asked for, falling back to the
+// default one. Without a default, clients that ask for an unknown name or
+// none are refused in the handshake.
+func (lc *listenerCertificates) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	sc := lc.byName[name]
+	if sc == nil && name != "" {
+		if i := strings.IndexByte(name, '.'); i > 0 {
+			sc = lc.byName["*"+name[i:]]
+		}
+	}
+	if len(lc.byName) > 0 {
+		switch {
+		case sc != nil:
+			log.Printf("TLS client %s asked for server name %q, using certificate %s", hello.Conn.RemoteAddr(), hello.ServerName, sc.certFile)
+		case lc.fallback != nil:
+			log.Printf("TLS client %s asked for server name %q, using the default certificate", hello.Conn.RemoteAddr(), hello.ServerName)
+		}
+	}
+	if sc == nil {
+		sc = lc.fallback
+	}
+	if sc == nil {
+		if name == "" {
+			return nil, fmt.Errorf("client sent no server name and there is no default certificate")
+		}
+		return nil, fmt.Errorf("no certificate for server name %q", hello.ServerName)
+	}
+
+	cert := sc.cert.Load()
+	if cert == nil {
+		return nil, fmt.Errorf("no TLS certificate loaded")
+	}
+	return cert, nil
+}
+
+// reloadServerCertificates loads the certificates of all running TLS
+// listeners again and uses them for new handshakes. A certificate that fails
+// to load is not used; the previous one stays in place.
+func reloadServerCertificates() {
+	serverCertsLock.Lock()
+	certs := make([]*serverCertificate, 0, len(serverCerts))
+	for _, sc := range serverCerts {
+		certs = append(certs, sc)
+	}
+	serverCertsLock.Unlock()
+
+	for _, sc := range certs {
+		cert, err := loadServerCertificate(sc.certFile, sc.keyFile)
+		if err != nil {
+			log.Printf("Failed to reload TLS certificate, keeping the current one: %v", err)
+			continue
+		}
+		sc.cert.Store(cert)
+		log.Printf("Reloaded TLS certificate %s (subject %s, valid until %s)",
+			sc.certFile, cert.Leaf.Subject.CommonName, cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+}
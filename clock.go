@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/racingmars/go3270"
@@ -145,20 +146,43 @@ var bigColon = []string{
 	" ",
 }
 
-// Refresh interval for the clock (1.2 seconds)
-const clockRefreshInterval = 1200 * time.Millisecond
+// Default and smallest allowed refresh interval for the clock
+const (
+	defaultClockRefresh = 1200 * time.Millisecond
+	minClockRefresh     = 250 * time.Millisecond
+)
 
-// Timezone names for display and cycling
-var timezoneNames = []string{
-	"UTC",
-	"New York",
-	"London",
-	"Rome",
-	"Tokyo",
+// clockRefreshInterval returns the configured clock refresh interval
+func clockRefreshInterval(config *Config) time.Duration {
+	if config.ClockRefreshMs > 0 {
+		return time.Duration(config.ClockRefreshMs) * time.Millisecond
+	}
+	return defaultClockRefresh
+}
+
+// nextClockRefresh works out when the clock should be redrawn next. Intervals
+// of a second or more are aligned to the start of a wall-clock second, so the
+// seconds on screen tick over in step with real time instead of drifting.
+func nextClockRefresh(now time.Time, interval time.Duration) time.Time {
+	if interval < time.Second {
+		return now.Add(interval)
+	}
+	// Redraw just after the second boundary so the new second is shown
+	next := now.Add(interval).Truncate(time.Second).Add(20 * time.Millisecond)
+	if !next.After(now) {
+		next = next.Add(time.Second)
+	}
+	return next
+}
+
+// clockZone is a timezone shown on the clock screen
+type clockZone struct {
+	name     string
+	location *time.Location
 }
 
-// Location objects for the different timezones
-var timezoneLocations = []string{
+// Default timezones for cycling with F11
+var defaultClockZones = []string{
 	"UTC",
 	"America/New_York",
 	"Europe/London",
@@ -166,6 +190,73 @@ var timezoneLocations = []string{
 	"Asia/Tokyo",
 }
 
+// Default cities for the world time footer
+var defaultClockFooterZones = []clockZone{
+	{name: "NY", location: mustLoadLocation("America/New_York")},
+	{name: "London", location: mustLoadLocation("Europe/London")},
+	{name: "Rome", location: mustLoadLocation("Europe/Rome")},
+	{name: "Tokyo", location: mustLoadLocation("Asia/Tokyo")},
+}
+
+// mustLoadLocation loads a timezone for the built-in defaults, falling back
+// to UTC if the system has no timezone database
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// zoneDisplayName turns a zone name like "America/New_York" into "New York"
+func zoneDisplayName(zone string) string {
+	if idx := strings.LastIndex(zone, "/"); idx >= 0 {
+		zone = zone[idx+1:]
+	}
+	return strings.ReplaceAll(zone, "_", " ")
+}
+
+// parseClockZones parses a comma separated list of IANA timezone names,
+// validating each one with time.LoadLocation
+func parseClockZones(value string) ([]clockZone, error) {
+	var zones []clockZone
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", name, err)
+		}
+		zones = append(zones, clockZone{name: zoneDisplayName(name), location: loc})
+	}
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("no timezones listed")
+	}
+	return zones, nil
+}
+
+// clockZones returns the configured cycle list or the defaults
+func clockZones(config *Config) []clockZone {
+	if len(config.ClockZones) > 0 {
+		return config.ClockZones
+	}
+	zones, _ := parseClockZones(strings.Join(defaultClockZones, ","))
+	if len(zones) == 0 {
+		zones = []clockZone{{name: "UTC", location: time.UTC}}
+	}
+	return zones
+}
+
+// clockFooterZones returns the configured footer cities or the defaults
+func clockFooterZones(config *Config) []clockZone {
+	if len(config.ClockFooterZones) > 0 {
+		return config.ClockFooterZones
+	}
+	return defaultClockFooterZones
+}
+
 // ASCII Art IBM logo for display at the top of each hour
 var ibmLogo = []string{
 	"IIIIIIIIIII  BBBBBBBBBBBB      MMMMMMMM      MMMMMMMM",
@@ -178,46 +269,65 @@ var ibmLogo = []string{
 	"IIIIIIIIIII  BBBBBBBBBBBB      MMMMMM     M    MMMMMM",
 }
 
+// clockSessionLine tells a user watching the clock whether the session is
+// encrypted and how many host sessions it has had
+func clockSessionLine(authSession *authSession) string {
+	connection := "Not encrypted"
+	if state := authSession.tlsState; state != nil {
+		connection = "Encrypted (TLS " + tlsVersionToString(state.Version) + ")"
+	}
+
+	sessions := fmt.Sprintf("%d host sessions", authSession.hostSessions)
+	if authSession.hostSessions == 1 {
+		sessions = "1 host session"
+	}
+
+	return fmt.Sprintf("%s - %s - logged on for %s", connection, sessions,
+		time.Since(authSession.loginTime).Round(time.Minute))
+}
+
 // Function to draw a big clock screen
-func ShowClock(conn net.Conn, username string) error {
+func ShowClock(conn net.Conn, config *Config, authSession *authSession) error {
+	username := authSession.username
+
+	// Keys go on the second last row
+	keyRow := screenRows - 2
+
 	// Keep track of logo test mode and timezone
 	showLogoTest := false
 	currentTimezone := 0
+	zones := clockZones(config)
+	footerZones := clockFooterZones(config)
 
 	// Function to create a fresh screen with the latest time
 	createScreen := func() go3270.Screen {
 		// Get current time in the selected timezone
-		now := time.Now().UTC() // Start with UTC
-
-		// Apply the selected timezone
-		if currentTimezone > 0 && currentTimezone < len(timezoneLocations) {
-			loc, err := time.LoadLocation(timezoneLocations[currentTimezone])
-			if err == nil {
-				now = time.Now().In(loc)
-			}
-		}
+		now := time.Now().In(zones[currentTimezone].location)
 
 		// Format time for display
 		currentTime := now.Format("15:04:05")
+		if config.Clock12Hour {
+			currentTime = now.Format("03:04:05")
+		}
 
 		// Create screen
 		screen := go3270.Screen{}
 
 		// Add timezone indicator and username at the top (centered)
-		tzName := timezoneNames[currentTimezone]
+		tzName := zones[currentTimezone].name
 		tzTitle := fmt.Sprintf("Secure3270Proxy Clock - User: %s - Timezone: %s", username, tzName)
 		screen = append(screen, go3270.Field{
 			Row:     0,
-			Col:     getCenteredPosition(tzTitle, 79),
+			Col:     getCenteredPosition(tzTitle, screenColumns-1),
 			Content: tzTitle,
-			Color:   go3270.Turquoise,
+			Color:   config.Theme.title(go3270.Turquoise),
 			Intense: true,
 		})
 
 		// Calculate position to center the clock
 		// Each digit is 8 chars wide, colon is 1 char wide, total is 8*6 + 1*2 = 50 for HH:MM:SS
 		clockWidth := 50
-		startCol := (79-clockWidth)/2 - 7 // Shift 7 columns to the left
+		startCol := (screenColumns-1-clockWidth)/2 - 7 // Shift 7 columns to the left
 
 		// Draw the big clock - starts at row 1
 		startRow := 1
@@ -242,14 +352,19 @@ func ShowClock(conn net.Conn, username string) error {
 		case 6:
 			digitColor = go3270.Yellow
 		}
+		if config.ClockColor != nil {
+			// clockcolor picks one color for good
+			digitColor = *config.ClockColor
+		}
 
-		// Check if we should show the IBM logo (top of hour or test mode)
-		isTopOfHour := now.Minute() == 0 && now.Second() < 30
+		// Check if we should show the IBM logo (top of hour, unless
+		// clocklogo=false, or test mode)
+		isTopOfHour := now.Minute() == 0 && now.Second() < 30 && !config.ClockNoLogo
 		showLogo := isTopOfHour || showLogoTest
 
 		if showLogo {
 			// Display IBM logo instead of time digits
-			logoCol := (79 - len(ibmLogo[0])) / 2 // Center the logo horizontally
+			logoCol := (screenColumns - 1 - len(ibmLogo[0])) / 2 // Center the logo horizontally
 			for i, line := range ibmLogo {
 				screen = append(screen, go3270.Field{
 					Row:     startRow + i + 1, // Position logo with a bit of spacing
@@ -263,7 +378,7 @@ func ShowClock(conn net.Conn, username string) error {
 			// If in test mode, show an indicator
 			if showLogoTest && !isTopOfHour {
 				screen = append(screen, go3270.Field{
-					Row:     20,
+					Row:     keyRow - 2,
 					Col:     15,
 					Content: "Logo test mode (Press F12 again to exit test mode)",
 					Color:   go3270.Blue,
@@ -315,21 +430,28 @@ func ShowClock(conn net.Conn, username string) error {
 			}
 		}
 
-		// Add world time information below the clock or logo
-		// Add world times - split into two rows for better fit
-		// Get times for different cities
-		nyLocation, _ := time.LoadLocation("America/New_York")
-		londonLocation, _ := time.LoadLocation("Europe/London")
-		romeLocation, _ := time.LoadLocation("Europe/Rome")
-		tokyoLocation, _ := time.LoadLocation("Asia/Tokyo")
-
-		nyTime := time.Now().In(nyLocation).Format("15:04")
-		londonTime := time.Now().In(londonLocation).Format("15:04")
-		romeTime := time.Now().In(romeLocation).Format("15:04")
-		tokyoTime := time.Now().In(tokyoLocation).Format("15:04")
+		// Show AM/PM to the right of the digits in 12-hour mode
+		if config.Clock12Hour && !showLogo {
+			screen = append(screen, go3270.Field{
+				Row:     startRow + len(bigDigits[0]) - 1,
+				Col:     startCol + clockWidth + 2,
+				Content: now.Format("PM"),
+				Color:   digitColor,
+				Intense: true,
+			})
+		}
 
-		worldTimeStr1 := fmt.Sprintf("NY: %s  London: %s", nyTime, londonTime)
-		worldTimeStr2 := fmt.Sprintf("Rome: %s  Tokyo: %s", romeTime, tokyoTime)
+		// Add world time information below the clock or logo
+		// Add world times - two cities per row for better fit
+		var worldTimeLines []string
+		for i := 0; i < len(footerZones) && len(worldTimeLines) < 4; i += 2 {
+			line := fmt.Sprintf("%s: %s", footerZones[i].name, time.Now().In(footerZones[i].location).Format("15:04"))
+			if i+1 < len(footerZones) {
+				line += fmt.Sprintf("  %s: %s", footerZones[i+1].name,
+					time.Now().In(footerZones[i+1].location).Format("15:04"))
+			}
+			worldTimeLines = append(worldTimeLines, line)
+		}
 
 		var worldTimeRow int
 		if showLogo {
@@ -338,47 +460,51 @@ func ShowClock(conn net.Conn, username string) error {
 			worldTimeRow = startRow + 11 // After the clock digits (9 rows tall)
 		}
 
-		screen = append(screen, go3270.Field{
-			Row:     worldTimeRow,
-			Col:     getCenteredPosition(worldTimeStr1, 79),
-			Content: worldTimeStr1,
-			Color:   go3270.Green,
-		})
-
-		screen = append(screen, go3270.Field{
-			Row:     worldTimeRow + 1,
-			Col:     getCenteredPosition(worldTimeStr2, 79),
-			Content: worldTimeStr2,
-			Color:   go3270.Green,
-		})
+		for i, line := range worldTimeLines {
+			screen = append(screen, go3270.Field{
+				Row:     worldTimeRow + i,
+				Col:     getCenteredPosition(line, screenColumns-1),
+				Content: line,
+				Color:   go3270.Green,
+			})
+		}
 
 		// Add date at the bottom
 		dateFormat := now.Format("Monday, January 2, 2006")
 		dateStr := fmt.Sprintf("Date: %s", dateFormat)
 		screen = append(screen, go3270.Field{
-			Row:     worldTimeRow + 3,
-			Col:     getCenteredPosition(dateStr, 79),
+			Row:     worldTimeRow + len(worldTimeLines) + 1,
+			Col:     getCenteredPosition(dateStr, screenColumns-1),
 			Content: dateStr,
 			Color:   go3270.Turquoise,
 		})
 
+		// Session details under the date
+		sessionLine := clockSessionLine(authSession)
+		screen = append(screen, go3270.Field{
+			Row:     worldTimeRow + len(worldTimeLines) + 2,
+			Col:     getCenteredPosition(sessionLine, screenColumns-1),
+			Content: sessionLine,
+			Color:   go3270.Blue,
+		})
+
 		// Add function key legends at the bottom
 		screen = append(screen, go3270.Field{
-			Row:     22,
+			Row:     keyRow,
 			Col:     2,
 			Content: "F3=Return to Host Menu",
 			Color:   go3270.Blue,
 		})
 
 		screen = append(screen, go3270.Field{
-			Row:     22,
+			Row:     keyRow,
 			Col:     25,
 			Content: "F11=Cycle Timezone",
 			Color:   go3270.Blue,
 		})
 
 		screen = append(screen, go3270.Field{
-			Row:     22,
+			Row:     keyRow,
 			Col:     45,
 			Content: "F12=Display IBM Logo",
 			Color:   go3270.Blue,
@@ -387,31 +513,18 @@ func ShowClock(conn net.Conn, username string) error {
 		return screen
 	}
 
-	// Function to update the screen without waiting for input
-	updateScreenNoWait := func() error {
-		screen := createScreen()
-
-		// Show the screen but don't wait for a response
-		_, err := go3270.ShowScreenOpts(screen, nil, conn,
-			go3270.ScreenOpts{
-				CursorRow:  22,
-				CursorCol:  40,
-				NoResponse: true,
-			})
-		return err
-	}
-
-	// Get input with a timeout for auto-refresh
-	getInputWithTimeout := func(timeoutMs int) (go3270.Response, error, bool) {
+	// Show the screen and wait for input until the deadline, which is how
+	// the clock gets redrawn on a timer
+	getInputUntil := func(deadline time.Time) (go3270.Response, error, bool) {
 		screen := createScreen()
 
 		// Set a timeout on the connection to implement non-blocking IO
-		conn.SetReadDeadline(time.Now().Add(time.Millisecond * time.Duration(timeoutMs)))
+		conn.SetReadDeadline(deadline)
 
 		// Show screen and try to get input (might timeout)
 		response, err := go3270.ShowScreenOpts(screen, nil, conn,
 			go3270.ScreenOpts{
-				CursorRow:  22,
+				CursorRow:  keyRow,
 				CursorCol:  40,
 				NoResponse: false,
 			})
@@ -429,79 +542,52 @@ func ShowClock(conn net.Conn, username string) error {
 		return response, err, timeout
 	}
 
-	// Initial screen update
-	if err := updateScreenNoWait(); err != nil {
-		return fmt.Errorf("error showing initial clock screen: %v", err)
-	}
-
-	// Main clock loop
-	lastRefreshTime := time.Now()
-	timeoutMs := int(clockRefreshInterval / time.Millisecond)
-
-	// Skip immediate refresh on first loop to avoid double refresh
-	skipImmediate := true
+	// Main clock loop. Every pass redraws the screen, either because the
+	// refresh deadline passed or because a key changed what is shown. The
+	// idle timeout and session limit still apply while the clock ticks.
+	interval := clockRefreshInterval(config)
+	nextRefresh := nextClockRefresh(time.Now(), interval)
+	lastInput := time.Now()
 
 	for {
-		// Try to get input with timeout
-		response, err, timeout := getInputWithTimeout(timeoutMs)
+		response, err, timeout := getInputUntil(screenDeadline(config, authSession, nextRefresh, lastInput))
 		if err != nil {
 			return fmt.Errorf("error getting input: %v", err)
 		}
 
-		// If we got user input, process it
-		if !timeout {
-			switch response.AID {
-			case go3270.AIDPF3:
-				// Return to main menu
+		if timeout {
+			if sessionExpired(config, authSession) {
 				return nil
-
-			case go3270.AIDPF11:
-				// Cycle to the next timezone
-				currentTimezone = (currentTimezone + 1) % len(timezoneNames)
-				// Reset refresh timer
-				lastRefreshTime = time.Now()
-				// Update screen immediately
-				if err := updateScreenNoWait(); err != nil {
-					return fmt.Errorf("error updating clock after F11: %v", err)
-				}
-				continue
-
-			case go3270.AIDPF12:
-				// Toggle logo test mode
-				showLogoTest = !showLogoTest
-				// Reset refresh timer
-				lastRefreshTime = time.Now()
-				// Update screen immediately
-				if err := updateScreenNoWait(); err != nil {
-					return fmt.Errorf("error updating clock after F12: %v", err)
-				}
-				continue
 			}
+			if idleExpired(config, lastInput) {
+				return errSessionIdle
+			}
+			nextRefresh = nextClockRefresh(time.Now(), interval)
+			continue
 		}
+		lastInput = time.Now()
 
-		// Check if it's time to refresh the clock
-		elapsedTime := time.Since(lastRefreshTime)
+		switch response.AID {
+		case go3270.AIDPF3:
+			// Return to main menu
+			return nil
 
-		// Only refresh if enough time has passed and we're not skipping the immediate refresh
-		if (timeout || elapsedTime >= clockRefreshInterval) && !skipImmediate {
-			// Reset refresh time BEFORE updating to avoid time drift
-			lastRefreshTime = time.Now()
+		case go3270.AIDPF11:
+			// Cycle to the next timezone
+			currentTimezone = (currentTimezone + 1) % len(zones)
 
-			// Update the clock display
-			if err := updateScreenNoWait(); err != nil {
-				return fmt.Errorf("error updating clock: %v", err)
-			}
-		}
-
-		// Clear the skip flag after first iteration
-		if skipImmediate {
-			skipImmediate = false
+		case go3270.AIDPF12:
+			// Toggle logo test mode
+			showLogoTest = !showLogoTest
 		}
 	}
 }
 
 // ShowClockWithLogo shows the clock screen with the IBM logo already displayed
-func ShowClockWithLogo(conn net.Conn, username string) error {
+func ShowClockWithLogo(conn net.Conn, config *Config, authSession *authSession) error {
+	username := authSession.username
+	keyRow := screenRows - 2
+
 	// Function to create a screen with the IBM logo displayed
 	createScreen := func() go3270.Screen {
 		// Create screen
@@ -511,14 +597,14 @@ func ShowClockWithLogo(conn net.Conn, username string) error {
 		tzTitle := fmt.Sprintf("Secure3270Proxy - IBM Logo - User: %s", username)
 		screen = append(screen, go3270.Field{
 			Row:     0,
-			Col:     getCenteredPosition(tzTitle, 79),
+			Col:     getCenteredPosition(tzTitle, screenColumns-1),
 			Content: tzTitle,
 			Color:   go3270.Turquoise,
 			Intense: true,
 		})
 
 		// Display IBM logo
-		logoCol := (79 - len(ibmLogo[0])) / 2 // Center the logo horizontally
+		logoCol := (screenColumns - 1 - len(ibmLogo[0])) / 2 // Center the logo horizontally
 		for i, line := range ibmLogo {
 			screen = append(screen, go3270.Field{
 				Row:     5 + i, // Position logo in the middle of screen
@@ -531,7 +617,7 @@ func ShowClockWithLogo(conn net.Conn, username string) error {
 
 		// Add key hint at bottom
 		screen = append(screen, go3270.Field{
-			Row:     22,
+			Row:     keyRow,
 			Col:     2,
 			Content: "Press F3 to return to Host Menu",
 			Color:   go3270.Blue,
@@ -542,7 +628,17 @@ func ShowClockWithLogo(conn net.Conn, username string) error {
 
 	// Show the IBM logo screen
 	screen := createScreen()
-	response, err := go3270.ShowScreen(screen, nil, 22, 2, conn)
+	if deadline := screenDeadline(config, authSession, time.Time{}, time.Now()); !deadline.IsZero() {
+		conn.SetReadDeadline(deadline)
+	}
+	response, err := go3270.ShowScreen(screen, nil, keyRow, 2, conn)
+	conn.SetReadDeadline(time.Time{})
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		if sessionExpired(config, authSession) {
+			return nil
+		}
+		return errSessionIdle
+	}
 	if err != nil {
 		return fmt.Errorf("error showing IBM logo: %v", err)
 	}
@@ -553,5 +649,5 @@ func ShowClockWithLogo(conn net.Conn, username string) error {
 	}
 
 	// Otherwise, show the regular clock screen with logo mode enabled
-	return ShowClock(conn, username)
+	return ShowClock(conn, config, authSession)
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultDialBackoff = 500 * time.Millisecond
+	maxDialBackoff     = 10 * time.Second
+	// A retrying dial never takes longer than this in total (or one dial
+	// timeout, if that is longer), so a user isn't left waiting forever
+	maxDialDuration = 60 * time.Second
+)
+
+// dialHost connects to a target host, retrying transient failures with
+// exponential backoff when dialretries is set. progress is called before
+// each retry so the caller can tell the user what is going on.
+func dialHost(config *Config, host Host, progress func(attempt, attempts int)) (net.Conn, error) {
+	dialTimeout := secondsOrDefault(config.DialTimeout, 15*time.Second)
+	budget := maxDialDuration
+	if dialTimeout > budget {
+		budget = dialTimeout
+	}
+	dialer := net.Dialer{
+		Timeout:   dialTimeout,
+		Deadline:  time.Now().Add(budget),
+		KeepAlive: secondsOrDefault(config.KeepAlive, 60*time.Second),
+	}
+
+	backoff := defaultDialBackoff
+	if config.DialBackoffMs > 0 {
+		backoff = time.Duration(config.DialBackoffMs) * time.Millisecond
+	}
+	attempts := config.DialRetries + 1
+
+	for attempt := 1; ; attempt++ {
+		conn, err := dialOnce(&dialer, host)
+		if err == nil {
+			return conn, nil
+		}
+		if attempt >= attempts || !isTransientDialError(err) {
+			return nil, err
+		}
+		if time.Now().Add(backoff).After(dialer.Deadline) {
+			return nil, fmt.Errorf("%w (giving up after %d attempts)", err, attempt)
+		}
+
+		log.Printf("Connecting to %s failed (attempt %d of %d): %v, retrying in %v",
+			host.Name, attempt, attempts, err, backoff)
+		if progress != nil {
+			progress(attempt+1, attempts)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxDialBackoff {
+			backoff = maxDialBackoff
+		}
+	}
+}
+
+// dialOnce makes a single plain or TLS connection attempt. A host name
+// that resolves to several addresses, e.g. round-robin records for a group
+// of LPARs, is tried one address after the other, each with the full dial
+// timeout, until one connects.
+func dialOnce(dialer *net.Dialer, host Host) (net.Conn, error) {
+	addrs := []string{host.Host}
+	if net.ParseIP(host.Host) == nil {
+		ctx, cancel := context.WithDeadline(context.Background(), dialer.Deadline)
+		resolved, err := net.DefaultResolver.LookupHost(ctx, host.Host)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		addrs = resolved
+	}
+
+	var errs []error
+	for _, addr := range addrs {
+		conn, err := dialAddress(dialer, host, addr)
+		if err == nil {
+			if len(addrs) > 1 {
+				log.Printf("Connected to %s at %s", host.Name, addr)
+			}
+			return conn, nil
+		}
+		if len(addrs) > 1 {
+			log.Printf("Connecting to %s at %s failed: %v", host.Name, addr, err)
+		}
+		errs = append(errs, err)
+
+		// Certificate problems are the same on every member
+		if !isTransientDialError(err) || !time.Now().Before(dialer.Deadline) {
+			break
+		}
+	}
+	if len(errs) == 1 {
+		return nil, errs[0]
+	}
+	return nil, fmt.Errorf("all %d addresses of %s failed, last error: %w", len(errs), host.Host, errs[len(errs)-1])
+}
+
+// dialAddress connects to one address of a host
+func dialAddress(dialer *net.Dialer, host Host, addr string) (net.Conn, error) {
+	targetAddr := net.JoinHostPort(addr, strconv.Itoa(host.Port))
+	if !host.TLS {
+		return dialer.Dial("tcp", targetAddr)
+	}
+	serverName := host.TLSServerName
+	if serverName == "" {
+		serverName = host.Host
+	}
+	return tls.DialWithDialer(dialer, "tcp", targetAddr, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: host.TLSSkipVerify,
+	})
+}
+
+// isTransientDialError reports whether trying again might help. Unknown
+// host names and certificate problems won't fix themselves in a few seconds.
+func isTransientDialError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+	var certErr *tls.CertificateVerificationError
+	return !errors.As(err, &certErr)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// envPrefix marks environment variables that override config file values,
+// e.g. SECURE3270_TLSPORT=12001 sets tlsport
+const envPrefix = "SECURE3270_"
+
+// envConfigValues returns the config keys set in the environment, in
+// lowercase, mapped to their values
+func envConfigValues() map[string]string {
+	values := make(map[string]string)
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		key, found := strings.CutPrefix(name, envPrefix)
+		if !found || key == "" {
+			continue
+		}
+		values[strings.ToLower(key)] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+// applyEnvOverrides applies SECURE3270_* variables on top of the values read
+// from the config file and returns the keys it set, sorted for logging
+func applyEnvOverrides(config *Config, values map[string]string) ([]string, error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := applyConfigValue(config, key, values[key]); err != nil {
+			return nil, fmt.Errorf("%s%s: %v", envPrefix, strings.ToUpper(key), err)
+		}
+	}
+	return keys, nil
+}
+
+// fileExists reports whether a file is present, so a missing config file
+// can be told apart from one that fails to parse
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return !os.IsNotExist(err)
+}
@@ -2,4 +2,8 @@ module secure3270proxy
 
 go 1.23.4
 
-require github.com/racingmars/go3270 v0.0.0-20250414050454-78aaf72e84cb
+require (
+	github.com/racingmars/go3270 v0.0.0-20250414050454-78aaf72e84cb
+	golang.org/x/crypto v0.41.0
+	gopkg.in/yaml.v3 v3.0.1
+)
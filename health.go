@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/racingmars/go3270"
+)
+
+// hostStatus is the last known reachability of a host
+type hostStatus int
+
+const (
+	hostStatusUnknown hostStatus = iota
+	hostStatusUp
+	hostStatusDown
+)
+
+var (
+	healthStatus      = make(map[string]hostStatus) // keyed by host:port
+	healthTargets     = make(map[string]bool)       // extra hosts seen in user host lists
+	healthLock        sync.RWMutex
+	healthChecksAlive bool
+)
+
+// hostKey returns the address used to identify a host in the health cache
+func hostKey(host Host) string {
+	return net.JoinHostPort(host.Host, strconv.Itoa(host.Port))
+}
+
+// registerHealthTargets adds hosts from a user's host list to the set of
+// hosts probed by the health checker
+func registerHealthTargets(hosts []Host) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+
+	for _, host := range hosts {
+		healthTargets[hostKey(host)] = true
+	}
+}
+
+// getHostStatus returns the cached reachability of a host
+func getHostStatus(host Host) hostStatus {
+	healthLock.RLock()
+	defer healthLock.RUnlock()
+
+	if !healthChecksAlive {
+		return hostStatusUnknown
+	}
+	return healthStatus[hostKey(host)]
+}
+
+// healthChecksEnabled reports whether the background health checker is running
+func healthChecksEnabled() bool {
+	healthLock.RLock()
+	defer healthLock.RUnlock()
+	return healthChecksAlive
+}
+
+// startHealthChecker periodically TCP-dials every known host and caches
+// whether it is reachable
+func startHealthChecker(interval time.Duration) {
+	healthLock.Lock()
+	healthChecksAlive = true
+	healthLock.Unlock()
+
+	log.Printf("Host health checks running every %v", interval)
+
+	// Don't wait longer than the interval for a single probe
+	probeTimeout := 5 * time.Second
+	if interval < probeTimeout {
+		probeTimeout = interval
+	}
+
+	for {
+		// Collect the default host list plus anything seen in user lists
+		targets := make(map[string]bool)
+		for _, host := range getConfig().Hosts {
+			targets[hostKey(host)] = true
+		}
+		healthLock.RLock()
+		for addr := range healthTargets {
+			targets[addr] = true
+		}
+		healthLock.RUnlock()
+
+		var wg sync.WaitGroup
+		for addr := range targets {
+			wg.Add(1)
+			go func(addr string) {
+				defer wg.Done()
+
+				status := hostStatusUp
+				conn, err := net.DialTimeout("tcp", addr, probeTimeout)
+				if err != nil {
+					status = hostStatusDown
+				} else {
+					conn.Close()
+				}
+
+				healthLock.Lock()
+				if previous, ok := healthStatus[addr]; ok && previous != status {
+					log.Printf("Health check: %s is now %s", addr, hostStatusName(status))
+				}
+				healthStatus[addr] = status
+				healthLock.Unlock()
+			}(addr)
+		}
+		wg.Wait()
+
+		time.Sleep(interval)
+	}
+}
+
+// hostStatusName returns a readable name for a host status
+func hostStatusName(status hostStatus) string {
+	switch status {
+	case hostStatusUp:
+		return "up"
+	case hostStatusDown:
+		return "down"
+	default:
+		return "unknown"
+	}
+}
+
+// hostStatusField builds the small status marker shown before a host name
+func hostStatusField(row, col int, host Host) go3270.Field {
+	switch getHostStatus(host) {
+	case hostStatusUp:
+		return go3270.Field{Row: row, Col: col, Content: "*", Color: go3270.Green, Intense: true}
+	case hostStatusDown:
+		return go3270.Field{Row: row, Col: col, Content: "*", Color: go3270.Red, Intense: true}
+	default:
+		return go3270.Field{Row: row, Col: col, Content: "?", Color: go3270.White}
+	}
+}
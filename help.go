@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/racingmars/go3270"
+)
+
+// Rows available for help text on the help screen (rows 2-21)
+const helpMaxLines = 20
+
+// Help screens, named like the sections of the help file
+const (
+	helpLogon = "logon"
+	helpMenu  = "menu"
+)
+
+// defaultHelp is shown for a screen the help file doesn't cover
+var defaultHelp = map[string][]string{
+	helpLogon: {
+		"Logging on",
+		"",
+		"  Type your userid and password and press Enter. Use the Tab key to",
+		"  move from the userid to the password field. Your password is not",
+		"  shown while you type it.",
+		"",
+		"  If your account has two-factor authentication, you are asked for",
+		"  the 6-digit code from your authenticator app next.",
+		"",
+		"  PF1/PF13  This help",
+		"  PF9       Log off and close the connection",
+		"",
+		"  If you can't log on, contact your system administrator.",
+	},
+	helpMenu: {
+		"Selecting a host",
+		"",
+		"  Type the number of a host, or its name or the start of its name,",
+		"  and press Enter to connect. When the host session ends you come",
+		"  back to this menu.",
+		"",
+		"  PF1   This help            PF4   Connection status",
+		"  PF5   Reconnect last host  PF7   Previous page",
+		"  PF8   Next page            PF10  Admin console (admins only)",
+		"  PF11  Clock                PF12  IBM logo",
+		"  PF6   Who am I, switch to another of your host files",
+		"",
+		"  Type one of the disconnect selections shown on the menu to log off.",
+		"",
+		"  If a host is missing or doesn't answer, contact your system administrator.",
+	},
+}
+
+var (
+	helpSections map[string][]string
+	helpLock     sync.RWMutex
+)
+
+// loadHelpFile reads the help texts. Lines under a "[logon]" or "[menu]"
+// line belong to that screen's help; lines before the first section are
+// shown on both. A screen without text keeps the built-in help, and an
+// empty filename restores it everywhere.
+func loadHelpFile(filename string) error {
+	sections := map[string][]string{}
+
+	if filename != "" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return fmt.Errorf("failed to open help file %s: %v", filename, err)
+		}
+		defer file.Close()
+
+		var common []string
+		current := ""
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), "\r")
+
+			if name, ok := strings.CutPrefix(strings.TrimSpace(line), "["); ok && strings.HasSuffix(name, "]") {
+				current = strings.ToLower(strings.TrimSuffix(name, "]"))
+				if current != helpLogon && current != helpMenu {
+					return fmt.Errorf("unknown section [%s] in help file %s", current, filename)
+				}
+				continue
+			}
+
+			if current == "" {
+				common = append(common, line)
+			} else {
+				sections[current] = append(sections[current], line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("error reading help file %s: %v", filename, err)
+		}
+
+		// Text for both screens comes first, then the screen's own
+		for _, name := range []string{helpLogon, helpMenu} {
+			if lines := trimBlankLines(append(append([]string{}, common...), sections[name]...)); len(lines) > 0 {
+				sections[name] = lines
+			} else {
+				delete(sections, name)
+			}
+		}
+	}
+
+	helpLock.Lock()
+	helpSections = sections
+	helpLock.Unlock()
+
+	return nil
+}
+
+// trimBlankLines drops empty lines at the start and end of a text
+func trimBlankLines(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// ShowHelp displays the help for a screen until the user presses Enter or
+// PF3. A non-zero deadline limits how long it waits.
+func ShowHelp(conn net.Conn, config *Config, name string, deadline time.Time) error {
+	helpLock.RLock()
+	lines, ok := helpSections[name]
+	helpLock.RUnlock()
+	if !ok {
+		lines = defaultHelp[name]
+	}
+	if len(lines) > helpMaxLines {
+		lines = lines[:helpMaxLines]
+	}
+
+	title := "Secure3270Proxy - Help"
+	screen := go3270.Screen{
+		{Row: 0, Col: getCenteredPosition(title, 79), Content: title, Color: config.Theme.title(go3270.White), Intense: true},
+	}
+	for i, line := range lines {
+		screen = append(screen, go3270.Field{
+			Row:     i + 2,
+			Col:     1,
+			Content: truncateText(line, 78),
+			Color:   go3270.Turquoise,
+		})
+	}
+	screen = append(screen, go3270.Field{
+		Row:     23,
+		Col:     1,
+		Content: "Press Enter or F3 to return",
+		Color:   go3270.White,
+	})
+
+	if !deadline.IsZero() {
+		conn.SetReadDeadline(deadline)
+		defer conn.SetReadDeadline(time.Time{})
+	}
+
+	_, err := go3270.HandleScreen(
+		screen,
+		nil,
+		nil,
+		[]go3270.AID{go3270.AIDEnter},
+		[]go3270.AID{go3270.AIDPF3},
+		"",
+		23, 1,
+		conn,
+	)
+	return err
+}
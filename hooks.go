@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long an onlogin/onconnect/ondisconnect hook may run before it is killed
+const hookTimeout = 30 * time.Second
+
+// hookEnvPrefix starts the names of the event variables passed to hooks
+const hookEnvPrefix = "HOOK_"
+
+// runHook starts a hook command for an event in the background. The command
+// is run directly (no shell) and the event details are only passed in
+// environment variables, so nothing a user typed is ever interpreted. A
+// failing or slow hook is logged and otherwise ignored.
+func runHook(command string, event string, vars map[string]string) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return
+	}
+
+	env := hookEnvironment(event, vars)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Env = env
+		output, err := cmd.CombinedOutput()
+		if ctx.Err() == context.DeadlineExceeded {
+			log.Printf("Warning: %s hook %s killed after %s", event, args[0], hookTimeout)
+		} else if err != nil {
+			log.Printf("Warning: %s hook %s failed: %v: %s", event, args[0], err, strings.TrimSpace(string(output)))
+		}
+	}()
+}
+
+// hookEnvironment is the proxy's environment without its own SECURE3270_
+// settings (which may hold the break-glass password), plus HOOK_EVENT and
+// one HOOK_<NAME> variable for each event detail
+func hookEnvironment(event string, vars map[string]string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envPrefix) {
+			env = append(env, kv)
+		}
+	}
+
+	env = append(env, hookEnvPrefix+"EVENT="+event)
+	for name, value := range vars {
+		env = append(env, hookEnvPrefix+strings.ToUpper(name)+"="+value)
+	}
+	return env
+}
+
+// hookLogin runs the onlogin hook after a successful logon
+func hookLogin(config *Config, username string, remoteAddr string) {
+	if config.OnLogin == "" {
+		return
+	}
+	runHook(config.OnLogin, "login", map[string]string{
+		"user": username,
+		"ip":   remoteAddr,
+	})
+}
+
+// hookConnect runs the onconnect hook once a host connection is up
+func hookConnect(config *Config, authSession *authSession, host Host) {
+	if config.OnConnect == "" {
+		return
+	}
+	runHook(config.OnConnect, "connect", hostHookVars(authSession, host))
+}
+
+// hookDisconnect runs the ondisconnect hook when a host session ends
+func hookDisconnect(config *Config, authSession *authSession, host Host, duration time.Duration, reason string) {
+	if config.OnDisconnect == "" {
+		return
+	}
+	vars := hostHookVars(authSession, host)
+	vars["duration"] = strconv.Itoa(int(duration / time.Second))
+	vars["reason"] = reason
+	runHook(config.OnDisconnect, "disconnect", vars)
+}
+
+// hostHookVars describes a host session for the connect and disconnect hooks
+func hostHookVars(authSession *authSession, host Host) map[string]string {
+	return map[string]string{
+		"user":   authSession.username,
+		"ip":     authSession.remoteAddr,
+		"host":   host.Name,
+		"target": net.JoinHostPort(host.Host, strconv.Itoa(host.Port)),
+	}
+}
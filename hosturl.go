@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long fetching a host list URL may take unless hosturltimeout is set
+const defaultHostURLTimeout = 10 * time.Second
+
+// Largest host list accepted from a URL
+const maxHostURLSize = 4 << 20
+
+// Redirects followed when fetching a host list, as many as net/http allows
+const maxHostURLRedirects = 10
+
+// cachedHostList is the last host list successfully loaded from a URL
+type cachedHostList struct {
+	hosts   []Host
+	fetched time.Time
+}
+
+var (
+	hostURLCache = make(map[string]cachedHostList)
+	hostURLLock  sync.Mutex
+)
+
+// isHostURL reports whether a host file name is an http:// or https:// URL
+func isHostURL(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// loadHostURL fetches and parses a host list served over HTTP(S). The last
+// list that loaded successfully is kept, so an API outage or a broken
+// response at reload time keeps the previous hosts instead of failing.
+func loadHostURL(config *Config, url string) ([]Host, error) {
+	data, err := fetchHostURL(config, url)
+	var hosts []Host
+	if err == nil {
+		hosts, err = parseHostData(url, data, config.StrictHosts)
+	}
+
+	hostURLLock.Lock()
+	defer hostURLLock.Unlock()
+
+	if err != nil {
+		cached, ok := hostURLCache[url]
+		if !ok {
+			return nil, err
+		}
+		log.Printf("Warning: %v, using the host list fetched at %s", err, cached.fetched.Format(time.RFC3339))
+		return cached.hosts, nil
+	}
+
+	hostURLCache[url] = cachedHostList{hosts: hosts, fetched: time.Now()}
+	return hosts, nil
+}
+
+// fetchHostURL downloads a host list, sending hosturltoken as a bearer token
+// when it is set. The token only ever goes over https, so it can't be read
+// off the wire or leaked by a redirect to a plain http URL.
+func fetchHostURL(config *Config, url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host list URL %s: %v", url, err)
+	}
+	req.Header.Set("Accept", "application/json, application/yaml")
+	if config.HostURLToken != "" {
+		if req.URL.Scheme == "https" {
+			req.Header.Set("Authorization", "Bearer "+config.HostURLToken)
+		} else {
+			log.Printf("SECURITY: not sending hosturltoken to %s, it is only sent over https", req.URL.Redacted())
+		}
+	}
+
+	client := &http.Client{
+		Timeout: secondsOrDefault(config.HostURLTimeout, defaultHostURLTimeout),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme != "https" && req.Header.Get("Authorization") != "" {
+				return fmt.Errorf("refusing to send hosturltoken to %s after a redirect", req.URL.Redacted())
+			}
+			if len(via) >= maxHostURLRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxHostURLRedirects)
+			}
+			return nil
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch host list: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch host list from %s: %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxHostURLSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host list from %s: %v", url, err)
+	}
+	if len(data) > maxHostURLSize {
+		return nil, fmt.Errorf("host list from %s is larger than %d bytes", url, maxHostURLSize)
+	}
+	return data, nil
+}
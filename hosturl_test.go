@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchHostURLToken(t *testing.T) {
+	var auth []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/hosts.json", http.StatusFound)
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	config := &Config{HostURLToken: "s3cret"}
+	for _, path := range []string{"/hosts.json", "/moved"} {
+		auth = nil
+		if _, err := fetchHostURL(config, server.URL+path); err != nil {
+			t.Fatalf("fetchHostURL(%s): %v", path, err)
+		}
+		for _, header := range auth {
+			if header != "" {
+				t.Errorf("%s: sent Authorization %q over plain http", path, header)
+			}
+		}
+	}
+}
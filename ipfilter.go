@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// parseCIDRList parses a comma separated list of CIDRs. Plain IP addresses
+// are treated as single-host networks.
+func parseCIDRList(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %v", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// addrIP extracts the IP address from a connection's remote address
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return net.ParseIP(addr.String())
+	}
+	return net.ParseIP(host)
+}
+
+// ipInNetworks reports whether a client address is in one of the networks
+func ipInNetworks(networks []*net.IPNet, addr net.Addr) bool {
+	ip := addrIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipAllowed checks a client address against the deny and allow lists.
+// Deny entries win; an empty allow list allows everyone not denied.
+func ipAllowed(config *Config, addr net.Addr) bool {
+	ip := addrIP(addr)
+	if ip == nil {
+		// Non-IP addresses can't be matched against CIDRs
+		return len(config.AllowCIDRs) == 0
+	}
+
+	for _, network := range config.DenyCIDRs {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(config.AllowCIDRs) == 0 {
+		return true
+	}
+	for _, network := range config.AllowCIDRs {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
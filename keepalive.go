@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Telnet NOP command, harmless to any terminal and to TN3270 data streams
+var nopCommand = []byte{telnetIAC, telnetNOP}
+
+// keepaliveConn sends a telnet NOP to the client when nothing has been
+// written to it for a while, so firewalls and NAT gateways that drop idle
+// TCP sessions keep the connection open. Writes are serialized so a NOP
+// never lands in the middle of a screen.
+type keepaliveConn struct {
+	net.Conn
+	writeLock sync.Mutex
+	lastWrite time.Time
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+// startTelnetKeepalive wraps a client connection so it gets a NOP after
+// telnetkeepalivesec seconds without output. Without the setting the
+// connection is returned as it is. stop ends the keepalives.
+func startTelnetKeepalive(conn net.Conn, config *Config) (net.Conn, func()) {
+	if config.TelnetKeepAlive <= 0 {
+		return conn, func() {}
+	}
+
+	interval := time.Duration(config.TelnetKeepAlive) * time.Second
+	kc := &keepaliveConn{Conn: conn, lastWrite: time.Now(), stop: make(chan struct{})}
+	go kc.run(interval)
+	return kc, func() { kc.stopOnce.Do(func() { close(kc.stop) }) }
+}
+
+// Write sends data to the client and notes the time for the idle check
+func (c *keepaliveConn) Write(b []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.lastWrite = time.Now()
+	return c.Conn.Write(b)
+}
+
+// run checks for idle periods until stopped. A failed NOP is ignored; the
+// next read on the connection reports the problem.
+func (c *keepaliveConn) run(interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.writeLock.Lock()
+			if time.Since(c.lastWrite) >= interval {
+				c.Conn.Write(nopCommand)
+				c.lastWrite = time.Now()
+			}
+			c.writeLock.Unlock()
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	lastHosts     = make(map[string]string) // username -> name of last host connected to
+	lastHostsFile string
+	lastHostsLock sync.Mutex
+)
+
+// loadLastHosts reads the state file that remembers each user's last host.
+// A missing file is fine; it is created on the first successful connect.
+func loadLastHosts(filename string) error {
+	lastHostsLock.Lock()
+	defer lastHostsLock.Unlock()
+
+	lastHostsFile = filename
+
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state file %s: %v", filename, err)
+	}
+
+	if err := json.Unmarshal(data, &lastHosts); err != nil {
+		return fmt.Errorf("failed to parse state file %s: %v", filename, err)
+	}
+	return nil
+}
+
+// getLastHost returns the name of the last host the user connected to
+func getLastHost(username string) string {
+	lastHostsLock.Lock()
+	defer lastHostsLock.Unlock()
+	return lastHosts[username]
+}
+
+// recordLastHost remembers the host the user just connected to and saves
+// it to the state file if one is configured
+func recordLastHost(username, hostName string) {
+	lastHostsLock.Lock()
+	defer lastHostsLock.Unlock()
+
+	if lastHosts[username] == hostName {
+		return
+	}
+	lastHosts[username] = hostName
+
+	if lastHostsFile == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(lastHosts, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode state file: %v", err)
+		return
+	}
+
+	// Write to a temporary file and rename so a crash never leaves a partial file
+	tmp := filepath.Join(filepath.Dir(lastHostsFile), "."+filepath.Base(lastHostsFile)+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("Failed to write state file: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, lastHostsFile); err != nil {
+		log.Printf("Failed to replace state file: %v", err)
+	}
+}
+
+// findHostByName returns the index of the first host with the given name, or -1
+func findHostByName(hosts []Host, name string) int {
+	for i, host := range hosts {
+		if host.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/racingmars/go3270"
+)
+
+// loginRecord is when and from where a user last logged on
+type loginRecord struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+}
+
+var (
+	lastLogins     = make(map[string]loginRecord) // username -> previous successful logon
+	lastLoginsFile string
+	lastLoginsLock sync.Mutex
+)
+
+// loadLastLogins reads the file with each user's last logon. A missing file
+// is fine; it is created on the first successful logon.
+func loadLastLogins(filename string) error {
+	lastLoginsLock.Lock()
+	defer lastLoginsLock.Unlock()
+
+	lastLoginsFile = filename
+
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read last logon file %s: %v", filename, err)
+	}
+
+	if err := json.Unmarshal(data, &lastLogins); err != nil {
+		return fmt.Errorf("failed to parse last logon file %s: %v", filename, err)
+	}
+	return nil
+}
+
+// recordLogin stores this logon and returns the one before it, or nil if
+// this is the user's first. Unless caseSensitive is set, a userid typed in
+// another case shares the record of the one already stored, like users
+// from LDAP or RADIUS that aren't in users.cnf.
+func recordLogin(username, remoteAddr string, when time.Time, caseSensitive bool) *loginRecord {
+	source := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		source = host
+	}
+
+	lastLoginsLock.Lock()
+	defer lastLoginsLock.Unlock()
+
+	if _, ok := lastLogins[username]; !ok && !caseSensitive {
+		for stored := range lastLogins {
+			if strings.EqualFold(stored, username) {
+				username = stored
+				break
+			}
+		}
+	}
+
+	var previous *loginRecord
+	if record, ok := lastLogins[username]; ok {
+		previous = &record
+	}
+	lastLogins[username] = loginRecord{Time: when, Source: source}
+
+	data, err := json.MarshalIndent(lastLogins, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode last logon file: %v", err)
+		return previous
+	}
+
+	// Write to a temporary file and rename so a crash never leaves a partial file
+	tmp := filepath.Join(filepath.Dir(lastLoginsFile), "."+filepath.Base(lastLoginsFile)+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("Failed to write last logon file: %v", err)
+		return previous
+	}
+	if err := os.Rename(tmp, lastLoginsFile); err != nil {
+		log.Printf("Failed to replace last logon file: %v", err)
+	}
+	return previous
+}
+
+// lastLoginText describes the previous logon, TSO style
+func lastLoginText(previous *loginRecord) string {
+	if previous == nil {
+		return "This is your first logon."
+	}
+	return fmt.Sprintf("Last logon was %s from %s.", previous.Time.Format("2006-01-02 15:04:05 MST"), previous.Source)
+}
+
+// ShowLastLogin tells the user when and from where they last logged on, so
+// they notice if somebody else used their account
+func ShowLastLogin(conn net.Conn, config *Config, authSession *authSession) error {
+	th := config.Theme
+	title := "Secure3270Proxy - Welcome " + authSession.username
+	screen := go3270.Screen{
+		{Row: 0, Col: getCenteredPosition(title, 79), Content: truncateText(title, 79), Color: th.title(go3270.White), Intense: true},
+		{Row: 3, Col: 3, Content: truncateText(lastLoginText(authSession.previousLogin), 76), Color: go3270.Turquoise},
+		{Row: 23, Col: 1, Content: "Press Enter to continue", Color: go3270.White},
+	}
+	if authSession.previousLogin != nil {
+		screen = append(screen, go3270.Field{Row: 5, Col: 3, Content: "If this wasn't you, please tell your administrator right away.", Color: go3270.White})
+	}
+
+	if config.IdleTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(time.Duration(config.IdleTimeout) * time.Second))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+
+	_, err := go3270.HandleScreen(
+		screen,
+		nil,
+		nil,
+		[]go3270.AID{go3270.AIDEnter},
+		[]go3270.AID{go3270.AIDPF3, go3270.AIDClear},
+		"",
+		23, 1,
+		conn,
+	)
+	if err != nil {
+		return fmt.Errorf("error showing last logon: %v", err)
+	}
+	return nil
+}
+
+// lastLoginEnabled reports whether logons are being recorded
+func lastLoginEnabled() bool {
+	lastLoginsLock.Lock()
+	defer lastLoginsLock.Unlock()
+	return lastLoginsFile != ""
+}
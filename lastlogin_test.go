@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordLoginCase(t *testing.T) {
+	if err := loadLastLogins(filepath.Join(t.TempDir(), "lastlogin.json")); err != nil {
+		t.Fatal(err)
+	}
+	first := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	if previous := recordLogin("jdoe", "192.0.2.1:1234", first, false); previous != nil {
+		t.Fatalf("first logon returned %+v", previous)
+	}
+	// Somebody else logging on as JDOE must show up for jdoe
+	previous := recordLogin("JDOE", "198.51.100.7:4321", first.Add(time.Hour), false)
+	if previous == nil || previous.Source != "192.0.2.1" {
+		t.Fatalf("logon as JDOE got previous %+v, want the one from 192.0.2.1", previous)
+	}
+	previous = recordLogin("jdoe", "192.0.2.1:1234", first.Add(2*time.Hour), false)
+	if previous == nil || previous.Source != "198.51.100.7" {
+		t.Errorf("jdoe got previous %+v, want the JDOE logon from 198.51.100.7", previous)
+	}
+
+	// With case sensitive userids they are different users
+	if previous := recordLogin("Jdoe", "192.0.2.9:1", first, true); previous != nil {
+		t.Errorf("case sensitive Jdoe got previous %+v", previous)
+	}
+}
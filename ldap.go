@@ -0,0 +1,566 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLDAPUserAttr = "uid"
+	// LDAP responses larger than this are treated as a protocol error
+	maxLDAPMessage = 1 << 20
+
+	ldapResultSuccess     = 0
+	ldapResultBusy        = 51
+	ldapResultUnavailable = 52
+
+	// OID of the StartTLS extended operation (RFC 4511)
+	ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+)
+
+// ldapGroup maps membership of an LDAP group to the host file users of that
+// group see
+type ldapGroup struct {
+	Group    string // Name of the group, i.e. the cn of its DN
+	HostFile string
+}
+
+// parseLDAPGroups reads ldapgroups, a comma separated list of group:hostfile
+// pairs such as "sysprog:sysprog.list,operators:ops.list"
+func parseLDAPGroups(value string) ([]ldapGroup, error) {
+	var groups []ldapGroup
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, hostFile, ok := strings.Cut(entry, ":")
+		group, hostFile = strings.TrimSpace(group), strings.TrimSpace(hostFile)
+		if !ok || group == "" || hostFile == "" {
+			return nil, fmt.Errorf("%q is not group:hostfile", entry)
+		}
+		groups = append(groups, ldapGroup{Group: group, HostFile: hostFile})
+	}
+	return groups, nil
+}
+
+// checkLDAPConfig makes sure the LDAP settings can work before the first
+// user tries to log in
+func checkLDAPConfig(config *Config) error {
+	u, err := url.Parse(config.LDAPURL)
+	if err != nil {
+		return fmt.Errorf("invalid ldapurl: %v", err)
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" || u.Host == "" {
+		return fmt.Errorf("invalid ldapurl %q (use ldap://host[:port] or ldaps://host[:port])", config.LDAPURL)
+	}
+	if config.LDAPStartTLS && u.Scheme == "ldaps" {
+		return fmt.Errorf("ldapstarttls can't be used with an ldaps:// url")
+	}
+	if strings.Count(config.LDAPBindDN, "%s") != 1 {
+		return fmt.Errorf("ldapbinddn must contain %%s exactly once for the userid")
+	}
+	if len(config.LDAPGroups) > 0 && config.LDAPBaseDN == "" {
+		return fmt.Errorf("ldapgroups needs an ldapbasedn to search the user's groups in")
+	}
+	return nil
+}
+
+// authenticateLDAP binds to the LDAP server as the user. On success it
+// returns the user's profile from users.cnf, if there is one, with the host
+// file of the first matching ldapgroups entry. errAuthRejected means the
+// password was wrong; any other error means the server couldn't be used.
+func authenticateLDAP(config *Config, username, password string) (User, error) {
+	// An empty password would be an unauthenticated bind, which servers
+	// report as a success
+	if username == "" || password == "" {
+		return User{}, errAuthRejected
+	}
+
+	lc, err := dialLDAP(config)
+	if err != nil {
+		return User{}, err
+	}
+	defer lc.close()
+
+	bindDN := strings.Replace(config.LDAPBindDN, "%s", escapeDNValue(username), 1)
+	if err := lc.bind(bindDN, password); err != nil {
+		return User{}, err
+	}
+
+	user := localProfile(config, username)
+	user.Password = ""
+	user.MustChangePassword = false
+
+	if len(config.LDAPGroups) > 0 {
+		memberOf, err := lc.memberOf(config.LDAPBaseDN, ldapUserAttr(config), username)
+		if err != nil {
+			// The password was fine, only the group lookup failed
+			log.Printf("LDAP group lookup for %s failed: %v", username, err)
+		}
+		if hostFile := ldapHostFile(config.LDAPGroups, memberOf); hostFile != "" {
+			user.HostFile = hostFile
+		}
+	}
+	return user, nil
+}
+
+// localProfile returns the users.cnf entry for a user authenticated
+// elsewhere, falling back to the wildcard entry, so flags, TOTP secrets and
+// host files can still be set per user. Userids are compared the way logins
+// do, and a listed user keeps the name from users.cnf.
+func localProfile(config *Config, username string) User {
+	users := currentUsers()
+	wildcard := User{}
+	for _, user := range users {
+		if user.Username == wildcardUsername {
+			wildcard = user
+		} else if sameUsername(user.Username, username, config.CaseSensitiveUsers) {
+			return user
+		}
+	}
+	wildcard.Username = username
+	return wildcard
+}
+
+// ldapHostFile returns the host file of the first configured group the user
+// is a member of, or "" for none
+func ldapHostFile(groups []ldapGroup, memberOf []string) string {
+	for _, group := range groups {
+		for _, dn := range memberOf {
+			if strings.EqualFold(group.Group, firstRDNValue(dn)) {
+				return group.HostFile
+			}
+		}
+	}
+	return ""
+}
+
+// firstRDNValue returns "ops" for "cn=ops,ou=groups,dc=example,dc=com"
+func firstRDNValue(dn string) string {
+	rdn := dn
+	if i := strings.Index(dn, ","); i >= 0 {
+		rdn = dn[:i]
+	}
+	if i := strings.Index(rdn, "="); i >= 0 {
+		return strings.TrimSpace(rdn[i+1:])
+	}
+	return strings.TrimSpace(rdn)
+}
+
+// escapeDNValue escapes a userid for use in a DN (RFC 4514), so a userid
+// like "x,ou=admins" can't bind as somebody else
+func escapeDNValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case strings.IndexByte(`,+"\<>;=`, c) >= 0,
+			c == '#' && i == 0,
+			c == ' ' && (i == 0 || i == len(value)-1):
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7F:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// ldapUserAttr is the attribute the group search matches the userid against
+func ldapUserAttr(config *Config) string {
+	if config.LDAPUserAttr != "" {
+		return config.LDAPUserAttr
+	}
+	return defaultLDAPUserAttr
+}
+
+// ldapConn is a minimal LDAPv3 client: simple bind, StartTLS and a search
+// for the memberOf attribute are all the proxy needs
+type ldapConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	nextID  int
+}
+
+// dialLDAP connects to ldapurl, with TLS for ldaps:// or ldapstarttls
+func dialLDAP(config *Config) (*ldapConn, error) {
+	u, err := url.Parse(config.LDAPURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldapurl: %v", err)
+	}
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = "389"
+		if u.Scheme == "ldaps" {
+			port = "636"
+		}
+	}
+
+	tlsConfig := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if config.LDAPCAFile != "" {
+		pem, err := os.ReadFile(config.LDAPCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ldapcafile: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ldapcafile %s", config.LDAPCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	timeout := secondsOrDefault(config.LDAPTimeout, 10*time.Second)
+	dialer := &net.Dialer{Timeout: timeout}
+	addr := net.JoinHostPort(host, port)
+
+	var conn net.Conn
+	if u.Scheme == "ldaps" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server %s: %v", addr, err)
+	}
+
+	lc := &ldapConn{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}
+	if config.LDAPStartTLS {
+		if err := lc.startTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return lc, nil
+}
+
+// close sends an unbind and drops the connection
+func (lc *ldapConn) close() {
+	lc.conn.SetWriteDeadline(time.Now().Add(lc.timeout))
+	lc.send(berTLV(0x42, nil))
+	lc.conn.Close()
+}
+
+// startTLS upgrades the connection in place
+func (lc *ldapConn) startTLS(tlsConfig *tls.Config) error {
+	id, err := lc.send(berTLV(0x77, berTLV(0x80, []byte(ldapStartTLSOID))))
+	if err != nil {
+		return err
+	}
+	op, content, err := lc.receive(id)
+	if err != nil {
+		return err
+	}
+	if op != 0x78 {
+		return fmt.Errorf("unexpected LDAP response 0x%02x to StartTLS", op)
+	}
+	if code, message, err := ldapResult(content); err != nil {
+		return err
+	} else if code != ldapResultSuccess {
+		return fmt.Errorf("LDAP StartTLS refused: result %d %s", code, message)
+	}
+
+	tlsConn := tls.Client(lc.conn, tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(lc.timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("LDAP StartTLS handshake failed: %v", err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+	lc.conn = tlsConn
+	lc.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// bind does a simple bind. A server that is busy or unavailable is an
+// error; every other refusal is errAuthRejected.
+func (lc *ldapConn) bind(dn, password string) error {
+	request := berInt(0x02, 3)
+	request = append(request, berTLV(0x04, []byte(dn))...)
+	request = append(request, berTLV(0x80, []byte(password))...)
+
+	id, err := lc.send(berTLV(0x60, request))
+	if err != nil {
+		return err
+	}
+	op, content, err := lc.receive(id)
+	if err != nil {
+		return err
+	}
+	if op != 0x61 {
+		return fmt.Errorf("unexpected LDAP response 0x%02x to bind", op)
+	}
+	code, message, err := ldapResult(content)
+	switch {
+	case err != nil:
+		return err
+	case code == ldapResultSuccess:
+		return nil
+	case code == ldapResultBusy || code == ldapResultUnavailable:
+		return fmt.Errorf("LDAP server unavailable: result %d %s", code, message)
+	default:
+		log.Printf("LDAP bind as %s refused: result %d %s", dn, code, message)
+		return errAuthRejected
+	}
+}
+
+// memberOf searches the subtree below baseDN for attr=value and returns
+// the memberOf values of the entries found
+func (lc *ldapConn) memberOf(baseDN, attr, value string) ([]string, error) {
+	filter := berTLV(0x04, []byte(attr))
+	filter = append(filter, berTLV(0x04, []byte(value))...)
+
+	request := berTLV(0x04, []byte(baseDN))
+	request = append(request, berInt(0x0A, 2)...) // wholeSubtree
+	request = append(request, berInt(0x0A, 0)...) // neverDerefAliases
+	request = append(request, berInt(0x02, 2)...) // sizeLimit
+	request = append(request, berInt(0x02, int(lc.timeout/time.Second))...)
+	request = append(request, berTLV(0x01, []byte{0})...) // typesOnly false
+	request = append(request, berTLV(0xA3, filter)...)    // equalityMatch
+	request = append(request, berTLV(0x30, berTLV(0x04, []byte("memberOf")))...)
+
+	id, err := lc.send(berTLV(0x63, request))
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []string
+	for {
+		op, content, err := lc.receive(id)
+		if err != nil {
+			return groups, err
+		}
+		switch op {
+		case 0x64: // SearchResultEntry
+			values, err := entryValues(content, "memberOf")
+			if err != nil {
+				return groups, err
+			}
+			groups = append(groups, values...)
+		case 0x73: // SearchResultReference, not followed
+		case 0x65: // SearchResultDone
+			code, message, err := ldapResult(content)
+			if err != nil {
+				return groups, err
+			}
+			if code != ldapResultSuccess {
+				return groups, fmt.Errorf("LDAP search failed: result %d %s", code, message)
+			}
+			return groups, nil
+		default:
+			return groups, fmt.Errorf("unexpected LDAP response 0x%02x to search", op)
+		}
+	}
+}
+
+// send wraps a protocol operation in an LDAPMessage and returns its id
+func (lc *ldapConn) send(op []byte) (int, error) {
+	lc.nextID++
+	message := append(berInt(0x02, lc.nextID), op...)
+	lc.conn.SetWriteDeadline(time.Now().Add(lc.timeout))
+	if _, err := lc.conn.Write(berTLV(0x30, message)); err != nil {
+		return 0, fmt.Errorf("error writing to LDAP server: %v", err)
+	}
+	return lc.nextID, nil
+}
+
+// receive reads the next LDAPMessage for id and returns its protocol
+// operation tag and content
+func (lc *ldapConn) receive(id int) (byte, []byte, error) {
+	lc.conn.SetReadDeadline(time.Now().Add(lc.timeout))
+	defer lc.conn.SetReadDeadline(time.Time{})
+
+	for {
+		tag, message, err := readBER(lc.reader)
+		if err != nil {
+			return 0, nil, fmt.Errorf("error reading from LDAP server: %v", err)
+		}
+		if tag != 0x30 {
+			return 0, nil, fmt.Errorf("invalid LDAP message tag 0x%02x", tag)
+		}
+
+		tag, idBytes, rest, err := parseBER(message)
+		if err != nil || tag != 0x02 {
+			return 0, nil, fmt.Errorf("invalid LDAP message id")
+		}
+		op, content, _, err := parseBER(rest)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid LDAP message: %v", err)
+		}
+		// Unsolicited notifications (id 0) mean the server is going away
+		if berIntValue(idBytes) == 0 {
+			return 0, nil, fmt.Errorf("LDAP server closed the connection")
+		}
+		if berIntValue(idBytes) == id {
+			return op, content, nil
+		}
+	}
+}
+
+// ldapResult reads the resultCode and diagnosticMessage of an LDAPResult
+func ldapResult(content []byte) (int, string, error) {
+	tag, code, rest, err := parseBER(content)
+	if err != nil || tag != 0x0A {
+		return 0, "", fmt.Errorf("invalid LDAP result")
+	}
+	_, _, rest, err = parseBER(rest) // matchedDN
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid LDAP result")
+	}
+	_, message, _, err := parseBER(rest)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid LDAP result")
+	}
+	return berIntValue(code), string(message), nil
+}
+
+// entryValues returns the values of one attribute of a SearchResultEntry
+func entryValues(content []byte, name string) ([]string, error) {
+	_, _, rest, err := parseBER(content) // objectName
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP entry")
+	}
+	_, attributes, _, err := parseBER(rest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP entry")
+	}
+
+	var values []string
+	for len(attributes) > 0 {
+		var attribute []byte
+		_, attribute, attributes, err = parseBER(attributes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LDAP attribute")
+		}
+		_, attrType, vals, err := parseBER(attribute)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LDAP attribute")
+		}
+		if !strings.EqualFold(string(attrType), name) {
+			continue
+		}
+		_, set, _, err := parseBER(vals)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LDAP attribute")
+		}
+		for len(set) > 0 {
+			var value []byte
+			_, value, set, err = parseBER(set)
+			if err != nil {
+				return nil, fmt.Errorf("invalid LDAP attribute value")
+			}
+			values = append(values, string(value))
+		}
+	}
+	return values, nil
+}
+
+// berTLV encodes one BER element with a definite length
+func berTLV(tag byte, content []byte) []byte {
+	out := []byte{tag}
+	n := len(content)
+	switch {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xFF:
+		out = append(out, 0x81, byte(n))
+	case n <= 0xFFFF:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	default:
+		out = append(out, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(out, content...)
+}
+
+// berInt encodes a non-negative INTEGER or ENUMERATED
+func berInt(tag byte, n int) []byte {
+	content := []byte{byte(n)}
+	for n >>= 8; n > 0; n >>= 8 {
+		content = append([]byte{byte(n)}, content...)
+	}
+	if content[0]&0x80 != 0 {
+		content = append([]byte{0}, content...)
+	}
+	return berTLV(tag, content)
+}
+
+// berIntValue decodes a small INTEGER or ENUMERATED
+func berIntValue(content []byte) int {
+	n := 0
+	for _, b := range content {
+		n = n<<8 | int(b)
+	}
+	return n
+}
+
+// parseBER splits the first BER element off data
+func parseBER(data []byte) (tag byte, content, rest []byte, err error) {
+	if len(data) < 2 {
+		return 0, nil, nil, io.ErrUnexpectedEOF
+	}
+	tag = data[0]
+	length, header := int(data[1]), 2
+	if data[1]&0x80 != 0 {
+		size := int(data[1] & 0x7F)
+		if size == 0 || size > 4 || len(data) < 2+size {
+			return 0, nil, nil, fmt.Errorf("unsupported BER length")
+		}
+		length = 0
+		for _, b := range data[2 : 2+size] {
+			length = length<<8 | int(b)
+		}
+		header += size
+	}
+	if length < 0 || len(data)-header < length {
+		return 0, nil, nil, io.ErrUnexpectedEOF
+	}
+	return tag, data[header : header+length], data[header+length:], nil
+}
+
+// readBER reads one complete BER element from the connection
+func readBER(r *bufio.Reader) (byte, []byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		size := int(first & 0x7F)
+		if size == 0 || size > 4 {
+			return 0, nil, fmt.Errorf("unsupported BER length")
+		}
+		length = 0
+		for i := 0; i < size; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxLDAPMessage {
+		return 0, nil, fmt.Errorf("LDAP message of %d bytes is too large", length)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+	return tag, content, nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unhex decodes a hex dump with optional spaces
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("bad hex %q: %v", s, err)
+	}
+	return b
+}
+
+func TestBERInt(t *testing.T) {
+	// Encodings from X.690 section 8.3
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "02 01 00"},
+		{1, "02 01 01"},
+		{127, "02 01 7f"},
+		{128, "02 02 00 80"},
+		{256, "02 02 01 00"},
+		{65535, "02 03 00 ff ff"},
+	}
+	for _, tt := range tests {
+		got := berInt(0x02, tt.n)
+		if !bytes.Equal(got, unhex(t, tt.want)) {
+			t.Errorf("berInt(%d) = % x, want %s", tt.n, got, tt.want)
+		}
+		_, content, rest, err := parseBER(got)
+		if err != nil || len(rest) != 0 || berIntValue(content) != tt.n {
+			t.Errorf("berInt(%d) decodes to %d, rest % x, err %v", tt.n, berIntValue(content), rest, err)
+		}
+	}
+}
+
+func TestBERLength(t *testing.T) {
+	tests := []struct {
+		length int
+		header string
+	}{
+		{0, "04 00"},
+		{0x7f, "04 7f"},
+		{0x80, "04 81 80"},
+		{0xff, "04 81 ff"},
+		{0x100, "04 82 01 00"},
+		{0xffff, "04 82 ff ff"},
+		{0x10000, "04 84 00 01 00 00"},
+	}
+	for _, tt := range tests {
+		content := bytes.Repeat([]byte{'a'}, tt.length)
+		encoded := berTLV(0x04, content)
+		header := unhex(t, tt.header)
+		if !bytes.HasPrefix(encoded, header) || len(encoded) != len(header)+tt.length {
+			t.Errorf("berTLV with %d bytes starts % x, want %s", tt.length, encoded[:min(len(encoded), 6)], tt.header)
+		}
+
+		// Trailing data after the element is handed back untouched
+		tag, got, rest, err := parseBER(append(encoded, 0x05, 0x00))
+		if err != nil || tag != 0x04 || !bytes.Equal(got, content) || !bytes.Equal(rest, []byte{0x05, 0x00}) {
+			t.Errorf("parseBER of %d bytes: tag 0x%02x, %d content bytes, rest % x, err %v", tt.length, tag, len(got), rest, err)
+		}
+
+		readTag, readContent, err := readBER(bufio.NewReader(bytes.NewReader(encoded)))
+		if err != nil || readTag != 0x04 || !bytes.Equal(readContent, content) {
+			t.Errorf("readBER of %d bytes: tag 0x%02x, %d content bytes, err %v", tt.length, readTag, len(readContent), err)
+		}
+	}
+}
+
+func TestParseBERMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"tag only", "04"},
+		{"short content", "04 05 61 62"},
+		{"indefinite length", "30 80 00 00"},
+		{"length of 5 bytes", "04 85 00 00 00 00 01 61"},
+		{"truncated long length", "04 82 01"},
+		{"long length past the end", "04 82 01 00 61"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := unhex(t, tt.data)
+			if _, _, _, err := parseBER(data); err == nil {
+				t.Errorf("parseBER(% x) succeeded, want an error", data)
+			}
+			if _, _, err := readBER(bufio.NewReader(bytes.NewReader(data))); err == nil {
+				t.Errorf("readBER(% x) succeeded, want an error", data)
+			}
+		})
+	}
+}
+
+func TestReadBERTooLarge(t *testing.T) {
+	data := unhex(t, "30 84 7f ff ff ff")
+	if _, _, err := readBER(bufio.NewReader(bytes.NewReader(data))); err == nil {
+		t.Errorf("readBER accepted a 2 GB message")
+	}
+}
+
+func TestLDAPResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		code    int
+		message string
+		wantErr bool
+	}{
+		{"success", "0a 01 00 04 00 04 00", 0, "", false},
+		{"invalid credentials", "0a 01 31 04 00 04 04 6e 6f 70 65", 49, "nope", false},
+		{"unavailable", "0a 01 34 04 00 04 00", 52, "", false},
+		{"not enumerated", "02 01 00 04 00 04 00", 0, "", true},
+		{"truncated", "0a 01 00 04 00", 0, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, message, err := ldapResult(unhex(t, tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ldapResult error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (code != tt.code || message != tt.message) {
+				t.Errorf("ldapResult = %d %q, want %d %q", code, message, tt.code, tt.message)
+			}
+		})
+	}
+}
+
+func TestEntryValues(t *testing.T) {
+	attribute := func(name string, values ...string) []byte {
+		var set []byte
+		for _, value := range values {
+			set = append(set, berTLV(0x04, []byte(value))...)
+		}
+		return berTLV(0x30, append(berTLV(0x04, []byte(name)), berTLV(0x31, set)...))
+	}
+	attributes := append(attribute("cn", "jdoe"), attribute("memberOf", "cn=ops,dc=example", "cn=dev,dc=example")...)
+	entry := append(berTLV(0x04, []byte("uid=jdoe,dc=example")), berTLV(0x30, attributes)...)
+
+	values, err := entryValues(entry, "memberof")
+	if err != nil {
+		t.Fatalf("entryValues: %v", err)
+	}
+	if strings.Join(values, ";") != "cn=ops,dc=example;cn=dev,dc=example" {
+		t.Errorf("entryValues = %q", values)
+	}
+
+	if values, err := entryValues(entry, "mail"); err != nil || len(values) != 0 {
+		t.Errorf("entryValues for a missing attribute = %q, %v", values, err)
+	}
+	if _, err := entryValues(entry[:len(entry)-3], "memberOf"); err == nil {
+		t.Errorf("entryValues accepted a truncated entry")
+	}
+}
+
+func TestLDAPBind(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string // BindResponse content after the message id
+		wantErr  bool
+		rejected bool
+	}{
+		{"success", "61 07 0a 01 00 04 00 04 00", false, false},
+		{"invalid credentials", "61 07 0a 01 31 04 00 04 00", true, true},
+		{"busy", "61 07 0a 01 33 04 00 04 00", true, false},
+		{"wrong operation", "65 07 0a 01 00 04 00 04 00", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			lc := &ldapConn{conn: client, reader: bufio.NewReader(client), timeout: 2 * time.Second}
+
+			response := berTLV(0x30, append(berInt(0x02, 1), unhex(t, tt.response)...))
+			requests := make(chan []byte, 1)
+			go func() {
+				defer server.Close()
+				_, message, err := readBER(bufio.NewReader(server))
+				if err != nil {
+					requests <- nil
+					return
+				}
+				requests <- berTLV(0x30, message)
+				server.Write(response)
+			}()
+
+			err := lc.bind("", "")
+
+			// Anonymous simple bind as in RFC 4511: version 3, empty name
+			// and password, message id 1
+			if request := <-requests; !bytes.Equal(request, unhex(t, "30 0c 02 01 01 60 07 02 01 03 04 00 80 00")) {
+				t.Errorf("bind request = % x", request)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("bind error = %v, want error %v", err, tt.wantErr)
+			}
+			if errors.Is(err, errAuthRejected) != tt.rejected {
+				t.Errorf("bind error = %v, want rejected %v", err, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestEscapeDNValue(t *testing.T) {
+	// Characters that RFC 4514 section 2.4 says must be escaped
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"jdoe", "jdoe"},
+		{"x,ou=admins", `x\,ou\=admins`},
+		{`a+b"c\d<e>f;g`, `a\+b\"c\\d\<e\>f\;g`},
+		{"#jdoe", `\#jdoe`},
+		{"jd#oe", "jd#oe"},
+		{" jdoe ", `\ jdoe\ `},
+		{"j doe", "j doe"},
+		{"j\x00doe\n", `j\00doe\0a`},
+		{"müller", "müller"},
+	}
+	for _, tt := range tests {
+		if got := escapeDNValue(tt.value); got != tt.want {
+			t.Errorf("escapeDNValue(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+// useUsers makes users the users list for one test
+func useUsers(t *testing.T, users []User) {
+	t.Helper()
+	previous := authUsers.Load()
+	authUsers.Store(&users)
+	t.Cleanup(func() { authUsers.Store(previous) })
+}
+
+// fakeLDAPServer accepts binds with any password and returns its URL
+func fakeLDAPServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	success := unhex(t, "61 07 0a 01 00 04 00 04 00")
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					_, message, err := readBER(reader)
+					if err != nil {
+						return
+					}
+					_, id, op, err := parseBER(message)
+					if err != nil || len(op) == 0 || op[0] != 0x60 {
+						return // Unbind or anything else ends the connection
+					}
+					conn.Write(berTLV(0x30, append(berInt(0x02, berIntValue(id)), success...)))
+				}
+			}()
+		}
+	}()
+	return "ldap://" + listener.Addr().String()
+}
+
+func TestLDAPLocalProfile(t *testing.T) {
+	useUsers(t, []User{
+		{Username: "*", Password: "x", HostFile: "guest.list"},
+		{Username: "jdoe", Password: "x", TOTPSecret: rfc6238Secret, ReadOnly: true, AllowedHosts: []string{"mvs1"}, AfterHost: "disconnect"},
+	})
+	config := &Config{LDAPURL: fakeLDAPServer(t), LDAPBindDN: "uid=%s,dc=example", LDAPTimeout: 2}
+
+	tests := []struct {
+		name          string
+		username      string
+		caseSensitive bool
+		want          string // Expected userid; "" when the wildcard entry is used
+	}{
+		{"exact", "jdoe", false, "jdoe"},
+		{"uppercase", "JDOE", false, "jdoe"},
+		{"mixed case", "JDoe", false, "jdoe"},
+		{"case sensitive", "JDOE", true, ""},
+		{"unlisted user", "alice", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.CaseSensitiveUsers = tt.caseSensitive
+			user, err := authenticateLDAP(config, tt.username, "secret")
+			if err != nil {
+				t.Fatalf("authenticateLDAP: %v", err)
+			}
+			if user.Password != "" {
+				t.Errorf("profile keeps the users.cnf password")
+			}
+			if tt.want == "" {
+				if user.Username != tt.username || user.HostFile != "guest.list" || user.TOTPSecret != "" {
+					t.Errorf("authenticateLDAP(%q) = %+v, want the wildcard profile", tt.username, user)
+				}
+				return
+			}
+			if user.Username != tt.want || user.TOTPSecret != rfc6238Secret || !user.ReadOnly ||
+				len(user.AllowedHosts) != 1 || user.AfterHost != "disconnect" {
+				t.Errorf("authenticateLDAP(%q) = %+v, want the profile of %s", tt.username, user, tt.want)
+			}
+		})
+	}
+}
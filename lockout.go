@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// loginFailure tracks failed login attempts for a single username
+type loginFailure struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+var (
+	loginFailures     = make(map[string]*loginFailure)
+	loginFailuresLock sync.Mutex
+)
+
+// lockoutWindow returns how long an account stays locked once the
+// failed login threshold has been crossed
+func lockoutWindow(config *Config) time.Duration {
+	minutes := 15
+	if config.LockoutMinutes > 0 {
+		minutes = config.LockoutMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// isLockedOut reports whether the username is currently locked out
+func isLockedOut(username string) bool {
+	loginFailuresLock.Lock()
+	defer loginFailuresLock.Unlock()
+
+	failure, ok := loginFailures[username]
+	if !ok {
+		return false
+	}
+	return time.Now().Before(failure.lockedUntil)
+}
+
+// recordFailedLogin counts a failed login for the username and returns true
+// if this failure caused the account to become locked
+func recordFailedLogin(username string, config *Config) bool {
+	if config.MaxFailedLogins <= 0 {
+		return false
+	}
+
+	window := lockoutWindow(config)
+	now := time.Now()
+
+	loginFailuresLock.Lock()
+	defer loginFailuresLock.Unlock()
+
+	// Forget stale entries so the map doesn't grow without bound
+	for name, failure := range loginFailures {
+		if now.Sub(failure.lastFailure) > window && now.After(failure.lockedUntil) {
+			delete(loginFailures, name)
+		}
+	}
+
+	failure, ok := loginFailures[username]
+	if !ok {
+		failure = &loginFailure{}
+		loginFailures[username] = failure
+	}
+	failure.count++
+	failure.lastFailure = now
+
+	if failure.count >= config.MaxFailedLogins {
+		failure.lockedUntil = now.Add(window)
+		failure.count = 0
+		log.Printf("SECURITY: user %s locked out for %v after %d failed logins",
+			username, window, config.MaxFailedLogins)
+		return true
+	}
+
+	return false
+}
+
+// resetFailedLogins clears the failure counter after a successful login
+func resetFailedLogins(username string) {
+	loginFailuresLock.Lock()
+	delete(loginFailures, username)
+	loginFailuresLock.Unlock()
+}
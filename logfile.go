@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// Rotated log files kept when logmaxsize is set and logkeep isn't
+const defaultLogKeep = 3
+
+// logFile is the log output when logfile is set. It can be reopened after
+// an external tool like logrotate moved it away, and rotates itself once it
+// reaches maxSize. Writes are serialized so lines never interleave.
+type logFile struct {
+	lock    sync.Mutex
+	path    string
+	file    *os.File
+	size    int64
+	maxSize int64 // Bytes before rotating, 0 to leave rotation to others
+	keep    int   // Rotated files kept as path.1 (newest) to path.<keep>
+}
+
+// The log file in use, nil when logging to stdout
+var currentLogFile *logFile
+
+// openLogFile sends the log to a file from now on
+func openLogFile(config *Config) error {
+	keep := config.LogKeep
+	if keep <= 0 {
+		keep = defaultLogKeep
+	}
+	lf := &logFile{path: config.LogFile, maxSize: int64(config.LogMaxSize) << 20, keep: keep}
+	if err := lf.open(); err != nil {
+		return err
+	}
+
+	log.SetOutput(lf)
+	currentLogFile = lf
+	return nil
+}
+
+// open (re)opens the file in append mode; the caller holds the lock or has
+// the file to itself
+func (lf *logFile) open() error {
+	file, err := os.OpenFile(lf.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s: %v", lf.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file %s: %v", lf.path, err)
+	}
+
+	if lf.file != nil {
+		lf.file.Close()
+	}
+	lf.file = file
+	lf.size = info.Size()
+	return nil
+}
+
+// Write appends one log line, rotating first if it would make the file
+// larger than maxSize
+func (lf *logFile) Write(p []byte) (int, error) {
+	lf.lock.Lock()
+	defer lf.lock.Unlock()
+
+	if lf.maxSize > 0 && lf.size > 0 && lf.size+int64(len(p)) > lf.maxSize {
+		if err := lf.rotate(); err != nil {
+			// Keep logging to the old file rather than losing lines
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file: %v\n", err)
+		}
+	}
+
+	n, err := lf.file.Write(p)
+	lf.size += int64(n)
+	return n, err
+}
+
+// rotate shifts path.1 to path.2 and so on, dropping the oldest, moves the
+// current file to path.1 and starts a new one
+func (lf *logFile) rotate() error {
+	os.Remove(fmt.Sprintf("%s.%d", lf.path, lf.keep))
+	for i := lf.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", lf.path, i), fmt.Sprintf("%s.%d", lf.path, i+1))
+	}
+	if err := os.Rename(lf.path, lf.path+".1"); err != nil {
+		return fmt.Errorf("failed to rename %s: %v", lf.path, err)
+	}
+	return lf.open()
+}
+
+// reopenLogFile reopens the log file after it was rotated externally
+func reopenLogFile() {
+	lf := currentLogFile
+	if lf == nil {
+		return
+	}
+
+	lf.lock.Lock()
+	err := lf.open()
+	lf.lock.Unlock()
+
+	if err != nil {
+		log.Printf("Failed to reopen log file: %v", err)
+		return
+	}
+	log.Printf("Reopened log file %s", lf.path)
+}
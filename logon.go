@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// logonText holds the static wording of the logon screen. The input fields
+// and their positions are fixed; only the surrounding text can be changed.
+type logonText struct {
+	Title         string // Row 0 banner
+	Help          string // Function key help line
+	Header        string // "ENTER LOGON PARAMETERS BELOW:"
+	RightHeader   string // "RACF LOGON PARAMETERS:"
+	UseridLabel   string
+	PasswordLabel string
+	Procedure     string // Value shown next to PROCEDURE
+	AcctNmbr      string // Value shown next to ACCT NMBR
+	Size          string // Value shown next to SIZE
+	Perform       string // Value shown next to PERFORM
+	Command       string // Value shown on the COMMAND line
+	OptionsHeader string // Text above the option list
+	Options       string // Option list on the bottom row
+}
+
+// defaultLogonText is the TSO/E style screen shown without a template
+var defaultLogonText = logonText{
+	Title:         strings.Repeat("-", 15) + " SECURE3270PROXY - TSO/E  LOGON " + strings.Repeat("-", 15),
+	Help:          "PF1/PF13 ==> Help   PF9 ==> Logoff",
+	Header:        "ENTER LOGON PARAMETERS BELOW:",
+	RightHeader:   "RACF LOGON PARAMETERS:",
+	UseridLabel:   "USERID",
+	PasswordLabel: "PASSWORD",
+	Procedure:     "TSOISPF",
+	Size:          "6144",
+	OptionsHeader: "ENTER AN 'S' BEFORE EACH OPTION DESIRED BELOW:",
+	Options:       "-NOMAIL         -NONOTICE        -RECONNECT        -OIDCARD",
+}
+
+var (
+	currentLogonText = defaultLogonText
+	logonTextLock    sync.RWMutex
+)
+
+// loadLogonTemplate reads key=value overrides for the logon screen text.
+// Keys not in the file keep their default wording; an empty filename
+// restores the built-in screen.
+func loadLogonTemplate(filename string) error {
+	text := defaultLogonText
+
+	if filename != "" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return fmt.Errorf("failed to open logon template %s: %v", filename, err)
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), "\r")
+			if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+				continue
+			}
+
+			parts := strings.SplitN(line, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+
+			// Values are used as is so leading spaces can position text
+			key := strings.ToLower(strings.TrimSpace(parts[0]))
+			value := parts[1]
+
+			switch key {
+			case "title":
+				text.Title = value
+			case "help":
+				text.Help = value
+			case "header":
+				text.Header = value
+			case "rightheader":
+				text.RightHeader = value
+			case "useridlabel":
+				text.UseridLabel = value
+			case "passwordlabel":
+				text.PasswordLabel = value
+			case "procedure":
+				text.Procedure = value
+			case "acctnmbr":
+				text.AcctNmbr = value
+			case "size":
+				text.Size = value
+			case "perform":
+				text.Perform = value
+			case "command":
+				text.Command = value
+			case "optionsheader":
+				text.OptionsHeader = value
+			case "options":
+				text.Options = value
+			default:
+				return fmt.Errorf("unknown key %q in logon template %s", key, filename)
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("error reading logon template %s: %v", filename, err)
+		}
+	}
+
+	logonTextLock.Lock()
+	currentLogonText = text
+	logonTextLock.Unlock()
+
+	return nil
+}
+
+// getLogonText returns the wording for the logon screen
+func getLogonText() logonText {
+	logonTextLock.RLock()
+	defer logonTextLock.RUnlock()
+	return currentLogonText
+}
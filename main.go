@@ -2,15 +2,22 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/racingmars/go3270"
@@ -32,37 +39,803 @@ v 0.5 per user hosts lists!
 v 0.6 selecing X or 99 from hosts view will disconnect session
 v 0.7 more permissive TLS settings
 v 0.8 add F11 key to display clock from proxy menu
+v 0.9 bcrypt hashed passwords in users.cnf, -hashpw helper
+v 0.10 optional TLS from the proxy to the target hosts
+v 0.11 optional TOTP second factor per user
 :wq
 */
+
+// version is shown on the status screen; release builds can override it
+// with -ldflags "-X main.version=..."
+var version = "0.11"
+
+// startTime is when the proxy started, for the uptime on the status screen
+var startTime = time.Now()
+
 type Host struct {
-	Name string `json:"name"`
-	Host string `json:"host"`
-	Port int    `json:"port"`
+	Name string `json:"name" yaml:"name"`
+	Host string `json:"host" yaml:"host"`
+	Port int    `json:"port" yaml:"port"`
+
+	// Optional TLS for the connection from the proxy to the host
+	TLS           bool   `json:"tls,omitempty" yaml:"tls"`
+	TLSServerName string `json:"tlsservername,omitempty" yaml:"tlsservername"` // name to verify, defaults to Host
+	TLSSkipVerify bool   `json:"tlsskipverify,omitempty" yaml:"tlsskipverify"` // don't verify the host's certificate
+
+	// Warning shown before connecting; the user must confirm with Enter
+	Warn string `json:"warn,omitempty" yaml:"warn"`
+
+	// Free text shown under the name on the host menu
+	Description string `json:"description,omitempty" yaml:"description"`
+
+	// How the terminal is handed over: "tn3270" (default) un-negotiates the
+	// terminal so the host negotiates TN3270 or TN3270E with it end to end,
+	// "raw" keeps the proxy's TN3270 session and passes the bytes through
+	Negotiation string `json:"negotiation,omitempty" yaml:"negotiation"`
+
+	// Keys "typed" to the host once its first screen arrives, e.g. to pick a
+	// VTAM application: text with {ENTER} and {CLEAR}, see onConnectRecords
+	OnConnectSend string `json:"onconnectsend,omitempty" yaml:"onconnectsend"`
+
+	// EBCDIC code page the host uses, e.g. "037" or "1047". Shown to users so
+	// they can set their emulator to match; the data is not translated.
+	CodePage string `json:"codepage,omitempty" yaml:"codepage"`
+}
+
+// rawNegotiation reports whether the host gets the terminal without telnet
+// un-negotiation
+func (h Host) rawNegotiation() bool {
+	return strings.EqualFold(h.Negotiation, "raw")
 }
 
 type Config struct {
-	Hosts         []Host
-	Port          int
-	TLSPort       int
-	TLSCert       string
-	TLSKey        string
-	HostFile      string // Path to the hosts configuration file
-	TLSEnabled    bool   // Flag to enable/disable TLS
-	TLSMinVersion string // Minimum TLS version (TLS1.0, TLS1.1, TLS1.2, TLS1.3)
-	TLSMaxVersion string // Maximum TLS version (TLS1.0, TLS1.1, TLS1.2, TLS1.3)
-	TLSTimeout    int    // Timeout in seconds for TLS connection negotiation
+	Hosts       []Host
+	Port        int
+	TLSPort     int
+	TLSCert     string
+	TLSKey      string
+	HostFile    string // Path to the hosts configuration file, or an http(s):// URL
+	StrictHosts bool   // Refuse host files with invalid entries instead of skipping them
+
+	HostURLToken   string // Bearer token sent when the host list is fetched from a URL
+	HostURLTimeout int    // Seconds allowed for fetching a host list URL (10)
+
+	TLSEnabled     bool     // Flag to enable/disable TLS
+	TLSMinVersion  string   // Minimum TLS version (TLS1.0, TLS1.1, TLS1.2, TLS1.3)
+	TLSMaxVersion  string   // Maximum TLS version (TLS1.0, TLS1.1, TLS1.2, TLS1.3)
+	TLSTimeout     int      // Timeout in seconds for TLS connection negotiation
+	MaxHandshakes  int      // TLS connections allowed in handshake and telnet negotiation at once, not counting logon (0 = unlimited)
+	TLSCiphers     []uint16 // Allowed TLS 1.0-1.2 cipher suites (empty = built-in default)
+	TLSModern      bool     // Only allow AEAD cipher suites and TLS 1.2 or later
+	BindAddress    string   // Address to listen on, e.g. 10.0.0.5 or [::] (empty = all interfaces)
+	TLSBindAddress string   // Address for the TLS listener only (empty = same as BindAddress)
+	ProxyProtocol  bool     // Expect a PROXY protocol v1/v2 header from a load balancer on every connection
+	UnixSocket     string   // Also accept plain telnet clients on this UNIX socket path (empty = disabled)
+
+	TLSSNICerts  []sniCert      // Certificates picked by the server name (SNI) the client asks for
+	TLSListeners []*tlsListener // Extra TLS listeners with their own port and TLS settings (tlslistener.<name>.<setting>)
+
+	AllowCIDRs []*net.IPNet // Client networks allowed to connect (empty = all)
+	DenyCIDRs  []*net.IPNet // Client networks that are always refused
+
+	// Emergency admin login that works without users.cnf
+	BreakGlass     string       // userid/password (or bcrypt hash)
+	BreakGlassFile string       // File holding userid/password, must be mode 0600 or stricter
+	AdminCIDRs     []*net.IPNet // Networks the break-glass login may be used from without TLS
+
+	// LDAP bind authentication, checked before users.cnf
+	LDAPURL        string      // ldap://host[:port] or ldaps://host[:port] (empty = disabled)
+	LDAPStartTLS   bool        // Upgrade ldap:// connections with StartTLS
+	LDAPCAFile     string      // CA bundle for verifying the LDAP server (empty = system roots)
+	LDAPBindDN     string      // DN users bind as, %s is replaced by the userid
+	LDAPBaseDN     string      // Where the user's entry is searched for group memberships
+	LDAPUserAttr   string      // Attribute holding the userid in the search (uid)
+	LDAPGroups     []ldapGroup // Group to host file mapping, the first group the user is in wins
+	LDAPFailClosed bool        // Refuse logins while LDAP is unreachable instead of using users.cnf
+	LDAPTimeout    int         // Seconds allowed for connecting and each LDAP request (10)
+
+	// RADIUS authentication (PAP), checked before users.cnf
+	RADIUSServers        []string // host[:port] of each server, tried in order (empty = disabled)
+	RADIUSSecret         string   // Shared secret, or an @file:/@exec: reference
+	RADIUSTimeout        int      // Seconds to wait for an answer before resending (5)
+	RADIUSRetries        int      // Resends per server before trying the next one (2)
+	RADIUSHostFileVendor uint32   // Vendor id of the attribute naming the user's host file (0 = none)
+	RADIUSHostFileType   byte     // Vendor attribute type naming the user's host file
+	RADIUSFailClosed     bool     // Refuse logins while no server answers instead of using users.cnf
+
+	// Automatic denylist for scanners and other non-3270 clients
+	ScanBanThreshold int // Rejected connections from one IP before it is banned (0 = never ban)
+	ScanBanMinutes   int // How long a scanner stays banned (60)
+
+	ClientCAFile   string // CA bundle used to verify TLS client certificates (empty = no client certs)
+	ClientAuth     string // Client certificate mode: none, request or require (default: require when a CA is set)
+	ClientCertBind bool   // Require the login username to match the client certificate CN
+
+	MaxFailedLogins int // Failed logins before a user is locked out (0 = no lockout)
+	LockoutMinutes  int // How long a locked out user stays locked
+
+	MaxLoginAttempts int // Failed logins on one connection before it is closed (0 = unlimited)
+
+	IdleTimeout int // Seconds a user may sit idle on the host menu (0 = no limit)
+	IdleWarning int // Seconds before the idle timeout a warning is shown (0 = no warning)
+
+	MaxSessionMinutes int // Minutes after logon a user is disconnected regardless of activity (0 = no limit)
+
+	// Network timeouts; zero means use the built-in default
+	NegotiateTimeout   int // Seconds allowed for telnet negotiation on the plain listener (30)
+	FirstByteTimeout   int // Seconds a new client has to answer the telnet negotiation (5)
+	DialTimeout        int // Seconds allowed for connecting to a target host (15)
+	UnNegotiateTimeout int // Seconds allowed for telnet un/re-negotiation around host sessions (10)
+	RenegotiateTries   int // Telnet re-negotiation attempts after a host session ends (3)
+	RenegotiateDelayMs int // Milliseconds between re-negotiation attempts (1000)
+	AuthTimeout        int // Seconds each screen before logon waits for Enter before disconnecting (300)
+	KeepAlive          int // Seconds between TCP keepalive probes on client and host connections (60)
+	TelnetKeepAlive    int // Seconds without output after which a telnet NOP is sent to the client (0 = never)
+
+	// Listener restarts; zero means use the built-in default
+	RestartDelay      int // Seconds before restarting a listener that failed quickly (30)
+	RestartAfterStop  int // Seconds before restarting a listener that stopped without an error (10)
+	RestartStableTime int // Seconds a listener must run before a failure restarts it at once (300)
+	RestartJitter     int // Percent of the delay added at random (0 = exact delays)
+
+	DialRetries   int // Extra attempts when connecting to a target host fails (0 = single attempt)
+	DialBackoffMs int // Milliseconds before the first retry, doubled on each further retry (500)
+
+	MinPasswordLength int // Minimum length for passwords chosen on the change screen
+	PasswordHistory   int // Earlier passwords a user may not choose again (0 = only length is checked)
+
+	CaseSensitiveUsers  bool // Match userids exactly instead of ignoring case
+	DuplicateUsersFatal bool // A userid listed twice makes loading users.cnf fail instead of a warning
+	UppercaseUserid     bool // Uppercase the typed userid before looking it up, like TSO
+
+	StateFile string // Remembers each user's last host across restarts (empty = in memory only)
+
+	LastLoginFile string // Records each user's last logon, shown to them after the next one (empty = disabled)
+
+	MOTDFile   string // Message of the day shown after login (empty = none)
+	MOTDCenter bool   // Center each line of the message of the day
+
+	LogonTemplate  string // Overrides for the logon screen wording (empty = built-in TSO/E screen)
+	HelpFile       string // Text for the PF1 help screens (empty = built-in help)
+	SplashSeconds  int    // Seconds the splash screen is shown to new connections (0 = no splash)
+	SplashFile     string // ASCII art for the splash screen (empty = IBM logo)
+	LogonProcedure string // PROCEDURE shown on the logon screen, overriding the template
+	LogonSize      string // SIZE shown on the logon screen, overriding the template
+
+	AuditFile string // Append-only audit trail of security events (empty = disabled)
+
+	LogFile    string // Log to this file instead of stdout, reopened on SIGHUP (empty = stdout)
+	LogMaxSize int    // Megabytes after which the log file is rotated by the proxy (0 = never)
+	LogKeep    int    // Rotated log files kept (3)
+
+	MaxSessionsPerUser int // Simultaneous sessions allowed per user (0 = unlimited)
+	MaxTotalSessions   int // Simultaneous sessions allowed in total (0 = unlimited)
+
+	HealthCheckInterval int // Seconds between host reachability probes (0 = disabled)
+	HealthPort          int // Port for the HTTP /healthz and /readyz probes (0 = disabled)
+
+	MaxKbps int // Per-direction bandwidth limit for each proxied session (0 = unlimited)
+
+	AutoConnect          string // Host name users are connected to straight after logon (empty = host menu)
+	AutoConnectReconnect bool   // Connect auto-connect users again when their host session ends instead of logging off
+	AfterHostDisconnect  bool   // Log users off when a host session ends instead of returning to the host menu
+
+	DisconnectTokens  []string // Host menu selections that log off (default 99 and X)
+	MaxHostsDisplayed int      // Hosts listed on the host menu, the rest only by name (0 = all)
+
+	OnLogin      string // Command run in the background after each successful logon
+	OnConnect    string // Command run when a user is connected to a host
+	OnDisconnect string // Command run when a host session ends
+
+	RecordDir string // Directory for raw 3270 session recordings (empty = disabled)
+	RecordAll bool   // Record every user, not just those with the record flag
+
+	ClockZones       []clockZone   // Timezones cycled with F11 on the clock screen
+	ClockFooterZones []clockZone   // Cities shown in the clock's world time footer
+	Clock12Hour      bool          // Show the clock in 12-hour format with AM/PM
+	ClockColor       *go3270.Color // Fixed color for the clock digits (nil = change color every minute)
+	ClockNoLogo      bool          // Don't replace the clock with the IBM logo at the top of each hour
+
+	Theme          theme // Screen color overrides
+	ClockRefreshMs int   // Milliseconds between clock redraws (default 1200)
 }
 
-func loadConfig(filename string) (*Config, error) {
-	var config Config
+var (
+	currentConfig *Config
+	configLock    sync.RWMutex
+)
 
-	// Default host file if not specified in secure3270.cnf
-	config.HostFile = "proxy3270.ovh"
+// getConfig returns the active configuration. Sessions take a snapshot when
+// they start, so a reload only affects new logins.
+func getConfig() *Config {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return currentConfig
+}
+
+// setConfig replaces the active configuration
+func setConfig(config *Config) {
+	configLock.Lock()
+	currentConfig = config
+	configLock.Unlock()
+}
+
+// secondsOrDefault converts a configured number of seconds to a duration,
+// using def when the value wasn't set
+func secondsOrDefault(seconds int, def time.Duration) time.Duration {
+	if seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return def
+}
+
+// loadHostFile reads and parses a JSON host list, or the hosts section of
+// a YAML file. The file may also be an http:// or https:// URL. Entries are
+// checked with validateHosts; with hostvalidation=strict any problem fails
+// the load, otherwise bad entries are skipped with a warning.
+func loadHostFile(config *Config, filename string) ([]Host, error) {
+	if isHostURL(filename) {
+		return loadHostURL(config, filename)
+	}
+
+	proxyData, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxy config from %s: %v", filename, err)
+	}
+	return parseHostData(filename, proxyData, config.StrictHosts)
+}
+
+// parseHostData parses and validates a host list read from filename
+func parseHostData(filename string, data []byte, strict bool) ([]Host, error) {
+	var hosts []Host
+	if isYAMLFile(filename) {
+		var err error
+		hosts, err = parseYAMLHosts(filename, data)
+		if err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(stripJSONComments(data), &hosts); err != nil {
+		return nil, fmt.Errorf("failed to parse proxy config %s: %v", filename, err)
+	}
+
+	return validateHosts(filename, hosts, strict)
+}
+
+// stripJSONComments blanks out lines starting with // or # so host files can
+// carry notes. Only whole lines are removed, so "//" inside a value is safe,
+// and the comments are replaced by spaces to keep JSON error offsets right.
+func stripJSONComments(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		trimmed := bytes.TrimSpace(line)
+		if bytes.HasPrefix(trimmed, []byte("//")) || bytes.HasPrefix(trimmed, []byte("#")) {
+			blank := bytes.Repeat([]byte(" "), len(bytes.TrimRight(line, "\r\n")))
+			line = append(blank, line[len(blank):]...)
+		}
+		out = append(out, line...)
+	}
+	return out
+}
+
+// validateHosts checks each host entry for a name, an address and a port in
+// 1-65535, and looks for duplicate names. Problems are reported with the
+// entry's position in the file (1-based, as on the host menu).
+func validateHosts(filename string, hosts []Host, strict bool) ([]Host, error) {
+	var valid []Host
+	names := make(map[string]int)
+
+	for i, host := range hosts {
+		var problem string
+		switch {
+		case strings.TrimSpace(host.Name) == "":
+			problem = "missing name"
+		case strings.TrimSpace(host.Host) == "":
+			problem = "missing host address"
+		case host.Port < 1 || host.Port > 65535:
+			problem = fmt.Sprintf("port %d out of range 1-65535", host.Port)
+		case host.Negotiation != "" && !strings.EqualFold(host.Negotiation, "tn3270") && !host.rawNegotiation():
+			problem = fmt.Sprintf("unknown negotiation %q (use tn3270 or raw)", host.Negotiation)
+		}
+		if problem == "" && host.OnConnectSend != "" {
+			if _, err := onConnectRecords(host.OnConnectSend); err != nil {
+				problem = fmt.Sprintf("invalid onconnectsend: %v", err)
+			}
+		}
+
+		if problem != "" {
+			if strict {
+				return nil, fmt.Errorf("invalid host entry %d in %s: %s", i+1, filename, problem)
+			}
+			log.Printf("Warning: skipping host entry %d in %s: %s", i+1, filename, problem)
+			continue
+		}
+
+		// Duplicate names still work by number, so they only fail strict mode
+		key := strings.ToLower(host.Name)
+		if first, ok := names[key]; ok {
+			if strict {
+				return nil, fmt.Errorf("host entry %d in %s duplicates the name %q of entry %d", i+1, filename, host.Name, first)
+			}
+			log.Printf("Warning: host entry %d in %s duplicates the name %q of entry %d", i+1, filename, host.Name, first)
+		} else {
+			names[key] = i + 1
+		}
+
+		valid = append(valid, host)
+	}
+
+	return valid, nil
+}
+
+// applyConfigValue sets a single configuration key. It is shared by the
+// key=value parser and the YAML loader so both accept the same settings.
+func applyConfigValue(config *Config, key, value string) error {
+	switch strings.ToLower(key) {
+	case "port":
+		if port, err := strconv.Atoi(value); err == nil && port > 0 {
+			config.Port = port
+		}
+	case "tlsport":
+		if port, err := strconv.Atoi(value); err == nil && port > 0 {
+			config.TLSPort = port
+		}
+	case "bindaddress":
+		config.BindAddress = value
+	case "tlsbindaddress":
+		config.TLSBindAddress = value
+	case "proxyprotocol":
+		config.ProxyProtocol = strings.ToLower(value) == "true"
+	case "unixsocket":
+		config.UnixSocket = value
+	case "allowcidrs":
+		networks, err := parseCIDRList(value)
+		if err != nil {
+			return fmt.Errorf("invalid allowcidrs: %v", err)
+		}
+		config.AllowCIDRs = networks
+	case "admincidrs":
+		networks, err := parseCIDRList(value)
+		if err != nil {
+			return fmt.Errorf("invalid admincidrs: %v", err)
+		}
+		config.AdminCIDRs = networks
+	case "breakglass":
+		config.BreakGlass = value
+	case "breakglassfile":
+		config.BreakGlassFile = value
+	case "ldapurl":
+		config.LDAPURL = value
+	case "ldapstarttls":
+		config.LDAPStartTLS = strings.ToLower(value) == "true"
+	case "ldapcafile":
+		config.LDAPCAFile = value
+	case "ldapbinddn":
+		config.LDAPBindDN = value
+	case "ldapbasedn":
+		config.LDAPBaseDN = value
+	case "ldapuserattr":
+		config.LDAPUserAttr = value
+	case "ldapgroups":
+		groups, err := parseLDAPGroups(value)
+		if err != nil {
+			return fmt.Errorf("invalid ldapgroups: %v", err)
+		}
+		config.LDAPGroups = groups
+	case "ldapfailopen":
+		config.LDAPFailClosed = strings.ToLower(value) == "false"
+	case "ldaptimeout":
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			config.LDAPTimeout = seconds
+		}
+	case "radiusservers":
+		config.RADIUSServers = nil
+		for _, server := range strings.Split(value, ",") {
+			if server = strings.TrimSpace(server); server != "" {
+				config.RADIUSServers = append(config.RADIUSServers, server)
+			}
+		}
+	case "radiussecret":
+		config.RADIUSSecret = value
+	case "radiustimeout":
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			config.RADIUSTimeout = seconds
+		}
+	case "radiusretries":
+		if retries, err := strconv.Atoi(value); err == nil && retries >= 0 {
+			config.RADIUSRetries = retries
+		}
+	case "radiushostfileattr":
+		vendor, typ, err := parseRADIUSHostFileAttr(value)
+		if err != nil {
+			return fmt.Errorf("invalid radiushostfileattr: %v", err)
+		}
+		config.RADIUSHostFileVendor, config.RADIUSHostFileType = vendor, typ
+	case "radiusfailopen":
+		config.RADIUSFailClosed = strings.ToLower(value) == "false"
+	case "denycidrs":
+		networks, err := parseCIDRList(value)
+		if err != nil {
+			return fmt.Errorf("invalid denycidrs: %v", err)
+		}
+		config.DenyCIDRs = networks
+	case "tlscert":
+		config.TLSCert = value
+	case "tlskey":
+		config.TLSKey = value
+	case "tlssnicerts":
+		certs, err := parseSNICerts(value)
+		if err != nil {
+			return fmt.Errorf("invalid tlssnicerts: %v", err)
+		}
+		config.TLSSNICerts = certs
+	case "hostfile":
+		config.HostFile = value
+	case "hosturltoken":
+		config.HostURLToken = value
+	case "hosturltimeout":
+		if timeout, err := strconv.Atoi(value); err == nil && timeout > 0 {
+			config.HostURLTimeout = timeout
+		}
+	case "hostvalidation":
+		switch strings.ToLower(value) {
+		case "strict":
+			config.StrictHosts = true
+		case "warn":
+			config.StrictHosts = false
+		default:
+			return fmt.Errorf("invalid hostvalidation %q (use warn or strict)", value)
+		}
+	case "tls":
+		// Make sure to handle any whitespace or comments in the value
+		trimmedValue := strings.TrimSpace(strings.Split(value, "#")[0])
+		config.TLSEnabled = strings.ToLower(trimmedValue) == "enabled" || strings.ToLower(trimmedValue) == "true"
+	case "tlsminversion", "tlsmaxversion":
+		// Like tls, tolerate a trailing comment on the line
+		value = strings.TrimSpace(strings.Split(value, "#")[0])
+		if _, err := parseTLSVersion(value); err == errSSLv3 {
+			return fmt.Errorf("invalid %s: %v", key, err)
+		} else if err != nil {
+			log.Printf("Warning: %v in %s, using the default", err, key)
+			value = ""
+		}
+		if strings.ToLower(key) == "tlsminversion" {
+			config.TLSMinVersion = value
+		} else {
+			config.TLSMaxVersion = value
+		}
+	case "tlsciphers":
+		suites, err := parseCipherSuites(value)
+		if err != nil {
+			return fmt.Errorf("invalid tlsciphers: %v", err)
+		}
+		config.TLSCiphers = suites
+	case "tlsmodern":
+		config.TLSModern = strings.ToLower(value) == "true"
+	case "maxconcurrenthandshakes":
+		if limit, err := strconv.Atoi(value); err == nil && limit > 0 {
+			config.MaxHandshakes = limit
+		}
+	case "tlstimeout":
+		if timeout, err := strconv.Atoi(value); err == nil && timeout > 0 {
+			config.TLSTimeout = timeout
+		}
+	case "clientcafile":
+		config.ClientCAFile = value
+	case "tlsclientauth":
+		mode := strings.ToLower(value)
+		if _, err := clientAuthType(mode); err != nil {
+			return err
+		}
+		config.ClientAuth = mode
+	case "clientcertbind":
+		config.ClientCertBind = strings.ToLower(value) == "true"
+	case "maxsessionsperuser":
+		if limit, err := strconv.Atoi(value); err == nil && limit >= 0 {
+			config.MaxSessionsPerUser = limit
+		}
+	case "maxtotalsessions":
+		if limit, err := strconv.Atoi(value); err == nil && limit >= 0 {
+			config.MaxTotalSessions = limit
+		}
+	case "maxkbps":
+		if kbps, err := strconv.Atoi(value); err == nil && kbps >= 0 {
+			config.MaxKbps = kbps
+		}
+	case "telnetkeepalivesec":
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			config.TelnetKeepAlive = seconds
+		}
+	case "restartdelay":
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			config.RestartDelay = seconds
+		}
+	case "restartafterstop":
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			config.RestartAfterStop = seconds
+		}
+	case "restartstabletime":
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			config.RestartStableTime = seconds
+		}
+	case "restartjitter":
+		if percent, err := strconv.Atoi(value); err == nil && percent >= 0 {
+			config.RestartJitter = percent
+		}
+	case "keepalive":
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			config.KeepAlive = seconds
+		}
+	case "healthport":
+		if port, err := strconv.Atoi(value); err == nil && port > 0 {
+			config.HealthPort = port
+		}
+	case "healthcheckinterval":
+		if interval, err := strconv.Atoi(value); err == nil && interval >= 0 {
+			config.HealthCheckInterval = interval
+		}
+	case "clockzones":
+		zones, err := parseClockZones(value)
+		if err != nil {
+			return fmt.Errorf("invalid clockzones: %v", err)
+		}
+		config.ClockZones = zones
+	case "clockfooterzones":
+		zones, err := parseClockZones(value)
+		if err != nil {
+			return fmt.Errorf("invalid clockfooterzones: %v", err)
+		}
+		config.ClockFooterZones = zones
+	case "themetitle", "themelabel", "themeinput", "themeerror":
+		color, err := parseColor(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", key, err)
+		}
+		switch strings.ToLower(key) {
+		case "themetitle":
+			config.Theme.Title = color
+		case "themelabel":
+			config.Theme.Label = color
+		case "themeinput":
+			config.Theme.Input = color
+		case "themeerror":
+			config.Theme.Error = color
+		}
+	case "clockcolor":
+		if strings.ToLower(value) == "cycle" {
+			config.ClockColor = nil
+			break
+		}
+		color, err := parseColor(value)
+		if err != nil {
+			return fmt.Errorf("invalid clockcolor: %v (use cycle or a color)", err)
+		}
+		config.ClockColor = color
+	case "clocklogo":
+		config.ClockNoLogo = strings.ToLower(value) == "false"
+	case "clockrefreshms":
+		ms, err := strconv.Atoi(value)
+		if err != nil || time.Duration(ms)*time.Millisecond < minClockRefresh {
+			return fmt.Errorf("invalid clockrefreshms %q (minimum %d)", value, minClockRefresh/time.Millisecond)
+		}
+		config.ClockRefreshMs = ms
+	case "clockformat":
+		switch strings.ToLower(value) {
+		case "12h":
+			config.Clock12Hour = true
+		case "24h":
+			config.Clock12Hour = false
+		default:
+			log.Printf("Warning: Unrecognized clockformat '%s', using 24h", value)
+		}
+	case "autoconnect":
+		config.AutoConnect = value
+	case "autoconnectexit":
+		switch strings.ToLower(value) {
+		case "reconnect":
+			config.AutoConnectReconnect = true
+		case "logoff":
+			config.AutoConnectReconnect = false
+		default:
+			return fmt.Errorf("invalid autoconnectexit %q (use reconnect or logoff)", value)
+		}
+	case "maxhostsdisplayed":
+		if limit, err := strconv.Atoi(value); err == nil && limit >= 0 {
+			config.MaxHostsDisplayed = limit
+		}
+	case "disconnecttokens":
+		config.DisconnectTokens = nil
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.ToUpper(strings.TrimSpace(token)); token != "" {
+				config.DisconnectTokens = append(config.DisconnectTokens, token)
+			}
+		}
+	case "onlogin":
+		config.OnLogin = value
+	case "onconnect":
+		config.OnConnect = value
+	case "ondisconnect":
+		config.OnDisconnect = value
+	case "afterhost":
+		switch strings.ToLower(value) {
+		case "menu":
+			config.AfterHostDisconnect = false
+		case "disconnect":
+			config.AfterHostDisconnect = true
+		default:
+			return fmt.Errorf("invalid afterhost %q (use menu or disconnect)", value)
+		}
+	case "recorddir":
+		config.RecordDir = value
+	case "recordall":
+		config.RecordAll = strings.ToLower(value) == "true"
+	case "auditfile":
+		config.AuditFile = value
+	case "logfile":
+		config.LogFile = value
+	case "logmaxsize":
+		if size, err := strconv.Atoi(value); err == nil && size >= 0 {
+			config.LogMaxSize = size
+		}
+	case "logkeep":
+		if keep, err := strconv.Atoi(value); err == nil && keep > 0 {
+			config.LogKeep = keep
+		}
+	case "statefile":
+		config.StateFile = value
+	case "lastloginfile":
+		config.LastLoginFile = value
+	case "motdfile":
+		config.MOTDFile = value
+	case "logontemplate":
+		config.LogonTemplate = value
+	case "helpfile":
+		config.HelpFile = value
+	case "splashseconds":
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			config.SplashSeconds = seconds
+		}
+	case "splashfile":
+		config.SplashFile = value
+	case "logonprocedure":
+		config.LogonProcedure = value
+	case "logonsize":
+		config.LogonSize = value
+	case "motdcenter":
+		config.MOTDCenter = strings.ToLower(value) == "true"
+	case "maxloginattempts":
+		if attempts, err := strconv.Atoi(value); err == nil && attempts > 0 {
+			config.MaxLoginAttempts = attempts
+		}
+	case "uppercaseuserid":
+		config.UppercaseUserid = strings.ToLower(value) == "true"
+	case "caseinsensitiveusers":
+		config.CaseSensitiveUsers = strings.ToLower(value) == "false"
+	case "duplicateusers":
+		switch strings.ToLower(value) {
+		case "warn":
+			config.DuplicateUsersFatal = false
+		case "fail":
+			config.DuplicateUsersFatal = true
+		default:
+			return fmt.Errorf("invalid duplicateusers %q (use warn or fail)", value)
+		}
+	case "passwordhistory":
+		if depth, err := strconv.Atoi(value); err == nil && depth >= 0 {
+			config.PasswordHistory = depth
+		}
+	case "minpasswordlength":
+		if length, err := strconv.Atoi(value); err == nil && length > 0 {
+			config.MinPasswordLength = length
+		}
+	case "negotiatetimeout":
+		if timeout, err := strconv.Atoi(value); err == nil && timeout > 0 {
+			config.NegotiateTimeout = timeout
+		}
+	case "authtimeout":
+		if timeout, err := strconv.Atoi(value); err == nil && timeout > 0 {
+			config.AuthTimeout = timeout
+		}
+	case "dialtimeout":
+		if timeout, err := strconv.Atoi(value); err == nil && timeout > 0 {
+			config.DialTimeout = timeout
+		}
+	case "firstbytetimeout":
+		if timeout, err := strconv.Atoi(value); err == nil && timeout > 0 {
+			config.FirstByteTimeout = timeout
+		}
+	case "scanbanthreshold":
+		if threshold, err := strconv.Atoi(value); err == nil && threshold >= 0 {
+			config.ScanBanThreshold = threshold
+		}
+	case "scanbanminutes":
+		if minutes, err := strconv.Atoi(value); err == nil && minutes > 0 {
+			config.ScanBanMinutes = minutes
+		}
+	case "dialretries":
+		if retries, err := strconv.Atoi(value); err == nil && retries >= 0 {
+			config.DialRetries = retries
+		}
+	case "dialbackoffms":
+		if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+			config.DialBackoffMs = ms
+		}
+	case "renegotiateattempts":
+		if attempts, err := strconv.Atoi(value); err == nil && attempts > 0 {
+			config.RenegotiateTries = attempts
+		}
+	case "renegotiatedelayms":
+		if delay, err := strconv.Atoi(value); err == nil && delay > 0 {
+			config.RenegotiateDelayMs = delay
+		}
+	case "unnegotiatetimeout":
+		if timeout, err := strconv.Atoi(value); err == nil && timeout > 0 {
+			config.UnNegotiateTimeout = timeout
+		}
+	case "idletimeout":
+		if timeout, err := strconv.Atoi(value); err == nil && timeout >= 0 {
+			config.IdleTimeout = timeout
+		}
+	case "idlewarning":
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			config.IdleWarning = seconds
+		}
+	case "maxsessionminutes":
+		if minutes, err := strconv.Atoi(value); err == nil && minutes > 0 {
+			config.MaxSessionMinutes = minutes
+		}
+	case "maxfailedlogins":
+		if attempts, err := strconv.Atoi(value); err == nil && attempts >= 0 {
+			config.MaxFailedLogins = attempts
+		}
+	case "lockoutminutes":
+		if minutes, err := strconv.Atoi(value); err == nil && minutes > 0 {
+			config.LockoutMinutes = minutes
+		}
+	default:
+		if strings.HasPrefix(strings.ToLower(key), "tlslistener.") {
+			return setTLSListenerValue(config, strings.ToLower(key), value)
+		}
+	}
+
+	return nil
+}
+
+// loadHostFiles loads a comma separated list of host files and concatenates
+// them in the listed order. A host that appears in more than one file (same
+// name, address and port) is only kept the first time.
+func loadHostFiles(config *Config, filenames string) ([]Host, error) {
+	var hosts []Host
+	seen := make(map[string]bool)
+
+	for _, filename := range strings.Split(filenames, ",") {
+		filename = strings.TrimSpace(filename)
+		if filename == "" {
+			continue
+		}
+
+		fileHosts, err := loadHostFile(config, filename)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, host := range fileHosts {
+			key := host.Name + "\x00" + net.JoinHostPort(host.Host, strconv.Itoa(host.Port))
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts, nil
+}
 
-	// First read the secure3270.cnf file for configuration
+// parseLegacyConfig reads the key=value format of secure3270.cnf
+func parseLegacyConfig(filename string, config *Config) error {
 	file, err := os.Open(filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open config file: %v", err)
+		return fmt.Errorf("failed to open config file: %v", err)
 	}
 	defer file.Close()
 
@@ -81,50 +854,73 @@ func loadConfig(filename string) (*Config, error) {
 		key := strings.TrimSpace(parts[0])
 		value := strings.TrimSpace(parts[1])
 
-		switch strings.ToLower(key) {
-		case "port":
-			if port, err := strconv.Atoi(value); err == nil && port > 0 {
-				config.Port = port
-			}
-		case "tlsport":
-			if port, err := strconv.Atoi(value); err == nil && port > 0 {
-				config.TLSPort = port
-			}
-		case "tlscert":
-			config.TLSCert = value
-		case "tlskey":
-			config.TLSKey = value
-		case "hostfile":
-			config.HostFile = value
-		case "tls":
-			// Make sure to handle any whitespace or comments in the value
-			trimmedValue := strings.TrimSpace(strings.Split(value, "#")[0])
-			config.TLSEnabled = strings.ToLower(trimmedValue) == "enabled"
-		case "tlsminversion":
-			config.TLSMinVersion = value
-		case "tlsmaxversion":
-			config.TLSMaxVersion = value
-		case "tlstimeout":
-			if timeout, err := strconv.Atoi(value); err == nil && timeout > 0 {
-				config.TLSTimeout = timeout
-			}
+		if err := applyConfigValue(config, key, value); err != nil {
+			return err
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading config: %v", err)
+		return fmt.Errorf("error reading config: %v", err)
 	}
 
-	// Now load the proxy hosts configuraton from the speficied file
-	proxyData, err := os.ReadFile(config.HostFile)
+	return nil
+}
+
+func loadConfig(filename string) (*Config, error) {
+	var config Config
+
+	// Default host file if not specified in secure3270.cnf
+	config.HostFile = "proxy3270.ovh"
+	config.RADIUSRetries = defaultRADIUSRetries
+
+	// First read the secure3270.cnf (or YAML) file for configuration. The
+	// file may be left out entirely when everything comes from the environment.
+	envValues := envConfigValues()
+	if !fileExists(filename) && len(envValues) > 0 {
+		log.Printf("Config file %s not found, using environment settings only", filename)
+	} else if isYAMLFile(filename) {
+		if err := parseYAMLConfig(filename, &config); err != nil {
+			return nil, err
+		}
+	} else if err := parseLegacyConfig(filename, &config); err != nil {
+		return nil, err
+	}
+
+	// Environment variables take precedence over the file
+	envKeys, err := applyEnvOverrides(&config, envValues)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read proxy config from %s: %v", config.HostFile, err)
+		return nil, err
 	}
 
-	if err := json.Unmarshal(proxyData, &config.Hosts); err != nil {
-		return nil, fmt.Errorf("failed to parse proxy config: %v", err)
+	// A TLS version range that allows nothing would silently refuse every client
+	if config.TLSEnabled {
+		if _, _, err := tlsVersionRange(&config); err != nil {
+			return nil, err
+		}
+		if err := checkTLSListeners(&config); err != nil {
+			return nil, err
+		}
 	}
 
+	// A broken LDAP setup would otherwise only show when users can't log in
+	if config.LDAPURL != "" {
+		if err := checkLDAPConfig(&config); err != nil {
+			return nil, err
+		}
+	}
+	if len(config.RADIUSServers) > 0 {
+		if err := checkRADIUSConfig(&config); err != nil {
+			return nil, err
+		}
+	}
+
+	// Now load the proxy hosts configuraton from the speficied file
+	hosts, err := loadHostFile(&config, config.HostFile)
+	if err != nil {
+		return nil, err
+	}
+	config.Hosts = hosts
+
 	// Set default port if not specified
 	if config.Port == 0 {
 		config.Port = 3270
@@ -132,12 +928,61 @@ func loadConfig(filename string) (*Config, error) {
 
 	// Display configuration summary
 	log.Printf("Configuration loaded successfully from %s:", filename)
+	if len(envKeys) > 0 {
+		log.Printf("  - Set from environment: %s", strings.Join(envKeys, ", "))
+	}
 	log.Printf("  - Standard listener port: %d", config.Port)
+	if config.BindAddress != "" {
+		log.Printf("  - Bind address: %s", config.BindAddress)
+	}
+	if config.TLSBindAddress != "" {
+		log.Printf("  - TLS bind address: %s", config.TLSBindAddress)
+	}
+	if config.ProxyProtocol {
+		log.Printf("  - PROXY protocol headers required on all connections")
+	}
+	if config.UnixSocket != "" {
+		log.Printf("  - UNIX socket: %s", config.UnixSocket)
+	}
+	if len(config.AllowCIDRs) > 0 {
+		log.Printf("  - Allowed client networks: %d entries", len(config.AllowCIDRs))
+	}
+	if len(config.DenyCIDRs) > 0 {
+		log.Printf("  - Denied client networks: %d entries", len(config.DenyCIDRs))
+	}
+	if config.ScanBanThreshold > 0 {
+		log.Printf("  - Banning scanners for %v after %d rejected connections", scanBanDuration(&config), config.ScanBanThreshold)
+	}
+	if breakGlassConfigured(&config) {
+		log.Printf("  - Break-glass login configured (TLS or %d admin networks only)", len(config.AdminCIDRs))
+	}
+	if config.LDAPURL != "" {
+		fallback := "users.cnf"
+		if config.LDAPFailClosed {
+			fallback = "refuse logins"
+		}
+		log.Printf("  - LDAP authentication: %s (%d group mappings, when unreachable: %s)",
+			config.LDAPURL, len(config.LDAPGroups), fallback)
+	}
+	if len(config.RADIUSServers) > 0 {
+		fallback := "users.cnf"
+		if config.RADIUSFailClosed {
+			fallback = "refuse logins"
+		}
+		log.Printf("  - RADIUS authentication: %s (when unreachable: %s)", strings.Join(config.RADIUSServers, ", "), fallback)
+	}
 	if config.TLSEnabled {
-		if config.TLSPort > 0 && config.TLSCert != "" && config.TLSKey != "" {
+		if config.TLSPort > 0 && (config.TLSCert != "" && config.TLSKey != "" || len(config.TLSSNICerts) > 0) {
 			log.Printf("  - TLS listener enabled on port: %d", config.TLSPort)
-			log.Printf("  - TLS certificate: %s", config.TLSCert)
-			log.Printf("  - TLS key: %s", config.TLSKey)
+			if config.TLSCert != "" && config.TLSKey != "" {
+				log.Printf("  - TLS certificate: %s", config.TLSCert)
+				log.Printf("  - TLS key: %s", config.TLSKey)
+			} else {
+				log.Printf("  - TLS certificate: none, clients must ask for a server name from tlssnicerts")
+			}
+			for _, entry := range config.TLSSNICerts {
+				log.Printf("  - TLS certificate for %s: %s", entry.ServerName, entry.CertFile)
+			}
 
 			// Display TLS version settings
 			if config.TLSMinVersion != "" {
@@ -152,11 +997,29 @@ func loadConfig(filename string) (*Config, error) {
 				log.Printf("  - TLS maximum version: TLS1.3 (default)")
 			}
 
+			if config.TLSModern {
+				log.Printf("  - TLS modern mode: TLS1.2+ and AEAD cipher suites only")
+			}
+			if len(config.TLSCiphers) > 0 {
+				log.Printf("  - TLS cipher suites: %d configured", len(config.TLSCiphers))
+			}
+
+			if config.MaxHandshakes > 0 {
+				log.Printf("  - Maximum concurrent TLS handshakes: %d", config.MaxHandshakes)
+			}
+
 			if config.TLSTimeout > 0 {
 				log.Printf("  - TLS connection timeout: %d seconds", config.TLSTimeout)
 			} else {
 				log.Printf("  - TLS connection timeout: 60 seconds (default)")
 			}
+
+			if config.ClientCAFile != "" {
+				log.Printf("  - TLS client certificate CA: %s", config.ClientCAFile)
+				if config.ClientCertBind {
+					log.Printf("  - Login username must match client certificate CN")
+				}
+			}
 		} else {
 			log.Printf("  - WARNING: TLS is enabled but configuration is incomplete")
 			if config.TLSPort == 0 {
@@ -169,134 +1032,291 @@ func loadConfig(filename string) (*Config, error) {
 				log.Printf("    - TLS key not specified")
 			}
 		}
+		for _, listener := range config.TLSListeners {
+			listenerConfig := listener.config(&config)
+			minVersion, maxVersion, _ := tlsVersionRange(listenerConfig)
+			cert := listenerConfig.TLSCert
+			if cert == "" {
+				cert = "none"
+			}
+			log.Printf("  - TLS listener %s on port %d: %s to %s, certificate %s (%d by server name)",
+				listener.Name, listenerConfig.TLSPort, tlsVersionToString(minVersion),
+				tlsVersionToString(maxVersion), cert, len(listenerConfig.TLSSNICerts))
+		}
 	} else {
 		log.Printf("  - TLS listener disabled")
 	}
 	log.Printf("  - Host list file: %s (%d hosts)", config.HostFile, len(config.Hosts))
+	if config.MaxSessionsPerUser > 0 {
+		log.Printf("  - Maximum sessions per user: %d", config.MaxSessionsPerUser)
+	}
+	if config.MaxTotalSessions > 0 {
+		log.Printf("  - Maximum total sessions: %d", config.MaxTotalSessions)
+	}
+	if config.MaxKbps > 0 {
+		log.Printf("  - Session bandwidth limit: %d kbps per direction", config.MaxKbps)
+	}
+	if config.HealthCheckInterval > 0 {
+		log.Printf("  - Host health check interval: %d seconds", config.HealthCheckInterval)
+	}
+	if config.HealthPort > 0 {
+		log.Printf("  - Health probe port: %d", config.HealthPort)
+	}
+	if config.MaxLoginAttempts > 0 {
+		log.Printf("  - Maximum login attempts per connection: %d", config.MaxLoginAttempts)
+	}
+	if config.CaseSensitiveUsers {
+		log.Printf("  - Userids are case sensitive")
+	}
+	if config.UppercaseUserid {
+		log.Printf("  - Userids are uppercased at logon")
+	}
+	if config.AutoConnect != "" {
+		log.Printf("  - Auto-connect host: %s", config.AutoConnect)
+	}
+	if config.AfterHostDisconnect {
+		log.Printf("  - Users are logged off when a host session ends")
+	}
+	if config.MaxHostsDisplayed > 0 {
+		log.Printf("  - Host menu lists at most %d hosts", config.MaxHostsDisplayed)
+	}
+	if len(config.DisconnectTokens) > 0 {
+		log.Printf("  - Host menu disconnect selections: %s", strings.Join(config.DisconnectTokens, ", "))
+	}
+	for _, hook := range []struct{ event, command string }{
+		{"login", config.OnLogin}, {"connect", config.OnConnect}, {"disconnect", config.OnDisconnect},
+	} {
+		if hook.command != "" {
+			log.Printf("  - On %s hook: %s", hook.event, hook.command)
+		}
+	}
+	if config.RecordDir != "" {
+		if config.RecordAll {
+			log.Printf("  - Recording all sessions to %s", config.RecordDir)
+		} else {
+			log.Printf("  - Recording flagged users' sessions to %s", config.RecordDir)
+		}
+	}
+	if config.AuditFile != "" {
+		log.Printf("  - Audit log: %s", config.AuditFile)
+	}
+	if config.LogFile != "" && config.LogMaxSize > 0 {
+		keep := config.LogKeep
+		if keep <= 0 {
+			keep = defaultLogKeep
+		}
+		log.Printf("  - Log file: %s, rotated at %d MB, %d kept", config.LogFile, config.LogMaxSize, keep)
+	} else if config.LogFile != "" {
+		log.Printf("  - Log file: %s", config.LogFile)
+	}
+	if config.MOTDFile != "" {
+		log.Printf("  - Message of the day: %s", config.MOTDFile)
+	}
+	if config.LogonTemplate != "" {
+		log.Printf("  - Logon screen template: %s", config.LogonTemplate)
+	}
+	if config.LogonProcedure != "" || config.LogonSize != "" {
+		log.Printf("  - Logon screen procedure %q, size %q", config.LogonProcedure, config.LogonSize)
+	}
+	if config.HelpFile != "" {
+		log.Printf("  - Help file: %s", config.HelpFile)
+	}
+	if config.SplashSeconds > 0 {
+		art := "IBM logo"
+		if config.SplashFile != "" {
+			art = config.SplashFile
+		}
+		log.Printf("  - Splash screen for %d seconds (%s)", config.SplashSeconds, art)
+	}
+	if t := config.Theme; t.Title != nil || t.Label != nil || t.Input != nil || t.Error != nil {
+		log.Printf("  - Custom screen colors enabled")
+	}
+	log.Printf("  - Timeouts: first byte %v, negotiate %v, logon %v, dial %v, un-negotiate %v, keepalive %v",
+		firstByteTimeout(&config),
+		secondsOrDefault(config.NegotiateTimeout, 30*time.Second),
+		secondsOrDefault(config.AuthTimeout, 300*time.Second),
+		secondsOrDefault(config.DialTimeout, 15*time.Second),
+		secondsOrDefault(config.UnNegotiateTimeout, 10*time.Second),
+		secondsOrDefault(config.KeepAlive, 60*time.Second))
+	if config.RestartDelay > 0 || config.RestartAfterStop > 0 || config.RestartStableTime > 0 || config.RestartJitter > 0 {
+		log.Printf("  - Listener restarts: after failure %v, after stop %v, immediate after %v uptime, jitter %d%%",
+			secondsOrDefault(config.RestartDelay, defaultRestartDelay),
+			secondsOrDefault(config.RestartAfterStop, defaultRestartAfterStop),
+			secondsOrDefault(config.RestartStableTime, defaultRestartStableTime), config.RestartJitter)
+	}
+	if config.TelnetKeepAlive > 0 {
+		log.Printf("  - Telnet NOP keepalive after %d idle seconds", config.TelnetKeepAlive)
+	}
+	if config.DialRetries > 0 {
+		backoff := defaultDialBackoff
+		if config.DialBackoffMs > 0 {
+			backoff = time.Duration(config.DialBackoffMs) * time.Millisecond
+		}
+		log.Printf("  - Host dial retries: %d, first backoff %v", config.DialRetries, backoff)
+	}
+	if config.IdleTimeout > 0 {
+		if config.IdleWarning > 0 && config.IdleWarning < config.IdleTimeout {
+			log.Printf("  - Host menu idle timeout: %d seconds, warning %d seconds before", config.IdleTimeout, config.IdleWarning)
+		} else {
+			log.Printf("  - Host menu idle timeout: %d seconds", config.IdleTimeout)
+		}
+	}
+	if config.MaxSessionMinutes > 0 {
+		log.Printf("  - Maximum session length: %d minutes", config.MaxSessionMinutes)
+	}
+	if config.MaxFailedLogins > 0 {
+		log.Printf("  - Account lockout after %d failed logins for %v",
+			config.MaxFailedLogins, lockoutWindow(&config))
+	}
 
 	return &config, nil
 }
 
-func startTLSServer(config *Config, debug, debug3270, trace bool) {
-	if config.TLSPort == 0 {
-		log.Printf("TLS enabled but port not specified, can't start TLS server")
-		return
+// startTLSServer runs a TLS listener. name is the tlslistener profile, or
+// empty for the main TLS listener.
+func startTLSServer(config *Config, name string, debug, debug3270, trace bool) {
+	server := "TLS server"
+	if name != "" {
+		server = "TLS server " + name
 	}
 
-	// Check if certificate files exist
-	if _, err := os.Stat(config.TLSCert); os.IsNotExist(err) {
-		log.Printf("TLS certificate file %s not found, can't start TLS server", config.TLSCert)
+	if config.TLSPort == 0 {
+		log.Printf("TLS enabled but port not specified, can't start %s", server)
 		return
 	}
 
-	if _, err := os.Stat(config.TLSKey); os.IsNotExist(err) {
-		log.Printf("TLS key file %s not found, can't start TLS server", config.TLSKey)
-		return
+	// Check if certificate files exist. The default certificate may be left
+	// out when there are certificates by server name.
+	var files []string
+	if config.TLSCert != "" || config.TLSKey != "" || len(config.TLSSNICerts) == 0 {
+		files = append(files, config.TLSCert, config.TLSKey)
+	}
+	for _, entry := range config.TLSSNICerts {
+		files = append(files, entry.CertFile, entry.KeyFile)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			log.Printf("TLS certificate or key file %s not found, can't start %s", file, server)
+			return
+		}
 	}
 
 	// TLS server auto-recovery loop
-	for {
-		startTime := time.Now()
-		if err := runTLSServer(config, debug, debug3270, trace); err != nil {
-			log.Printf("TLS server error: %v", err)
-
-			// If the server ran for a reasonable amount of time before failing,
-			// it's likely a temporary issue, so we can restart immediately
-			if time.Since(startTime) > 5*time.Minute {
-				log.Printf("TLS server restarting immediately...")
-			} else {
-				// If it failed quickly, there might be a more serious issue
-				// Wait before retrying to avoid rapid restart loops
-				log.Printf("TLS server will restart in 30 seconds...")
-				time.Sleep(30 * time.Second)
-			}
-		} else {
-			// Normal shutdown - wait before restarting
-			log.Printf("TLS server shut down, restarting in 10 seconds...")
-			time.Sleep(10 * time.Second)
+	superviseServer(server, func() error {
+		return runTLSServer(config, name, debug, debug3270, trace)
+	})
+}
+
+func runTLSServer(config *Config, name string, debug, debug3270, trace bool) error {
+	// Tell the listeners apart in the log
+	logf := log.Printf
+	if name != "" {
+		logf = func(format string, v ...interface{}) {
+			log.Printf("TLS listener "+name+": "+format, v...)
 		}
 	}
-}
 
-func runTLSServer(config *Config, debug, debug3270, trace bool) error {
-	cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+	certs, err := loadListenerCertificates(config)
 	if err != nil {
-		return fmt.Errorf("failed to load TLS certificates: %v", err)
-	}
-
-	// Set TLS version based on configuration or use defaults
-	var minVersion uint16 = tls.VersionTLS10 // Default minimum
-	var maxVersion uint16 = tls.VersionTLS13 // Default maximum
-
-	// Parse minimum TLS version from config
-	if config.TLSMinVersion != "" {
-		switch strings.ToLower(config.TLSMinVersion) {
-		case "tls1.0", "tls1", "tlsv1.0", "tlsv1":
-			minVersion = tls.VersionTLS10
-		case "tls1.1", "tlsv1.1":
-			minVersion = tls.VersionTLS11
-		case "tls1.2", "tlsv1.2":
-			minVersion = tls.VersionTLS12
-		case "tls1.3", "tlsv1.3":
-			minVersion = tls.VersionTLS13
-		default:
-			log.Printf("Warning: Unrecognized TLS minimum version '%s', using TLS 1.0", config.TLSMinVersion)
-		}
+		return err
 	}
 
-	// Parse maximum TLS version from config
-	if config.TLSMaxVersion != "" {
-		switch strings.ToLower(config.TLSMaxVersion) {
-		case "tls1.0", "tls1", "tlsv1.0", "tlsv1":
-			maxVersion = tls.VersionTLS10
-		case "tls1.1", "tlsv1.1":
-			maxVersion = tls.VersionTLS11
-		case "tls1.2", "tlsv1.2":
-			maxVersion = tls.VersionTLS12
-		case "tls1.3", "tlsv1.3":
-			maxVersion = tls.VersionTLS13
-		default:
-			log.Printf("Warning: Unrecognized TLS maximum version '%s', using TLS 1.3", config.TLSMaxVersion)
-		}
+	minVersion, maxVersion, err := tlsVersionRange(config)
+	if err != nil {
+		return err
 	}
 
 	// Log TLS version configuration
-	log.Printf("Using TLS version range: %s to %s",
+	logf("Using TLS version range: %s to %s",
 		tlsVersionToString(minVersion),
 		tlsVersionToString(maxVersion))
 
+	suites := cipherSuites(config)
+	if config.TLSModern {
+		// An explicit tlsciphers list is still limited to AEAD suites
+		var aead []uint16
+		for _, id := range suites {
+			if isAEADSuite(id) {
+				aead = append(aead, id)
+			}
+		}
+		if len(aead) == 0 {
+			return fmt.Errorf("tlsmodern is enabled but tlsciphers contains no AEAD cipher suites")
+		}
+		suites = aead
+	}
+	logf("Using TLS cipher suites: %s", cipherSuiteNames(suites))
+
 	tlsConfig := &tls.Config{
-		Certificates:             []tls.Certificate{cert},
+		GetCertificate:           certs.getCertificate,
 		MinVersion:               minVersion,
 		MaxVersion:               maxVersion,
 		PreferServerCipherSuites: true,
-		InsecureSkipVerify:       true,
-		ClientAuth:               tls.NoClientCert,
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-			tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_RSA_WITH_AES_128_CBC_SHA,
-			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-			tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
-		},
-	}
-
-	listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", config.TLSPort), tlsConfig)
+		CipherSuites:             suites,
+	}
+
+	// Work out how client certificates are handled. Without a CA nothing can
+	// be verified, so only "none" is allowed then.
+	mode := config.ClientAuth
+	if mode == "" {
+		mode = "none"
+		if config.ClientCAFile != "" {
+			mode = "require"
+		}
+	}
+	clientAuth, err := clientAuthType(mode)
+	if err != nil {
+		return err
+	}
+	if clientAuth != tls.NoClientCert && config.ClientCAFile == "" {
+		return fmt.Errorf("tlsclientauth=%s needs a clientcafile to verify certificates against", mode)
+	}
+	tlsConfig.ClientAuth = clientAuth
+
+	switch clientAuth {
+	case tls.NoClientCert:
+		logf("TLS client certificates: not requested")
+	case tls.VerifyClientCertIfGiven:
+		logf("TLS client certificates: requested, verified against %s when presented", config.ClientCAFile)
+	case tls.RequireAndVerifyClientCert:
+		logf("TLS client certificates: required and verified against %s", config.ClientCAFile)
+	}
+
+	// Load the trusted CA for client certificates
+	if clientAuth != tls.NoClientCert {
+		caData, err := os.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file %s: %v", config.ClientCAFile, err)
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caData) {
+			return fmt.Errorf("no valid certificates found in client CA file %s", config.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = caPool
+	}
+
+	// Listen on plain TCP and start TLS per connection, so a PROXY protocol
+	// header can be read before the handshake
+	listener, err := net.Listen("tcp", listenAddress(config.tlsBindAddress(), config.TLSPort))
 	if err != nil {
-		return fmt.Errorf("failed to start TLS listener: %v", err)
+		return &listenError{fmt.Errorf("failed to start TLS listener: %v", err)}
 	}
 	defer listener.Close()
 
-	log.Printf("TLS Proxy3270 listening on port %d", config.TLSPort)
+	logf("TLS Proxy3270 listening on %s", listener.Addr())
+	listenerStarted()
+	defer listenerStopped()
+
+	// Bound the number of connections in the TLS handshake and telnet
+	// negotiation at once; the logon screen runs without a slot
+	var handshakes chan struct{}
+	if config.MaxHandshakes > 0 {
+		handshakes = make(chan struct{}, config.MaxHandshakes)
+	}
 
 	for {
-		// Accept connections without a timeout - TLS listeners don't support SetDeadline
-		// like TCP listeners do. We'll handle timeouts at the connection level instead.
+		// Accept connections without a timeout; timeouts are handled at the
+		// connection level instead
 		conn, err := listener.Accept()
 
 		if err != nil {
@@ -309,38 +1329,91 @@ func runTLSServer(config *Config, debug, debug3270, trace bool) error {
 			return fmt.Errorf("TLS accept error: %v", err)
 		}
 
+		// Refuse new connections outright while the limit is reached
+		release := func() {}
+		if handshakes != nil {
+			select {
+			case handshakes <- struct{}{}:
+				var once sync.Once
+				release = func() { once.Do(func() { <-handshakes }) }
+			default:
+				log.Printf("SECURITY: too many TLS handshakes in progress, dropping connection from %s", conn.RemoteAddr())
+				conn.Close()
+				continue
+			}
+		}
+
 		// Handle each connection in a separate goroutine
-		go handleTLSConnection(conn, config, debug, debug3270, trace)
+		go handleTLSConnection(conn, tlsConfig, getConfig(), release, debug, debug3270, trace)
 	}
 }
 
-func handleTLSConnection(conn net.Conn, config *Config, debug, debug3270, trace bool) {
+// handleTLSConnection runs a TLS client from handshake to logout. release
+// frees the connection's handshake slot and is called once the TLS handshake
+// and telnet negotiation are over.
+func handleTLSConnection(rawConn net.Conn, tlsConfig *tls.Config, config *Config, release func(), debug, debug3270, trace bool) {
 	// Ensure connection is always closed when we're done
-	defer conn.Close()
+	defer rawConn.Close()
+	defer release()
 
-	// For TLS connections, add a small delay to ensure handshake completes
-	time.Sleep(500 * time.Millisecond)
+	setKeepAlive(rawConn, config)
 
-	// Set initial timeout for telnet negotiation - use configured timeout or default to 60 seconds
+	// Set initial timeout for the TLS handshake and telnet negotiation - use configured timeout or default to 60 seconds
 	timeoutSeconds := 60
 	if config.TLSTimeout > 0 {
 		timeoutSeconds = config.TLSTimeout
 	}
-	conn.SetDeadline(time.Now().Add(time.Duration(timeoutSeconds) * time.Second))
+	rawConn.SetDeadline(time.Now().Add(time.Duration(timeoutSeconds) * time.Second))
+
+	// Learn the real client address from the load balancer
+	if config.ProxyProtocol {
+		proxiedConn, err := readProxyHeader(rawConn)
+		if err != nil {
+			log.Printf("SECURITY: %v", err)
+			return
+		}
+		rawConn = proxiedConn
+	}
+
+	// Drop blocked networks before doing any TLS or telnet work
+	if !ipAllowed(config, rawConn.RemoteAddr()) {
+		log.Printf("SECURITY: refused TLS connection from %s", rawConn.RemoteAddr())
+		return
+	}
+	if isBanned(config, rawConn.RemoteAddr()) {
+		return
+	}
+
+	var conn net.Conn = tls.Server(rawConn, tlsConfig)
+	defer conn.Close()
+
+	// Complete the TLS handshake explicitly so failures are caught right here
+	// and the connection state below is final
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			noteRejected(config, conn.RemoteAddr(), "TLS handshake failed: "+err.Error())
+			return
+		}
+	}
 
 	// Log TLS connection details if debugging is enabled
 	if debug {
 		if tlsConn, ok := conn.(*tls.Conn); ok {
 			tlsState := tlsConn.ConnectionState()
-			log.Printf("TLS Connection: Version=%v, CipherSuite=%v, HandshakeComplete=%v",
+			log.Printf("TLS Connection: Version=%v, CipherSuite=%v, ServerName=%q, HandshakeComplete=%v",
 				tlsVersionToString(tlsState.Version),
 				tls.CipherSuiteName(tlsState.CipherSuite),
+				tlsState.ServerName,
 				tlsState.HandshakeComplete)
 		}
 	}
 
 	// Negotiate telnet protocol with direct error handling
-	if err := go3270.NegotiateTelnet(conn); err != nil {
+	term, err := negotiateTelnet(conn, firstByteTimeout(config))
+	if errors.Is(err, errNotTN3270) {
+		noteRejected(config, conn.RemoteAddr(), err.Error())
+		return
+	} else if err != nil {
 		log.Printf("TLS telnet negotiation failed: %v", err)
 		return
 	}
@@ -348,8 +1421,39 @@ func handleTLSConnection(conn net.Conn, config *Config, debug, debug3270, trace
 	// After successful negotiation, remove the deadline for regular operation
 	conn.SetDeadline(time.Time{})
 
+	// Handshake and negotiation are done, let the next connection in. The
+	// logon screen is bounded by authtimeout instead.
+	release()
+
+	// Pick up the identity from a verified client certificate, if any
+	certUser := ""
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsState := tlsConn.ConnectionState()
+		auditLog("CONNECT", "ip=%s tls=%s cipher=%s", conn.RemoteAddr(),
+			tlsVersionToString(tlsState.Version), tls.CipherSuiteName(tlsState.CipherSuite))
+		if certs := tlsState.PeerCertificates; len(certs) > 0 {
+			certUser = certs[0].Subject.CommonName
+			log.Printf("TLS client certificate presented for CN=%s", certUser)
+		}
+	}
+
+	if err := ShowSplash(conn, config); err != nil {
+		log.Printf("TLS client %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
 	// Handle authentication first
-	authSession, err := HandleAuth(conn)
+	authSession, err := HandleAuth(conn, config, certUser)
+
+	// Keep the TLS details for the status screen
+	if authSession != nil {
+		authSession.terminal = term
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			authSession.tlsState = &state
+		}
+	}
+
 	if err != nil {
 		log.Printf("TLS authentication failed: %v", err)
 		if err.Error() == "user requested logoff with PF9" {
@@ -365,6 +1469,49 @@ func handleTLSConnection(conn net.Conn, config *Config, debug, debug3270, trace
 
 	log.Printf("TLS user %s authenticated successfully", authSession.username)
 
+	// Now proceed with the normal proxy3270 host selection and connection handling
+	runUserSession(conn, config, authSession)
+}
+
+// runUserSession applies session limits and the user's own host list, then
+// hands an authenticated connection over to the host menu
+func runUserSession(conn net.Conn, config *Config, authSession *authSession) {
+	// Keep idle sessions alive through firewalls from here on
+	conn, stopKeepalive := startTelnetKeepalive(conn, config)
+	defer stopKeepalive()
+
+	// Enforce per-user and global session limits
+	if !acquireSession(authSession.username, config) {
+		showDisconnectScreen(conn, config, "Too many active sessions. Please try again later.")
+		return
+	}
+	defer releaseSession(authSession.username)
+
+	// Make the session visible in the admin console
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	authSession.ctx = ctx
+	authSession.sessionID = sessionRegistry.Register(authSession, conn, cancel)
+	defer sessionRegistry.Deregister(authSession.sessionID)
+
+	defer func() {
+		auditLog("SESSION_END", "user=%q ip=%s duration=%s", authSession.username,
+			authSession.remoteAddr, time.Since(authSession.loginTime).Round(time.Second))
+	}()
+
+	// Tell the user about their previous logon, then show the message of
+	// the day before the host menu
+	if lastLoginEnabled() {
+		if err := ShowLastLogin(conn, config, authSession); err != nil {
+			log.Printf("Error showing last logon to %s: %v", authSession.username, err)
+			return
+		}
+	}
+	if err := ShowMOTD(conn, config); err != nil {
+		log.Printf("Error showing MOTD to %s: %v", authSession.username, err)
+		return
+	}
+
 	// Create a copy of the config to override with user-specific settings if needed
 	userConfig := *config
 
@@ -373,28 +1520,136 @@ func handleTLSConnection(conn net.Conn, config *Config, debug, debug3270, trace
 		log.Printf("Using user-specific host file: %s", authSession.hostFile)
 		userConfig.HostFile = authSession.hostFile
 
-		// Load hosts from the user-specific file
-		proxyData, err := os.ReadFile(userConfig.HostFile)
+		// Load hosts from the user-specific file(s)
+		hosts, err := loadHostFiles(&userConfig, userConfig.HostFile)
 		if err != nil {
-			log.Printf("Failed to read user host file %s: %v, falling back to default",
-				userConfig.HostFile, err)
-		} else {
-			// Parse the hosts from the user's host file
-			var hosts []Host
-			if err := json.Unmarshal(proxyData, &hosts); err != nil {
-				log.Printf("Failed to parse user host file %s: %v, falling back to default",
-					userConfig.HostFile, err)
-			} else {
-				// Successfully loaded user's hosts
-				userConfig.Hosts = hosts
+			reason := "Your host list file contains errors."
+			for _, filename := range strings.Split(userConfig.HostFile, ",") {
+				if _, statErr := os.Stat(strings.TrimSpace(filename)); os.IsNotExist(statErr) {
+					reason = "Your host list file does not exist."
+					break
+				}
+			}
+			log.Printf("Failed to load host file for %s: %v", authSession.username, err)
+			auditLog("HOSTFILE_ERROR", "user=%q ip=%s file=%s error=%q", authSession.username,
+				authSession.remoteAddr, userConfig.HostFile, err.Error())
+
+			// Fall back to the default list only if it has something to offer
+			fallback := config.Hosts
+			if len(authSession.allowedHosts) > 0 {
+				fallback = filterHosts(fallback, authSession.allowedHosts)
+			}
+			if !showHostListError(conn, config, reason, len(fallback) > 0) {
+				return
 			}
+			log.Printf("Showing the default host list to %s", authSession.username)
+		} else {
+			// Successfully loaded user's hosts
+			userConfig.Hosts = hosts
 		}
 	}
 
-	// Now proceed with the normal proxy3270 host selection and connection handling
+	// Only show the hosts this user is allowed to use
+	if len(authSession.allowedHosts) > 0 {
+		userConfig.Hosts = filterHosts(userConfig.Hosts, authSession.allowedHosts)
+		log.Printf("User %s restricted to %d hosts", authSession.username, len(userConfig.Hosts))
+	}
+
+	// Kiosk mode: go straight to a single host when one is configured
+	autoConnect := authSession.autoConnect
+	if autoConnect == "" {
+		autoConnect = config.AutoConnect
+	}
+	if autoConnect != "" && authSession.readOnly {
+		log.Printf("User %s is view-only, showing the host menu instead of auto-connecting", authSession.username)
+	} else if autoConnect != "" {
+		if index := findHostByName(userConfig.Hosts, autoConnect); index >= 0 {
+			handleAutoConnect(conn, &userConfig, authSession, userConfig.Hosts[index])
+			return
+		}
+		log.Printf("Auto-connect host %s not available to %s, showing the host menu", autoConnect, authSession.username)
+	}
+
+	// Don't present an empty menu
+	if len(userConfig.Hosts) == 0 {
+		log.Printf("No hosts available for %s, disconnecting", authSession.username)
+		showDisconnectScreen(conn, config, "No hosts are available for your userid, contact your administrator.")
+		return
+	}
+
 	handleProxyConnection(conn, &userConfig, authSession)
 }
 
+// filterHosts returns the hosts matching the allowed list. Entries are
+// either host names (case-insensitive) or 1-based positions in the list.
+func filterHosts(hosts []Host, allowed []string) []Host {
+	var filtered []Host
+	for i, host := range hosts {
+		for _, entry := range allowed {
+			if num, err := strconv.Atoi(entry); err == nil {
+				if num == i+1 {
+					filtered = append(filtered, host)
+					break
+				}
+			} else if strings.EqualFold(entry, host.Name) {
+				filtered = append(filtered, host)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// setKeepAlive enables TCP keepalive on a client or host connection so dead
+// peers are noticed while a session sits idle. TLS connections are unwrapped
+// to reach the underlying TCP connection.
+func setKeepAlive(conn net.Conn, config *Config) {
+	tcpConn := tcpConnOf(conn)
+	if tcpConn == nil {
+		return
+	}
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		log.Printf("Warning: failed to enable keepalive for %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	tcpConn.SetKeepAlivePeriod(secondsOrDefault(config.KeepAlive, 60*time.Second))
+}
+
+// tcpConnOf returns the TCP connection underneath TLS, PROXY protocol and
+// keepalive wrappers, or nil if there isn't one
+func tcpConnOf(conn net.Conn) *net.TCPConn {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			return c
+		case *tls.Conn:
+			conn = c.NetConn()
+		case *proxyProtocolConn:
+			conn = c.Conn
+		case *keepaliveConn:
+			conn = c.Conn
+		default:
+			return nil
+		}
+	}
+}
+
+// tlsBindAddress is the address the TLS listener binds to. Without its own
+// tlsbindaddress it shares the plain listener's bindaddress.
+func (c *Config) tlsBindAddress() string {
+	if c.TLSBindAddress != "" {
+		return c.TLSBindAddress
+	}
+	return c.BindAddress
+}
+
+// listenAddress builds a host:port listen address. An empty bind address
+// listens on all interfaces; IPv6 literals may be given with or without brackets.
+func listenAddress(bindAddress string, port int) string {
+	host := strings.TrimSuffix(strings.TrimPrefix(bindAddress, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
 // tlsVersionToString converts a TLS version constant to a human-readable string
 func tlsVersionToString(version uint16) string {
 	switch version {
@@ -419,10 +1674,30 @@ func main() {
 		debug      = flag.Bool("debug", false, "Enable debug logging")
 		debug3270  = flag.Bool("debug3270", false, "Enable debug output in go3270 library")
 		trace      = flag.Bool("trace", false, "Enable trace logging")
+		hashpw     = flag.Bool("hashpw", false, "Print a bcrypt hash for a password read from stdin and exit")
+		totpsecret = flag.Bool("totpsecret", false, "Generate a TOTP secret for the user given as argument and exit")
 	)
 	flag.Parse()
 
-	log.Printf("Secure3270Proxy starting...")
+	if *totpsecret {
+		username := flag.Arg(0)
+		if username == "" {
+			log.Fatalf("Usage: %s -totpsecret <username>", os.Args[0])
+		}
+		if err := printTOTPSecret(username); err != nil {
+			log.Fatalf("Failed to generate TOTP secret: %v", err)
+		}
+		return
+	}
+
+	if *hashpw {
+		if err := printPasswordHash(); err != nil {
+			log.Fatalf("Failed to hash password: %v", err)
+		}
+		return
+	}
+
+	log.Printf("Secure3270Proxy %s starting...", version)
 	log.Printf("Loading configuration from %s", *configFile)
 
 	// Load configuration (includes both proxy hosts and authentication settings)
@@ -431,56 +1706,190 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	// Everything from here on goes to the log file, if there is one
+	if config.LogFile != "" {
+		log.Printf("Logging to %s", config.LogFile)
+		if err := openLogFile(config); err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Printf("Secure3270Proxy %s starting...", version)
+	}
+
 	// Load authentcation configuraton from users.cnf
-	if err := LoadAuthConfig(*configFile); err != nil {
-		log.Fatalf("Failed to load authentication config: %v", err)
+	if err := LoadAuthConfig(*configFile, config); err != nil {
+		if !breakGlassConfigured(config) {
+			log.Fatalf("Failed to load authentication config: %v", err)
+		}
+		log.Printf("WARNING: failed to load authentication config: %v", err)
+		log.Printf("WARNING: only the break-glass login will work until users.cnf is fixed and reloaded")
+	} else {
+		log.Printf("Authentication configuration loaded successfully from users.cnf")
+	}
+
+	if config.AuditFile != "" {
+		if err := openAuditLog(config.AuditFile); err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+	}
+
+	if err := loadMOTD(config.MOTDFile); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	if err := loadLogonTemplate(config.LogonTemplate); err != nil {
+		log.Printf("Warning: %v, using the default logon screen", err)
+	}
+
+	if err := loadHelpFile(config.HelpFile); err != nil {
+		log.Printf("Warning: %v, using the built-in help", err)
+	}
+
+	if err := loadSplashFile(config.SplashFile); err != nil {
+		log.Printf("Warning: %v, using the IBM logo", err)
+	}
+
+	if config.StateFile != "" {
+		if err := loadLastHosts(config.StateFile); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	if config.LastLoginFile != "" {
+		if err := loadLastLogins(config.LastLoginFile); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	setConfig(config)
+
+	if config.HealthCheckInterval > 0 {
+		go startHealthChecker(time.Duration(config.HealthCheckInterval) * time.Second)
+	}
+
+	// Reload users and host lists on SIGHUP
+	go handleReloadSignals(*configFile)
+	go handleMaintenanceSignals()
+
+	// Start the HTTP health probes if configured
+	if config.HealthPort > 0 {
+		go startProbeServer(config)
 	}
-	log.Printf("Authentication configuration loaded successfully from users.cnf")
 
 	// Start TLS server in a goroutine if configured and enabled
 	if config.TLSEnabled && config.TLSPort > 0 {
-		go startTLSServer(config, *debug, *debug3270, *trace)
+		go startTLSServer(config, "", *debug, *debug3270, *trace)
+	}
+	if config.TLSEnabled {
+		for _, listener := range config.TLSListeners {
+			go startTLSServer(listener.config(config), listener.Name, *debug, *debug3270, *trace)
+		}
 	}
 
 	// Start non-TLS listener with auto-recovery
 	go startStandardServer(config, *debug, *debug3270, *trace)
 
-	// Keep the main goroutine running
-	select {}
+	// Start the local UNIX socket listener if configured
+	if config.UnixSocket != "" {
+		go startUnixServer(config, *debug, *debug3270, *trace)
+	}
+
+	// Run until stopped, or until a listener can't be opened at all
+	os.Exit(waitForShutdown())
 }
 
-func startStandardServer(config *Config, debug, debug3270, trace bool) {
-	for {
-		startTime := time.Now()
-		if err := runStandardServer(config, debug, debug3270, trace); err != nil {
-			log.Printf("Standard server error: %v", err)
-
-			// If the server ran for a reasonable amount of time before failing,
-			// it's likely a temporary issue, so we can restart immediately
-			if time.Since(startTime) > 5*time.Minute {
-				log.Printf("Standard server restarting immediately...")
-			} else {
-				// If it failed quickly, there might be a more serious issue
-				// Wait before retrying to avoid rapid restart loops
-				log.Printf("Standard server will restart in 30 seconds...")
-				time.Sleep(30 * time.Second)
-			}
+// handleReloadSignals re-reads the config file, users.cnf and the host file
+// whenever the process receives SIGHUP. Active sessions keep the config they
+// started with.
+func handleReloadSignals(configFile string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		log.Printf("SIGHUP received, reloading configuration")
+
+		reopenLogFile()
+		reopenAuditLog()
+
+		// The main config comes first so the files below are taken from it.
+		// If it can't be loaded, at least pick up changes to the host list.
+		configLoaded := reloadMainConfig(configFile)
+		reloadServerCertificates()
+
+		if err := loadMOTD(getConfig().MOTDFile); err != nil {
+			log.Printf("Failed to reload MOTD: %v", err)
+		}
+
+		if err := loadLogonTemplate(getConfig().LogonTemplate); err != nil {
+			log.Printf("Failed to reload logon template, keeping previous screen: %v", err)
+		}
+
+		if err := loadHelpFile(getConfig().HelpFile); err != nil {
+			log.Printf("Failed to reload help file, keeping previous help: %v", err)
+		}
+
+		if err := loadSplashFile(getConfig().SplashFile); err != nil {
+			log.Printf("Failed to reload splash file, keeping previous splash: %v", err)
+		}
+
+		if err := LoadAuthConfig(configFile, getConfig()); err != nil {
+			log.Printf("Failed to reload users, keeping previous users: %v", err)
 		} else {
-			// Normal shutdown - wait before restarting
-			log.Printf("Standard server shut down, restarting in 10 seconds...")
-			time.Sleep(10 * time.Second)
+			log.Printf("Reloaded users from users.cnf")
 		}
+
+		if configLoaded {
+			continue
+		}
+		newConfig := *getConfig()
+		hosts, err := loadHostFile(&newConfig, newConfig.HostFile)
+		if err != nil {
+			log.Printf("Failed to reload host list, keeping previous hosts: %v", err)
+			continue
+		}
+		newConfig.Hosts = hosts
+		setConfig(&newConfig)
+		log.Printf("Reloaded host list %s (%d hosts)", newConfig.HostFile, len(hosts))
 	}
 }
 
+// printPasswordHash reads a password from stdin and prints its bcrypt hash
+// so it can be pasted into the password field of users.cnf
+func printPasswordHash() error {
+	fmt.Fprint(os.Stderr, "Password: ")
+	reader := bufio.NewReader(os.Stdin)
+	password, err := reader.ReadString('\n')
+	if err != nil && password == "" {
+		return fmt.Errorf("failed to read password: %v", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return fmt.Errorf("password must not be empty")
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+	return nil
+}
+
+func startStandardServer(config *Config, debug, debug3270, trace bool) {
+	superviseServer("Standard server", func() error {
+		return runStandardServer(config, debug, debug3270, trace)
+	})
+}
+
 func runStandardServer(config *Config, debug, debug3270, trace bool) error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", config.Port))
+	listener, err := net.Listen("tcp", listenAddress(config.BindAddress, config.Port))
 	if err != nil {
-		return fmt.Errorf("failed to start standard listener: %v", err)
+		return &listenError{fmt.Errorf("failed to start standard listener: %v", err)}
 	}
 	defer listener.Close()
 
-	log.Printf("Proxy3270 listening on port %d", config.Port)
+	log.Printf("Proxy3270 listening on %s", listener.Addr())
+	listenerStarted()
+	defer listenerStopped()
 	log.Printf("Secure3270Proxy startup complete")
 
 	// Safely access the underlying TCP listener to set deadlines
@@ -503,7 +1912,7 @@ func runStandardServer(config *Config, debug, debug3270, trace bool) error {
 		}
 
 		// Handle each connection in a separate goroutine
-		go handleStandardConnection(conn, config, debug, debug3270, trace)
+		go handleStandardConnection(conn, getConfig(), debug, debug3270, trace)
 	}
 }
 
@@ -511,11 +1920,39 @@ func handleStandardConnection(conn net.Conn, config *Config, debug, debug3270, t
 	// Ensure connection is always closed when we're done
 	defer conn.Close()
 
+	setKeepAlive(conn, config)
+
 	// Set initial timeout for telnet negotiation
-	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	conn.SetDeadline(time.Now().Add(secondsOrDefault(config.NegotiateTimeout, 30*time.Second)))
+
+	// Local clients on the UNIX socket have no network address to check
+	if !isLocalConn(conn) {
+		// Learn the real client address from the load balancer
+		if config.ProxyProtocol {
+			proxiedConn, err := readProxyHeader(conn)
+			if err != nil {
+				log.Printf("SECURITY: %v", err)
+				return
+			}
+			conn = proxiedConn
+		}
+
+		// Drop blocked networks before negotiating telnet
+		if !ipAllowed(config, conn.RemoteAddr()) {
+			log.Printf("SECURITY: refused connection from %s", conn.RemoteAddr())
+			return
+		}
+		if isBanned(config, conn.RemoteAddr()) {
+			return
+		}
+	}
 
 	// Negotiate telnet protocol with direct error handling
-	if err := go3270.NegotiateTelnet(conn); err != nil {
+	term, err := negotiateTelnet(conn, firstByteTimeout(config))
+	if errors.Is(err, errNotTN3270) {
+		noteRejected(config, conn.RemoteAddr(), err.Error())
+		return
+	} else if err != nil {
 		log.Printf("Standard telnet negotiation failed: %v", err)
 		return
 	}
@@ -523,8 +1960,15 @@ func handleStandardConnection(conn net.Conn, config *Config, debug, debug3270, t
 	// After successful negotiation, remove the deadline for regular operation
 	conn.SetDeadline(time.Time{})
 
+	auditLog("CONNECT", "ip=%s tls=none", conn.RemoteAddr())
+
+	if err := ShowSplash(conn, config); err != nil {
+		log.Printf("Standard client %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
 	// Handle authentication first
-	authSession, err := HandleAuth(conn)
+	authSession, err := HandleAuth(conn, config, "")
 	if err != nil {
 		log.Printf("Standard authentication failed: %v", err)
 		if err.Error() == "user requested logoff with PF9" {
@@ -537,35 +1981,10 @@ func handleStandardConnection(conn net.Conn, config *Config, debug, debug3270, t
 		log.Printf("Standard user authentication failed")
 		return
 	}
+	authSession.terminal = term
 
 	log.Printf("Standard user %s authenticated successfully", authSession.username)
 
-	// Create a copy of the config to override with user-specific settings if needed
-	userConfig := *config
-
-	// If user has a specific host file, use it
-	if authSession.hostFile != "" {
-		log.Printf("Using user-specific host file: %s", authSession.hostFile)
-		userConfig.HostFile = authSession.hostFile
-
-		// Load hosts from the user-specific file
-		proxyData, err := os.ReadFile(userConfig.HostFile)
-		if err != nil {
-			log.Printf("Failed to read user host file %s: %v, falling back to default",
-				userConfig.HostFile, err)
-		} else {
-			// Parse the hosts from the user's host file
-			var hosts []Host
-			if err := json.Unmarshal(proxyData, &hosts); err != nil {
-				log.Printf("Failed to parse user host file %s: %v, falling back to default",
-					userConfig.HostFile, err)
-			} else {
-				// Successfully loaded user's hosts
-				userConfig.Hosts = hosts
-			}
-		}
-	}
-
 	// Now proceed with the normal proxy3270 host selection and connection handling
-	handleProxyConnection(conn, &userConfig, authSession)
+	runUserSession(conn, config, authSession)
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	maintenanceSince time.Time // When maintenance mode was entered, zero when off
+	maintenanceLock  sync.RWMutex
+)
+
+// inMaintenance reports whether new logons are refused, and since when
+func inMaintenance() (bool, time.Time) {
+	maintenanceLock.RLock()
+	defer maintenanceLock.RUnlock()
+	return !maintenanceSince.IsZero(), maintenanceSince
+}
+
+// setMaintenance enters or leaves maintenance mode. Sessions that are
+// already logged on carry on either way. by says who asked for it.
+func setMaintenance(on bool, by string) {
+	maintenanceLock.Lock()
+	defer maintenanceLock.Unlock()
+
+	if on == !maintenanceSince.IsZero() {
+		return
+	}
+	if on {
+		maintenanceSince = time.Now()
+		log.Printf("Maintenance mode entered by %s at %s, refusing new logons", by, maintenanceSince.Format(time.RFC3339))
+		auditLog("MAINTENANCE", "state=on by=%q", by)
+		return
+	}
+	log.Printf("Maintenance mode left by %s at %s after %v, logons allowed again", by,
+		time.Now().Format(time.RFC3339), time.Since(maintenanceSince).Round(time.Second))
+	auditLog("MAINTENANCE", "state=off by=%q", by)
+	maintenanceSince = time.Time{}
+}
+
+// handleMaintenanceSignals toggles maintenance mode whenever the process
+// receives SIGUSR1
+func handleMaintenanceSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+
+	for range signals {
+		on, _ := inMaintenance()
+		setMaintenance(!on, "SIGUSR1")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// hostCounters are the connection statistics of one host, by host name
+type hostCounters struct {
+	attempts  int64
+	successes int64
+	failures  map[string]int64 // By reason, see dialFailureReason
+	dialTime  time.Duration    // Total time of the successful connects
+	active    int64
+}
+
+var (
+	hostStats     = make(map[string]*hostCounters)
+	hostStatsLock sync.Mutex
+)
+
+// countersFor returns the counters of a host; the caller holds the lock
+func countersFor(name string) *hostCounters {
+	counters := hostStats[name]
+	if counters == nil {
+		counters = &hostCounters{failures: make(map[string]int64)}
+		hostStats[name] = counters
+	}
+	return counters
+}
+
+// recordHostConnect counts a successful connect that took dialTime and
+// the session it starts
+func recordHostConnect(host Host, dialTime time.Duration) {
+	hostStatsLock.Lock()
+	defer hostStatsLock.Unlock()
+
+	counters := countersFor(host.Name)
+	counters.attempts++
+	counters.successes++
+	counters.dialTime += dialTime
+	counters.active++
+}
+
+// recordHostFailure counts a connect that failed with err
+func recordHostFailure(host Host, err error) {
+	hostStatsLock.Lock()
+	defer hostStatsLock.Unlock()
+
+	counters := countersFor(host.Name)
+	counters.attempts++
+	counters.failures[dialFailureReason(err)]++
+}
+
+// recordHostDisconnect counts the end of a session started by
+// recordHostConnect
+func recordHostDisconnect(host Host) {
+	hostStatsLock.Lock()
+	defer hostStatsLock.Unlock()
+
+	countersFor(host.Name).active--
+}
+
+// dialFailureReason sorts a dial error into a few reasons that are useful
+// as a metric label
+func dialFailureReason(err error) string {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &certErr):
+		return "tls"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "unreachable"
+	default:
+		return "other"
+	}
+}
+
+// writeMetrics writes the per-host statistics in the Prometheus text
+// format. Every host of the default host list is included, even before its
+// first connect, so dashboards don't miss quiet hosts.
+func writeMetrics(w io.Writer, config *Config) {
+	hostStatsLock.Lock()
+	stats := make(map[string]hostCounters, len(hostStats))
+	for name, counters := range hostStats {
+		copied := *counters
+		copied.failures = make(map[string]int64, len(counters.failures))
+		for reason, n := range counters.failures {
+			copied.failures[reason] = n
+		}
+		stats[name] = copied
+	}
+	hostStatsLock.Unlock()
+
+	if config != nil {
+		for _, host := range config.Hosts {
+			if _, ok := stats[host.Name]; !ok {
+				stats[host.Name] = hostCounters{}
+			}
+		}
+	}
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metric := func(name, kind, help string, value func(hostCounters) string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, host := range names {
+			fmt.Fprintf(w, "%s{host=\"%s\"} %s\n", name, metricLabel(host), value(stats[host]))
+		}
+	}
+
+	metric("secure3270_host_connect_attempts_total", "counter", "Connection attempts to the host.",
+		func(c hostCounters) string { return fmt.Sprint(c.attempts) })
+	metric("secure3270_host_connect_successes_total", "counter", "Successful connections to the host.",
+		func(c hostCounters) string { return fmt.Sprint(c.successes) })
+
+	fmt.Fprintf(w, "# HELP secure3270_host_connect_failures_total Failed connections to the host by reason.\n")
+	fmt.Fprintf(w, "# TYPE secure3270_host_connect_failures_total counter\n")
+	for _, host := range names {
+		reasons := make([]string, 0, len(stats[host].failures))
+		for reason := range stats[host].failures {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "secure3270_host_connect_failures_total{host=\"%s\",reason=\"%s\"} %d\n",
+				metricLabel(host), reason, stats[host].failures[reason])
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP secure3270_host_dial_seconds Time taken by successful connections to the host.\n")
+	fmt.Fprintf(w, "# TYPE secure3270_host_dial_seconds summary\n")
+	for _, host := range names {
+		fmt.Fprintf(w, "secure3270_host_dial_seconds_sum{host=\"%s\"} %g\n", metricLabel(host), stats[host].dialTime.Seconds())
+		fmt.Fprintf(w, "secure3270_host_dial_seconds_count{host=\"%s\"} %d\n", metricLabel(host), stats[host].successes)
+	}
+
+	metric("secure3270_host_active_sessions", "gauge", "Sessions currently connected to the host.",
+		func(c hostCounters) string { return fmt.Sprint(c.active) })
+}
+
+// metricLabel escapes a label value for the Prometheus text format
+func metricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/racingmars/go3270"
+)
+
+// Rows available for message lines on the MOTD screen (rows 2-21)
+const motdMaxLines = 20
+
+var (
+	motdTitle string
+	motdLines []string
+	motdLock  sync.RWMutex
+)
+
+// loadMOTD reads the message of the day file. A first line starting with
+// "title:" becomes the screen title. A missing or empty file disables the banner.
+func loadMOTD(filename string) error {
+	var title string
+	var lines []string
+
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read MOTD file %s: %v", filename, err)
+		}
+
+		text := strings.TrimRight(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+		if strings.TrimSpace(text) != "" {
+			lines = strings.Split(text, "\n")
+			if strings.HasPrefix(strings.ToLower(lines[0]), "title:") {
+				title = strings.TrimSpace(lines[0][len("title:"):])
+				lines = lines[1:]
+			}
+		}
+	}
+
+	if len(lines) > motdMaxLines {
+		log.Printf("Warning: MOTD has %d lines, only the first %d are shown", len(lines), motdMaxLines)
+		lines = lines[:motdMaxLines]
+	}
+
+	motdLock.Lock()
+	motdTitle = title
+	motdLines = lines
+	motdLock.Unlock()
+
+	return nil
+}
+
+// ShowMOTD displays the message of the day until the user presses Enter or
+// PF3. It does nothing if no MOTD is loaded.
+func ShowMOTD(conn net.Conn, config *Config) error {
+	motdLock.RLock()
+	title := motdTitle
+	lines := motdLines
+	motdLock.RUnlock()
+
+	if len(lines) == 0 && title == "" {
+		return nil
+	}
+
+	screen := go3270.Screen{}
+	if title != "" {
+		screen = append(screen, go3270.Field{
+			Row:     0,
+			Col:     getCenteredPosition(title, 79),
+			Content: title,
+			Color:   config.Theme.title(go3270.White),
+			Intense: true,
+		})
+	}
+
+	for i, line := range lines {
+		if len(line) > 78 {
+			line = line[:78]
+		}
+		col := 1
+		if config.MOTDCenter {
+			col = getCenteredPosition(line, 79)
+		}
+		screen = append(screen, go3270.Field{
+			Row:     i + 2,
+			Col:     col,
+			Content: line,
+			Color:   go3270.Turquoise,
+		})
+	}
+
+	screen = append(screen, go3270.Field{
+		Row:     23,
+		Col:     1,
+		Content: "Press Enter to continue, F3 to skip",
+		Color:   go3270.White,
+	})
+
+	if config.IdleTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(time.Duration(config.IdleTimeout) * time.Second))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+
+	_, err := go3270.HandleScreen(
+		screen,
+		nil,
+		nil,
+		[]go3270.AID{go3270.AIDEnter},
+		[]go3270.AID{go3270.AIDPF3},
+		"",
+		23, 1,
+		conn,
+	)
+	return err
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ebcdicPrintable maps printable ASCII (0x20-0x7E) to EBCDIC code page 037
+var ebcdicPrintable = [95]byte{
+	0x40, 0x5A, 0x7F, 0x7B, 0x5B, 0x6C, 0x50, 0x7D, 0x4D, 0x5D, 0x5C, 0x4E, 0x6B, 0x60, 0x4B, 0x61,
+	0xF0, 0xF1, 0xF2, 0xF3, 0xF4, 0xF5, 0xF6, 0xF7, 0xF8, 0xF9, 0x7A, 0x5E, 0x4C, 0x7E, 0x6E, 0x6F,
+	0x7C, 0xC1, 0xC2, 0xC3, 0xC4, 0xC5, 0xC6, 0xC7, 0xC8, 0xC9, 0xD1, 0xD2, 0xD3, 0xD4, 0xD5, 0xD6,
+	0xD7, 0xD8, 0xD9, 0xE2, 0xE3, 0xE4, 0xE5, 0xE6, 0xE7, 0xE8, 0xE9, 0xBA, 0xE0, 0xBB, 0xB0, 0x6D,
+	0x79, 0x81, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89, 0x91, 0x92, 0x93, 0x94, 0x95, 0x96,
+	0x97, 0x98, 0x99, 0xA2, 0xA3, 0xA4, 0xA5, 0xA6, 0xA7, 0xA8, 0xA9, 0xC0, 0x4F, 0xD0, 0xA1,
+}
+
+// 3270 attention identifiers sent by onconnectsend
+const (
+	aidEnter = 0x7D
+	aidClear = 0x6D
+)
+
+// End of a 3270 record in the telnet stream
+var endOfRecord = []byte{telnetIAC, telnetEOR}
+
+// States of recordScanner between two bytes of the stream
+const (
+	scanData     = iota
+	scanIAC      // After IAC
+	scanOption   // After IAC WILL, WONT, DO or DONT
+	scanSBOption // After IAC SB
+	scanSB       // Inside a subnegotiation
+	scanSBIAC    // After IAC inside a subnegotiation
+)
+
+// recordScanner follows the telnet stream from a host across reads, so an
+// IAC EOR split over two reads is still found and a 0xEF data byte after an
+// escaped IAC IAC isn't taken for one. It also notices when the host starts
+// TN3270E negotiation, whose records need a header onconnectsend doesn't
+// write.
+type recordScanner struct {
+	state   int
+	tn3270e bool
+}
+
+// scan reads the next bytes from the host and returns how many records
+// they end
+func (s *recordScanner) scan(data []byte) int {
+	ended := 0
+	for _, b := range data {
+		switch s.state {
+		case scanData:
+			if b == telnetIAC {
+				s.state = scanIAC
+			}
+		case scanIAC:
+			switch b {
+			case telnetEOR:
+				ended++
+				s.state = scanData
+			case telnetWill, telnetWont, telnetDo, telnetDont:
+				s.state = scanOption
+			case telnetSB:
+				s.state = scanSBOption
+			default:
+				// IAC IAC is a 0xFF data byte, anything else a two byte command
+				s.state = scanData
+			}
+		case scanOption:
+			s.state = scanData
+		case scanSBOption:
+			// The host only sends TN3270E subnegotiations once the
+			// terminal agreed to it (RFC 2355 section 7)
+			if b == optTN3270E {
+				s.tn3270e = true
+			}
+			s.state = scanSB
+		case scanSB:
+			if b == telnetIAC {
+				s.state = scanSBIAC
+			}
+		case scanSBIAC:
+			if b == telnetSE {
+				s.state = scanData
+			} else {
+				s.state = scanSB
+			}
+		}
+	}
+	return ended
+}
+
+// onConnectRecords turns a host's onconnectsend string into the 3270
+// inbound records a terminal would send for it. Text is typed at the top of
+// the screen and sent with Enter at each {ENTER} (or newline) and at the end
+// of the string; {CLEAR} sends the Clear key. "{{" stands for "{".
+func onConnectRecords(send string) ([][]byte, error) {
+	var records [][]byte
+	var text []byte
+
+	enter := func() {
+		record := []byte{aidEnter, 0x40, 0x40} // cursor at the top left
+		record = append(record, text...)
+		records = append(records, append(record, endOfRecord...))
+		text = nil
+	}
+
+	for rest := send; rest != ""; {
+		switch {
+		case strings.HasPrefix(rest, "{{"):
+			text = append(text, ebcdicPrintable['{'-0x20])
+			rest = rest[2:]
+		case strings.HasPrefix(strings.ToUpper(rest), "{ENTER}"):
+			enter()
+			rest = rest[len("{ENTER}"):]
+		case strings.HasPrefix(strings.ToUpper(rest), "{CLEAR}"):
+			if len(text) > 0 {
+				return nil, fmt.Errorf("text before {CLEAR} must be sent with {ENTER}")
+			}
+			records = append(records, append([]byte{aidClear}, endOfRecord...))
+			rest = rest[len("{CLEAR}"):]
+		case rest[0] == '\n':
+			enter()
+			rest = rest[1:]
+		case rest[0] == '{':
+			return nil, fmt.Errorf("unknown key %q (use {ENTER}, {CLEAR} or {{)", strings.SplitAfterN(rest, "}", 2)[0])
+		case rest[0] >= 0x20 && rest[0] <= 0x7E:
+			text = append(text, ebcdicPrintable[rest[0]-0x20])
+			rest = rest[1:]
+		default:
+			return nil, fmt.Errorf("character %q can't be sent, only printable ASCII", rest[0])
+		}
+	}
+
+	if len(text) > 0 {
+		enter()
+	}
+	return records, nil
+}
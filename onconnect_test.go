@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOnConnectRecords(t *testing.T) {
+	enter := func(text string) string { return "7d 40 40 " + text + " ff ef" }
+	tests := []struct {
+		send    string
+		want    []string // Records in hex
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"TSO", []string{enter("e3 e2 d6")}, false},
+		{"TSO{ENTER}", []string{enter("e3 e2 d6")}, false},
+		{"{CLEAR}LOGON APPLID(TSO){ENTER}", []string{"6d ff ef", enter("d3 d6 c7 d6 d5 40 c1 d7 d7 d3 c9 c4 4d e3 e2 d6 5d")}, false},
+		{"{clear}a{enter}", []string{"6d ff ef", enter("81")}, false},
+		{"A\nB", []string{enter("c1"), enter("c2")}, false},
+		{"{ENTER}", []string{enter("")}, false},
+		{"{{x}", []string{enter("c0 a7 d0")}, false},
+		{"A{CLEAR}", nil, true},
+		{"{PF3}", nil, true},
+		{"café", nil, true},
+		{"tab\there", nil, true},
+	}
+	for _, tt := range tests {
+		records, err := onConnectRecords(tt.send)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("onConnectRecords(%q) error = %v, want error %v", tt.send, err, tt.wantErr)
+			continue
+		}
+		if len(records) != len(tt.want) {
+			t.Errorf("onConnectRecords(%q) = % x, want %d records", tt.send, records, len(tt.want))
+			continue
+		}
+		for i, want := range tt.want {
+			if !bytes.Equal(records[i], unhex(t, want)) {
+				t.Errorf("onConnectRecords(%q) record %d = % x, want %s", tt.send, i, records[i], want)
+			}
+		}
+	}
+}
+
+func TestRecordScanner(t *testing.T) {
+	tests := []struct {
+		name    string
+		reads   []string
+		ended   []int // Records ended by each read
+		tn3270e bool
+	}{
+		{"one record", []string{"f5 c3 11 40 40 ff ef"}, []int{1}, false},
+		{"two records in one read", []string{"f5 c3 ff ef f1 c3 ff ef"}, []int{2}, false},
+		{"IAC EOR split over two reads", []string{"f5 c3 ff", "ef"}, []int{0, 1}, false},
+		{"escaped IAC then 0xEF data", []string{"f5 c3 ff ff ef 40"}, []int{0}, false},
+		{"escaped IAC split over reads", []string{"f5 c3 ff", "ff ef", "ff ef"}, []int{0, 0, 1}, false},
+		{"negotiation before the screen", []string{"ff fd 18 ff fa 18 01 ff f0 ff fd 19", "ff fb 19 f5 c3 ff ef"}, []int{0, 1}, false},
+		{"EOR byte as an option", []string{"ff fb ef f5 c3"}, []int{0}, false},
+		{"IAC inside a subnegotiation", []string{"ff fa 18 01 ff ff ef ff f0"}, []int{0}, false},
+		{"TN3270E", []string{"ff fd 28", "ff fa 28 08 02 ff f0"}, []int{0, 0}, true},
+		{"TN3270E refused", []string{"ff fd 28 ff fd 18 ff fa 18 01 ff f0"}, []int{0}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var scanner recordScanner
+			for i, read := range tt.reads {
+				if got := scanner.scan(unhex(t, read)); got != tt.ended[i] {
+					t.Errorf("read %d (%s) ended %d records, want %d", i+1, read, got, tt.ended[i])
+				}
+			}
+			if scanner.tn3270e != tt.tn3270e {
+				t.Errorf("tn3270e = %v, want %v", scanner.tn3270e, tt.tn3270e)
+			}
+		})
+	}
+}
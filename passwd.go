@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/racingmars/go3270"
+)
+
+// Field names for the password change screen
+const (
+	fieldNewPassword     = "newPassword"
+	fieldConfirmPassword = "confirmPassword"
+)
+
+// minPasswordLength returns the configured minimum password length
+func minPasswordLength(config *Config) int {
+	if config.MinPasswordLength > 0 {
+		return config.MinPasswordLength
+	}
+	return 8
+}
+
+// HandlePasswordChange asks the user to enter a new password twice and
+// stores it once both entries match
+func HandlePasswordChange(conn net.Conn, config *Config, username string) error {
+	fieldValues := make(map[string]string)
+
+	th := config.Theme
+	screen := go3270.Screen{
+		{Row: 0, Col: 0, Content: strings.Repeat("-", 15) + " SECURE3270PROXY - PASSWORD CHANGE " + strings.Repeat("-", 12), Color: th.title(go3270.White)},
+		{Row: 2, Col: 0, Content: "PF3 ==> Logoff", Color: go3270.White},
+
+		{Row: 4, Col: 3, Content: fmt.Sprintf("PASSWORD FOR %s HAS EXPIRED. ENTER A NEW PASSWORD BELOW:", strings.ToUpper(username)), Color: go3270.White},
+
+		{Row: 6, Col: 3, Content: "NEW PASSWORD ", Color: th.label(go3270.Turquoise)},
+		{Row: 6, Col: 17, Content: "===>", Color: go3270.White},
+		{Row: 6, Col: 22, Name: fieldNewPassword, Write: true, Hidden: true, Color: th.input(go3270.Red)},
+		{Row: 6, Col: 55, Autoskip: true},
+
+		{Row: 8, Col: 3, Content: "CONFIRM      ", Color: th.label(go3270.Turquoise)},
+		{Row: 8, Col: 17, Content: "===>", Color: go3270.White},
+		{Row: 8, Col: 22, Name: fieldConfirmPassword, Write: true, Hidden: true, Color: th.input(go3270.Red)},
+		{Row: 8, Col: 55, Autoskip: true},
+
+		{Row: 10, Col: 3, Content: fmt.Sprintf("PASSWORDS MUST BE AT LEAST %d CHARACTERS LONG.", minPasswordLength(config)), Color: th.label(go3270.Turquoise)},
+	}
+	if config.PasswordHistory > 0 {
+		screen = append(screen, go3270.Field{Row: 11, Col: 3, Content: fmt.Sprintf("YOUR LAST %d PASSWORDS CAN'T BE USED AGAIN.", config.PasswordHistory), Color: th.label(go3270.Turquoise)})
+	}
+	screen = append(screen,
+		go3270.Field{Row: 19, Col: 3, Name: fieldErrorMsg, Color: th.error(go3270.Red), Intense: true},
+	)
+
+	rules := go3270.Rules{
+		fieldNewPassword:     {Validator: go3270.NonBlank, Reset: true},
+		fieldConfirmPassword: {Validator: go3270.NonBlank, Reset: true},
+	}
+
+	authTimeout := secondsOrDefault(config.AuthTimeout, 300*time.Second)
+	for {
+		// The user isn't logged on yet, so the screen gets authtimeout
+		// like the logon screen
+		conn.SetReadDeadline(time.Now().Add(authTimeout))
+		resp, err := go3270.HandleScreen(
+			screen,
+			rules,
+			fieldValues,
+			[]go3270.AID{go3270.AIDEnter},
+			[]go3270.AID{go3270.AIDPF3},
+			fieldErrorMsg,
+			6, 23,
+			conn,
+		)
+		conn.SetReadDeadline(time.Time{})
+
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			log.Printf("Password change screen timed out after %v for %s", authTimeout, username)
+			showDisconnectScreen(conn, config, "No new password received in time.")
+			return fmt.Errorf("password change screen timed out")
+		}
+		if err != nil {
+			return fmt.Errorf("screen show error: %v", err)
+		}
+
+		if resp.AID == go3270.AIDPF3 {
+			return fmt.Errorf("user %s logged off instead of changing password", username)
+		}
+
+		newPassword := resp.Values[fieldNewPassword]
+		if newPassword != resp.Values[fieldConfirmPassword] {
+			fieldValues[fieldErrorMsg] = "Passwords do not match. Please try again."
+			continue
+		}
+
+		if utf8.RuneCountInString(newPassword) < minPasswordLength(config) {
+			fieldValues[fieldErrorMsg] = fmt.Sprintf("Password must be at least %d characters.", minPasswordLength(config))
+			continue
+		}
+
+		if passwordReused(config, username, newPassword) {
+			fieldValues[fieldErrorMsg] = "Password was used recently. Choose a different one."
+			continue
+		}
+
+		if err := changeUserPassword(username, newPassword, config.PasswordHistory); err != nil {
+			log.Printf("Failed to change password for %s: %v", username, err)
+			fieldValues[fieldErrorMsg] = "Password could not be changed. Contact your administrator."
+			continue
+		}
+
+		log.Printf("User %s changed their password", username)
+		auditLog("PASSWORD_CHANGE", "user=%q ip=%s", username, conn.RemoteAddr())
+		return nil
+	}
+}
+
+// passwordReused reports whether a new password is the user's current one
+// or one of the last passwordhistory passwords
+func passwordReused(config *Config, username, password string) bool {
+	if config.PasswordHistory <= 0 {
+		return false
+	}
+	for _, user := range currentUsers() {
+		if user.Username != username {
+			continue
+		}
+		if checkPassword(user.Password, password) {
+			return true
+		}
+		history := user.PasswordHistory
+		if len(history) > config.PasswordHistory {
+			history = history[:config.PasswordHistory]
+		}
+		for _, hash := range history {
+			if checkPassword(hash, password) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// changeUserPassword stores a new bcrypt hashed password for the user,
+// both in memory and in users.cnf. With a history depth the old password
+// is remembered, as a hash, for passwordReused.
+func changeUserPassword(username, newPassword string, depth int) error {
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	authUsersLock.Lock()
+	defer authUsersLock.Unlock()
+
+	var history []string
+	for _, user := range currentUsers() {
+		if user.Username != username {
+			continue
+		}
+		history = user.PasswordHistory
+		if depth > 0 {
+			previous := user.Password
+			if !isBcryptHash(previous) {
+				if previous, err = hashPassword(previous); err != nil {
+					return err
+				}
+			}
+			history = append([]string{previous}, history...)
+			if len(history) > depth {
+				history = history[:depth]
+			}
+		}
+		break
+	}
+
+	if err := rewriteUsersFile(usersFile, username, hash, history); err != nil {
+		return err
+	}
+
+	// Swap in a changed copy, logins may be reading the current list
+	users := append([]User(nil), currentUsers()...)
+	for i := range users {
+		if users[i].Username == username {
+			users[i].Password = hash
+			users[i].PasswordHistory = history
+			users[i].MustChangePassword = false
+		}
+	}
+	authUsers.Store(&users)
+
+	return nil
+}
+
+// rewriteUsersFile replaces the password field of the user's line in the
+// users file, and the password history when there is one, keeping all other
+// lines (including comments) as they are. The new file is written next to
+// the old one and renamed into place.
+func rewriteUsersFile(filename, username, password string, history []string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read users file: %v", err)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("failed to stat users file: %v", err)
+	}
+
+	var out strings.Builder
+	found := false
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			parts := splitUserFields(trimmed, 3)
+			if len(parts) >= 2 && strings.TrimSpace(parts[0]) == username {
+				parts[1] = password
+				if len(history) > 0 {
+					parts = userFieldsWithHistory(trimmed, password, history)
+				}
+				line = strings.Join(parts, "/")
+				found = true
+			}
+		}
+		out.WriteString(line)
+		out.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading users file: %v", err)
+	}
+
+	if !found {
+		return fmt.Errorf("user %s not found in %s", username, filename)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), ".users.cnf.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary users file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(out.String()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary users file: %v", err)
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set users file permissions: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary users file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary users file: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("failed to replace users file: %v", err)
+	}
+
+	return nil
+}
+
+// userFieldsWithHistory splits a users.cnf line into all seven fields and
+// sets the password and history. Fields with a "/" go back in double quotes
+// so the line reads the same way again.
+func userFieldsWithHistory(line, password string, history []string) []string {
+	parts, _ := userFields(line)
+	for len(parts) < 7 {
+		parts = append(parts, "")
+	}
+	parts[1] = password
+	for i := 2; i < 6; i++ {
+		if strings.Contains(parts[i], "/") {
+			parts[i] = "\"" + parts[i] + "\""
+		}
+	}
+	parts[6] = strings.Join(history, ",")
+	return parts
+}
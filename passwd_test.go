@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRewriteUsersFile(t *testing.T) {
+	const before = `# Users of the proxy
+# username/password/hostfile/totpsecret/allowedhosts/flags/history
+
+alice/` + slashHash + `/alice.list
+  # indented comment
+jdoe/oldsecret/"/etc/secure3270/hosts/jdoe.list"/JBSWY3DPEHPK3PXP/mvs1,vm1/admin
+bob/"@file:/run/secrets/bob"
+`
+	newHash := "$2b$" + slashHash[4:]
+	oldHash := "$2y$" + slashHash[4:]
+
+	tests := []struct {
+		name    string
+		history []string
+		want    string // The jdoe line after the rewrite
+	}{
+		{"password only", nil,
+			`jdoe/` + newHash + `/"/etc/secure3270/hosts/jdoe.list"/JBSWY3DPEHPK3PXP/mvs1,vm1/admin`},
+		{"with history", []string{oldHash, slashHash},
+			`jdoe/` + newHash + `/"/etc/secure3270/hosts/jdoe.list"/JBSWY3DPEHPK3PXP/mvs1,vm1/admin/` + oldHash + "," + slashHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "users.cnf")
+			if err := os.WriteFile(filename, []byte(before), 0640); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := rewriteUsersFile(filename, "jdoe", newHash, tt.history); err != nil {
+				t.Fatalf("rewriteUsersFile: %v", err)
+			}
+
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(before, "\n")
+			lines[5] = tt.want
+			if want := strings.Join(lines, "\n"); string(data) != want {
+				t.Errorf("rewritten file:\n%s\nwant:\n%s", data, want)
+			}
+
+			info, err := os.Stat(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0640 {
+				t.Errorf("file mode = %v, want 0640", info.Mode().Perm())
+			}
+
+			// The rewritten line must read back the same way
+			users, err := loadUsersFile(filename)
+			if err != nil {
+				t.Fatalf("loadUsersFile: %v", err)
+			}
+			var jdoe *User
+			for i := range users {
+				if users[i].Username == "jdoe" {
+					jdoe = &users[i]
+				}
+			}
+			if jdoe == nil {
+				t.Fatalf("jdoe is missing after the rewrite")
+			}
+			if jdoe.Password != newHash || jdoe.HostFile != "/etc/secure3270/hosts/jdoe.list" ||
+				jdoe.TOTPSecret != "JBSWY3DPEHPK3PXP" || !jdoe.Admin ||
+				!reflect.DeepEqual(jdoe.AllowedHosts, []string{"mvs1", "vm1"}) ||
+				!reflect.DeepEqual(jdoe.PasswordHistory, tt.history) {
+				t.Errorf("jdoe reads back as %+v", *jdoe)
+			}
+		})
+	}
+}
+
+func TestRewriteUsersFileUnknownUser(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users.cnf")
+	if err := os.WriteFile(filename, []byte("# jdoe/secret\nalice/secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := rewriteUsersFile(filename, "jdoe", "$2a$10$x", nil); err == nil {
+		t.Errorf("rewriteUsersFile succeeded for a user that only appears in a comment")
+	}
+	if data, _ := os.ReadFile(filename); string(data) != "# jdoe/secret\nalice/secret\n" {
+		t.Errorf("users file changed to %q", data)
+	}
+}
+
+func TestRewriteUsersFileLegacyHostFile(t *testing.T) {
+	dir := t.TempDir()
+	inDir(t, dir)
+	os.Mkdir("hosts", 0755)
+	if err := os.WriteFile("hosts/jdoe.list", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("users.cnf", []byte("jdoe/pw/hosts/jdoe.list\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding a history puts the host file in quotes, so it keeps its "/"
+	if err := rewriteUsersFile("users.cnf", "jdoe", slashHash, []string{slashHash}); err != nil {
+		t.Fatalf("rewriteUsersFile: %v", err)
+	}
+	data, _ := os.ReadFile("users.cnf")
+	if want := `jdoe/` + slashHash + `/"hosts/jdoe.list"////` + slashHash + "\n"; string(data) != want {
+		t.Errorf("rewritten line = %q, want %q", data, want)
+	}
+	users, err := loadUsersFile("users.cnf")
+	if err != nil || len(users) != 1 || users[0].HostFile != "hosts/jdoe.list" || len(users[0].PasswordHistory) != 1 {
+		t.Errorf("loadUsersFile = %+v, %v", users, err)
+	}
+}
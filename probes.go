@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Number of 3270 listeners currently accepting connections
+var activeListeners int32
+
+// listenerStarted and listenerStopped track the listeners for /readyz
+func listenerStarted() {
+	atomic.AddInt32(&activeListeners, 1)
+}
+
+func listenerStopped() {
+	atomic.AddInt32(&activeListeners, -1)
+}
+
+// startProbeServer runs the HTTP liveness/readiness server with the same
+// auto-recovery loop as the 3270 listeners
+func startProbeServer(config *Config) {
+	superviseServer("Health probe server", func() error {
+		return runProbeServer(config)
+	})
+}
+
+// runProbeServer serves /healthz (the process is alive), /readyz (the
+// configuration is loaded, at least one listener is accepting and the proxy
+// isn't in maintenance mode) and the
+// per-host connection statistics on /metrics
+func runProbeServer(config *Config) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if getConfig() == nil {
+			http.Error(w, "configuration not loaded", http.StatusServiceUnavailable)
+			return
+		}
+		// Lets a load balancer send new users elsewhere while draining
+		if on, _ := inMaintenance(); on {
+			http.Error(w, "maintenance mode", http.StatusServiceUnavailable)
+			return
+		}
+		if atomic.LoadInt32(&activeListeners) == 0 {
+			http.Error(w, "no listeners accepting connections", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, getConfig())
+	})
+
+	listener, err := net.Listen("tcp", listenAddress(config.BindAddress, config.HealthPort))
+	if err != nil {
+		return &listenError{fmt.Errorf("failed to start health probe listener: %v", err)}
+	}
+	defer listener.Close()
+
+	log.Printf("Health probes listening on %s", listener.Addr())
+
+	// net/http recovers panics in handlers, so a failing probe only drops
+	// that request
+	server := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	return server.Serve(listener)
+}
@@ -19,6 +19,7 @@ v 0.6 selecing X or 99 from hosts view will disconnect session
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -31,89 +32,266 @@ import (
 	"github.com/racingmars/go3270"
 )
 
+// Telnet re-negotiation after a host session unless configured otherwise
+const (
+	defaultRenegotiateTries = 3
+	defaultRenegotiateDelay = time.Second
+)
+
+// Rows of the host menu that aren't host entries: the title and a blank
+// line above, the page indicator and three footer rows below
+const menuChromeRows = 6
+
 func handleProxyConnection(conn net.Conn, config *Config, authSession *authSession) {
+	// Current page of the host menu, kept across trips to hosts and the clock
+	page := 0
+
+	// Shown once above the host list, e.g. when a typed name matched nothing
+	message := ""
+
+	// connect takes the user to a host picked on the menu; view-only users
+	// stay on the menu with a message instead
+	connect := func(host Host) bool {
+		if authSession.readOnly {
+			log.Printf("View-only user %s tried to connect to %s", authSession.username, host.Name)
+			auditLog("HOST_SELECT", "result=denied user=%q ip=%s host=%s reason=readonly",
+				authSession.username, authSession.remoteAddr, host.Name)
+			message = "Access is view-only, you can't connect to " + host.Name
+			return true
+		}
+		return menuProxyToHost(conn, config, authSession, host)
+	}
+
+	// Make sure the health checker also probes this user's hosts
+	registerHealthTargets(config.Hosts)
+
+	// 18 rows of hosts per page, footer on the last four rows
+	footerRow := screenRows - 4
+
 	for {
+		// Enforce the hard session limit between host sessions
+		if sessionExpired(config, authSession) {
+			endExpiredSession(conn, config, authSession)
+			return
+		}
+
+		// Only the first maxhostsdisplayed hosts are listed; the others can
+		// still be selected by name or number
+		shown := config.Hosts
+		if config.MaxHostsDisplayed > 0 && len(shown) > config.MaxHostsDisplayed {
+			shown = shown[:config.MaxHostsDisplayed]
+		}
+		hidden := len(config.Hosts) - len(shown)
+
+		// Split the hosts into pages and keep the current page in range
+		pages := menuPages(shown, screenRows-menuChromeRows)
+		pageCount := len(pages)
+		if page >= pageCount {
+			page = pageCount - 1
+		}
+		firstHost, lastHost := pages[page][0], pages[page][1]
+
+		// Find the host this user connected to last time, if it's still in the list
+		lastIndex := findHostByName(config.Hosts, getLastHost(authSession.username))
+
 		// Create field values map
 		fieldValues := make(map[string]string)
 
 		// Show host selection menu with centered title
 		welcomeMsg := fmt.Sprintf("Welcome %s - Available Hosts", authSession.username)
-		// Calculate center position (assuming 80 column screen)
-		centerPos := (80 - len(welcomeMsg)) / 2
+		centerPos := getCenteredPosition(welcomeMsg, screenColumns)
 		if centerPos < 1 {
 			centerPos = 1
 		}
 
 		screen := go3270.Screen{
-			{Row: 0, Col: centerPos, Content: welcomeMsg, Color: go3270.White},
+			{Row: 0, Col: centerPos, Content: welcomeMsg, Color: config.Theme.title(go3270.White)},
+		}
+		if message != "" {
+			screen = append(screen, go3270.Field{Row: 1, Col: 1, Content: truncateText(message, screenColumns-2), Color: config.Theme.error(go3270.Red), Intense: true})
+			message = ""
 		}
 
-		// Add host entries - start from row 2
-		for i, host := range config.Hosts {
+		// Add host entries for this page - start from row 2.
+		// Hosts keep their global number so selections are the same on every page.
+		row := 2
+		for i, host := range config.Hosts[firstHost:lastHost] {
 			// Add the host number in white
 			screen = append(screen, go3270.Field{
-				Row:     i + 2, // Start from row 2
+				Row:     row,
 				Col:     1,
-				Content: fmt.Sprintf("%2d.", i+1),
+				Content: fmt.Sprintf("%2d.", firstHost+i+1),
 				Color:   go3270.White,
 			})
 
-			// Split the host details: name in blue, address in green
-			hostName := fmt.Sprintf("%-30s", host.Name)
-			hostAddr := fmt.Sprintf("(%s:%d)", host.Host, host.Port)
+			// Show an up/down marker in front of the name when health checks run
+			nameCol := 5
+			if healthChecksEnabled() {
+				screen = append(screen, hostStatusField(row, 5, host))
+				nameCol = 7
+			}
+
+			// Split the host details: name in blue, address in green. Both
+			// are shortened as needed so nothing is written past the last column.
+			hostName, hostAddr := fitHostLine(nameCol, screenColumns, host.Name, fmt.Sprintf("(%s:%d)", host.Host, host.Port))
 
-			// Add host name in blue
-			screen = append(screen, go3270.Field{
-				Row:     i + 2,
-				Col:     5,
+			// Add host name in blue, highlighting the last host used
+			nameField := go3270.Field{
+				Row:     row,
+				Col:     nameCol,
 				Content: hostName,
 				Color:   go3270.Blue,
-			})
+			}
+			if firstHost+i == lastIndex {
+				nameField.Highlighting = go3270.ReverseVideo
+			}
+			screen = append(screen, nameField)
 
 			// Add host address in green
 			screen = append(screen, go3270.Field{
-				Row:     i + 2,
-				Col:     5 + len(hostName),
+				Row:     row,
+				Col:     nameCol + len(hostName),
 				Content: hostAddr,
 				Color:   go3270.Green,
 			})
+			row++
+
+			// The description goes on its own line under the name, in the
+			// terminal's default color so it stands back from the entries
+			if host.Description != "" {
+				screen = append(screen, go3270.Field{
+					Row:     row,
+					Col:     nameCol + 1,
+					Content: truncateText(host.Description, screenColumns-nameCol-2),
+					Color:   go3270.DefaultColor,
+				})
+				row++
+			}
+		}
+
+		// Add page indicator below the hosts when they don't fit on one page
+		if pageCount > 1 {
+			screen = append(screen, go3270.Field{
+				Row:     footerRow,
+				Col:     4,
+				Content: fmt.Sprintf("Page %d of %d   F7=Previous  F8=Next", page+1, pageCount),
+				Color:   go3270.Turquoise,
+			})
+		}
+
+		// Say how many hosts were left off the list
+		if hidden > 0 {
+			text := fmt.Sprintf("%d more hosts not shown, type a name", hidden)
+			if hidden == 1 {
+				text = "1 more host not shown, type a name"
+			}
+			col := 4
+			if pageCount > 1 {
+				col = 40
+			}
+			screen = append(screen, go3270.Field{
+				Row:     footerRow,
+				Col:     col,
+				Content: truncateText(text, screenColumns-col-1),
+				Color:   go3270.Yellow,
+			})
 		}
 
-		// Add disconnect option on row 21
+		// Add disconnect option
+		tokens := disconnectTokens(config)
 		screen = append(screen, go3270.Field{
-			Row:     21,
+			Row:     footerRow + 1,
 			Col:     4,
-			Content: "Enter 99 or X to disconnect",
+			Content: truncateText("Enter "+joinOr(tokens)+" to disconnect", 35),
 			Color:   go3270.White,
 		})
 
 		// Add function key help for clock (F11)
 		screen = append(screen, go3270.Field{
-			Row:     21,
+			Row:     footerRow + 1,
 			Col:     40,
 			Content: "F11=Clock",
 			Color:   go3270.White,
 		})
 
-		// Add selectoin feeld on row 23
+		// Offer a quick reconnect to the last host (F5)
+		if lastIndex >= 0 {
+			screen = append(screen, go3270.Field{
+				Row:     footerRow + 1,
+				Col:     52,
+				Content: "F5=Reconnect last host",
+				Color:   go3270.White,
+			})
+		}
+
+		// Connection details on F4
+		screen = append(screen, go3270.Field{
+			Row:     footerRow + 2,
+			Col:     40,
+			Content: "F4=Status",
+			Color:   go3270.White,
+		})
+
+		// Help on F1
+		screen = append(screen, go3270.Field{
+			Row:     footerRow + 2,
+			Col:     52,
+			Content: "F1=Help",
+			Color:   go3270.White,
+		})
+
+		// Identity and host file choice on F6
+		screen = append(screen, go3270.Field{
+			Row:     footerRow + 2,
+			Col:     62,
+			Content: "F6=Who am I",
+			Color:   go3270.White,
+		})
+
+		// Admins get the session monitor on F10
+		if authSession.admin {
+			screen = append(screen, go3270.Field{
+				Row:     footerRow + 2,
+				Col:     4,
+				Content: "F10=Admin console",
+				Color:   go3270.White,
+			})
+		}
+
+		// Add selectoin feeld on the last row, wide enough for the
+		// longest host number or disconnect token, or a host name prefix
+		prompt := "Enter selection (1-" + strconv.Itoa(len(config.Hosts)) + ", name, " + tokens[0] + "): "
+		selectionCol := 36
+		if 4+len(prompt) > selectionCol {
+			selectionCol = 4 + len(prompt)
+		}
+		selectionWidth := len(strconv.Itoa(len(config.Hosts)))
+		for _, token := range tokens {
+			if len(token) > selectionWidth {
+				selectionWidth = len(token)
+			}
+		}
+		if selectionWidth < minSelectionWidth {
+			selectionWidth = minSelectionWidth
+		}
 		screen = append(screen,
 			go3270.Field{
-				Row:     23,
+				Row:     screenRows - 1,
 				Col:     4,
-				Content: "Enter selection (1-" + strconv.Itoa(len(config.Hosts)) + ", X): ",
-				Color:   go3270.Red,
+				Content: prompt,
+				Color:   config.Theme.label(go3270.Red),
 			},
 			go3270.Field{
-				Row:          23,
-				Col:          36,
+				Row:          screenRows - 1,
+				Col:          selectionCol,
 				Name:         "selection",
 				Write:        true,
-				Color:        go3270.Green,
+				Color:        config.Theme.input(go3270.Green),
 				Highlighting: go3270.Underscore,
 			},
 			go3270.Field{
-				Row:      23,
-				Col:      39,
+				Row:      screenRows - 1,
+				Col:      selectionCol + 1 + selectionWidth,
 				Autoskip: true,
 			},
 		)
@@ -123,26 +301,136 @@ func handleProxyConnection(conn net.Conn, config *Config, authSession *authSessi
 			"selection": {Validator: go3270.NonBlank},
 		}
 
+		// Disconnect users who leave the menu sitting idle, or stay
+		// logged on past the session limit. Idle users are warned first
+		// when idlewarning is set.
+		var menuDeadline, warnAt time.Time
+		if config.IdleTimeout > 0 {
+			menuDeadline = time.Now().Add(time.Duration(config.IdleTimeout) * time.Second)
+			if config.IdleWarning > 0 && config.IdleWarning < config.IdleTimeout {
+				warnAt = menuDeadline.Add(-time.Duration(config.IdleWarning) * time.Second)
+			}
+		}
+		if limit := sessionDeadline(config, authSession); !limit.IsZero() && (menuDeadline.IsZero() || limit.Before(menuDeadline)) {
+			menuDeadline = limit
+		}
+		readDeadline := menuDeadline
+		if !warnAt.IsZero() && warnAt.Before(readDeadline) {
+			readDeadline = warnAt
+		}
+		if !readDeadline.IsZero() {
+			conn.SetReadDeadline(readDeadline)
+		}
+
 		// Display the screen and wait for user input
 		resp, err := go3270.HandleScreen(
 			screen,
 			rules,
 			fieldValues,
 			[]go3270.AID{go3270.AIDEnter},
-			[]go3270.AID{go3270.AIDPF11, go3270.AIDPF12},
+			[]go3270.AID{go3270.AIDPF1, go3270.AIDPF13, go3270.AIDPF4, go3270.AIDPF5, go3270.AIDPF6, go3270.AIDPF7, go3270.AIDPF8, go3270.AIDPF10, go3270.AIDPF11, go3270.AIDPF12},
 			"",
-			23, 37, // Position cursor at selection field on row 23
+			screenRows-1, selectionCol+1, // Position cursor at the selection field
 			conn,
 		)
+		conn.SetReadDeadline(time.Time{})
 
 		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if sessionExpired(config, authSession) {
+					endExpiredSession(conn, config, authSession)
+					return
+				}
+				if !warnAt.IsZero() && time.Now().Before(menuDeadline) {
+					if showIdleWarning(conn, config, menuDeadline) {
+						continue
+					}
+					if sessionExpired(config, authSession) {
+						endExpiredSession(conn, config, authSession)
+						return
+					}
+				}
+				endIdleSession(conn, config, authSession)
+				return
+			}
 			log.Printf("Screen show error: %v", err)
 			return
 		}
 
+		if resp.AID == go3270.AIDPF1 || resp.AID == go3270.AIDPF13 {
+			if err := ShowHelp(conn, config, helpMenu, menuDeadline); err != nil {
+				log.Printf("Error showing help screen: %v", err)
+				return
+			}
+			continue
+		}
+
+		if resp.AID == go3270.AIDPF6 {
+			file, err := ShowWhoAmI(conn, config, authSession)
+			if err != nil {
+				log.Printf("Error showing who am I screen: %v", err)
+				return
+			}
+			if file != "" {
+				if err := switchHostFile(config, authSession, file); err != nil {
+					log.Printf("User %s could not switch to host file %s: %v", authSession.username, file, err)
+					message = "Host file " + file + " could not be loaded, keeping the current one"
+				} else {
+					page = 0
+					registerHealthTargets(config.Hosts)
+				}
+			}
+			continue
+		}
+
+		if resp.AID == go3270.AIDPF4 {
+			if err := ShowStatus(conn, config, authSession); err != nil {
+				log.Printf("Error showing status screen: %v", err)
+				return
+			}
+			continue
+		}
+
+		if resp.AID == go3270.AIDPF5 {
+			if lastIndex >= 0 && !connect(config.Hosts[lastIndex]) {
+				return
+			}
+			continue
+		}
+
+		if resp.AID == go3270.AIDPF7 {
+			if page > 0 {
+				page--
+			}
+			continue
+		}
+
+		if resp.AID == go3270.AIDPF8 {
+			if page < pageCount-1 {
+				page++
+			}
+			continue
+		}
+
+		if resp.AID == go3270.AIDPF10 && authSession.admin {
+			if err := ShowAdminConsole(conn, config, authSession); err != nil {
+				if errors.Is(err, errSessionIdle) {
+					endIdleSession(conn, config, authSession)
+					return
+				}
+				log.Printf("Error showing admin console: %v", err)
+				return
+			}
+			continue
+		}
+
 		if resp.AID == go3270.AIDPF11 {
 			// Show the clock screen
-			if err := ShowClock(conn, authSession.username); err != nil {
+			if err := ShowClock(conn, config, authSession); err != nil {
+				if errors.Is(err, errSessionIdle) {
+					endIdleSession(conn, config, authSession)
+					return
+				}
 				log.Printf("Error showing clock: %v", err)
 			}
 			continue
@@ -151,7 +439,11 @@ func handleProxyConnection(conn net.Conn, config *Config, authSession *authSessi
 		if resp.AID == go3270.AIDPF12 {
 			// Show the clock screen with IBM logo already displayed
 			// We'll simulate pressing F12 by setting a flag
-			if err := ShowClockWithLogo(conn, authSession.username); err != nil {
+			if err := ShowClockWithLogo(conn, config, authSession); err != nil {
+				if errors.Is(err, errSessionIdle) {
+					endIdleSession(conn, config, authSession)
+					return
+				}
 				log.Printf("Error showing IBM logo: %v", err)
 			}
 			continue
@@ -160,42 +452,39 @@ func handleProxyConnection(conn net.Conn, config *Config, authSession *authSessi
 		if resp.AID == go3270.AIDEnter {
 			selection := resp.Values["selection"]
 
-			// Check for disconnect commands (99 or X/x)
-			if selection == "99" || strings.ToUpper(selection) == "X" {
+			// Check for disconnect commands (99 or X/x unless configured)
+			if isDisconnectToken(tokens, selection) {
 				log.Printf("User %s requested disconnect with selection: %s", authSession.username, selection)
 				return // Exit the function to close the connection
 			}
 
 			// Otherwise, try to parse as a host number
 			num, err := strconv.Atoi(selection)
-			if err != nil || num < 1 || num > len(config.Hosts) {
-				continue
-			}
-
-			// Connect to selected host
-			selectedHost := config.Hosts[num-1]
-			if err := connectToHost(conn, selectedHost); err != nil {
-				log.Printf("Connection to host failed: %v", err)
+			if err == nil {
+				if num < 1 || num > len(config.Hosts) {
+					continue
+				}
 
-				// Show eror screan
-				errorScreen := go3270.Screen{
-					{Row: 1, Col: 1, Content: "Connection Error", Color: go3270.White},
-					{Row: 3, Col: 1, Content: fmt.Sprintf("Failed to connect to %s: %v", selectedHost.Name, err), Color: go3270.White},
-					{Row: 5, Col: 1, Content: "Press Enter to continue", Color: go3270.White},
+				// Connect to selected host
+				if !connect(config.Hosts[num-1]) {
+					return
 				}
+				continue
+			}
 
-				go3270.HandleScreen(
-					errorScreen,
-					nil,
-					nil,
-					[]go3270.AID{go3270.AIDEnter},
-					[]go3270.AID{},
-					"",
-					5, 1,
-					conn,
-				)
+			// Or a host name, or the start of exactly one
+			matches := matchHostName(config.Hosts, selection)
+			if len(matches) == 0 {
+				message = fmt.Sprintf("No host matches %q", strings.TrimSpace(selection))
+				continue
+			}
+			if len(matches) > 1 {
+				message = fmt.Sprintf("%q is ambiguous: %d hosts match, type more of the name", strings.TrimSpace(selection), len(matches))
 				continue
 			}
+			if !connect(config.Hosts[matches[0]]) {
+				return
+			}
 
 			// After disconnecting from the host, re-display the host selection menu
 			// by continuing the loop instead of returning
@@ -204,41 +493,605 @@ func handleProxyConnection(conn net.Conn, config *Config, authSession *authSessi
 	}
 }
 
-func connectToHost(clientConn net.Conn, host Host) error {
-	// Set a timeout for the un-negotiation
-	clientConn.SetDeadline(time.Now().Add(10 * time.Second))
+// proxyToHost connects the user to a host and shows an error screen if
+// the connection could not be made; exit says what PF3 on that screen does.
+// It returns false if the terminal has gone away, true when the user can be
+// given the host menu again.
+func proxyToHost(conn net.Conn, config *Config, authSession *authSession, selectedHost Host, exit string) bool {
+	// No new host sessions past the session limit
+	if sessionExpired(config, authSession) {
+		endExpiredSession(conn, config, authSession)
+		return false
+	}
+
+	// Hosts with a warning need an explicit confirmation first
+	if selectedHost.Warn != "" && !confirmHost(conn, config, selectedHost) {
+		log.Printf("User %s cancelled connection to %s", authSession.username, selectedHost.Name)
+		return true
+	}
+
+	sessionRegistry.SetHost(authSession.sessionID, selectedHost.Name)
+	defer sessionRegistry.SetHost(authSession.sessionID, "")
+
+	// Enter on the error screen dials the same host again
+	var result sessionResult
+	var hostStart time.Time
+	for {
+		auditLog("HOST_SELECT", "user=%q ip=%s host=%s target=%s:%d",
+			authSession.username, authSession.remoteAddr, selectedHost.Name, selectedHost.Host, selectedHost.Port)
+		hostStart = time.Now()
+
+		// Record the data stream for audit if enabled for this user
+		var recorder *sessionRecorder
+		if config.RecordDir != "" && (config.RecordAll || authSession.record) {
+			var err error
+			recorder, err = newSessionRecorder(config.RecordDir, authSession.username, selectedHost.Name)
+			if err != nil {
+				log.Printf("Warning: %v", err)
+			} else {
+				log.Printf("Recording session of %s to %s in %s", authSession.username, selectedHost.Name, recorder.filename)
+				auditLog("RECORDING", "user=%q host=%s file=%s", authSession.username, selectedHost.Name, recorder.filename)
+			}
+		}
+
+		var err error
+		result, err = connectToHost(authSession.ctx, conn, config, selectedHost, recorder, sessionDeadline(config, authSession), func() {
+			hookConnect(config, authSession, selectedHost)
+		})
+		recorder.close()
+		if err == nil {
+			break
+		}
+
+		log.Printf("Connection to host failed: %v", err)
+		auditLog("HOST_CONNECT", "result=failure user=%q ip=%s host=%s error=%q",
+			authSession.username, authSession.remoteAddr, selectedHost.Name, err.Error())
+
+		retry, ok := showConnectError(conn, config, selectedHost, err, exit)
+		if !ok {
+			return false
+		}
+		if !retry {
+			return true
+		}
+
+		log.Printf("User %s retrying connection to %s", authSession.username, selectedHost.Name)
+		if sessionExpired(config, authSession) {
+			endExpiredSession(conn, config, authSession)
+			return false
+		}
+	}
+
+	recordLastHost(authSession.username, selectedHost.Name)
+	authSession.hostSessions++
+
+	// One line with everything about the session, for capacity planning and
+	// incident review
+	duration := time.Since(hostStart).Round(time.Second)
+	reason := result.reason.String()
+	if result.err != nil {
+		reason += ": " + result.err.Error()
+	}
+	log.Printf("SESSION user=%q ip=%s host=%s target=%s:%d tls=%t duration=%s client_bytes=%d host_bytes=%d reason=%q",
+		authSession.username, authSession.remoteAddr, selectedHost.Name, selectedHost.Host, selectedHost.Port,
+		authSession.tlsState != nil, duration, result.clientBytes, result.targetBytes, reason)
+
+	auditLog("HOST_DISCONNECT", "user=%q ip=%s host=%s duration=%s reason=%q",
+		authSession.username, authSession.remoteAddr, selectedHost.Name, duration, result.reason)
+	hookDisconnect(config, authSession, selectedHost, duration, result.reason.String())
+
+	if result.terminalLost {
+		return false
+	}
+	if result.reason == endTimeLimit {
+		endExpiredSession(conn, config, authSession)
+		return false
+	}
+	return !result.reason.clientGone()
+}
+
+// endExpiredSession logs off a user who has reached maxsessionminutes
+func endExpiredSession(conn net.Conn, config *Config, authSession *authSession) {
+	log.Printf("User %s reached the session limit of %d minutes, disconnecting", authSession.username, config.MaxSessionMinutes)
+	auditLog("SESSION_LIMIT", "user=%q ip=%s minutes=%d", authSession.username, authSession.remoteAddr, config.MaxSessionMinutes)
+	showDisconnectScreen(conn, config, "Session time limit reached, please reconnect.")
+}
+
+// endIdleSession logs off a user who hasn't pressed a key for idletimeout
+// seconds
+func endIdleSession(conn net.Conn, config *Config, authSession *authSession) {
+	log.Printf("User %s idle for %d seconds, disconnecting", authSession.username, config.IdleTimeout)
+	showDisconnectScreen(conn, config, "Session timed out due to inactivity")
+}
+
+// showConnectError tells the user a host could not be reached and asks
+// whether to try it again. retry is true for Enter and false for PF3, whose
+// effect exit describes; ok is false if the terminal has gone away.
+func showConnectError(conn net.Conn, config *Config, host Host, err error, exit string) (retry, ok bool) {
+	errorScreen := go3270.Screen{
+		{Row: 1, Col: 1, Content: "Connection Error", Color: config.Theme.title(go3270.White)},
+		{Row: 3, Col: 1, Content: fmt.Sprintf("Failed to connect to %s: %v", host.Name, err), Color: config.Theme.error(go3270.White)},
+		{Row: 5, Col: 1, Content: "Press Enter to retry, F3 to " + exit, Color: go3270.White},
+	}
+
+	resp, err := go3270.HandleScreen(
+		errorScreen,
+		nil,
+		nil,
+		[]go3270.AID{go3270.AIDEnter},
+		[]go3270.AID{go3270.AIDPF3},
+		"",
+		5, 1,
+		conn,
+	)
+	if err != nil {
+		return false, false
+	}
+	return resp.AID == go3270.AIDEnter, true
+}
+
+// menuProxyToHost connects to a host chosen on the host menu. Once a host
+// session has run, the user goes back to the menu or is logged off
+// depending on afterhost; failed or cancelled connections always return.
+func menuProxyToHost(conn net.Conn, config *Config, authSession *authSession, host Host) bool {
+	completed := authSession.hostSessions
+	if !proxyToHost(conn, config, authSession, host, "return to the host menu") {
+		return false
+	}
+	if authSession.hostSessions == completed || !afterHostDisconnect(config, authSession) {
+		return true
+	}
+
+	log.Printf("User %s left host %s, logging off", authSession.username, host.Name)
+	showDisconnectScreen(conn, config, "Your session with "+host.Name+" has ended.")
+	return false
+}
+
+// afterHostDisconnect reports whether the user is logged off after a host
+// session; the user's own setting wins over the config
+func afterHostDisconnect(config *Config, authSession *authSession) bool {
+	switch authSession.afterHost {
+	case "menu":
+		return false
+	case "disconnect":
+		return true
+	}
+	return config.AfterHostDisconnect
+}
+
+// handleAutoConnect takes the user straight to one host instead of showing
+// the host menu. When the host session ends the user is either connected
+// again or logged off, depending on autoconnectexit. There is no menu to go
+// back to, so a connection that never ran, because the user gave up on the
+// error screen or the warning, always logs off.
+func handleAutoConnect(conn net.Conn, config *Config, authSession *authSession, host Host) {
+	for {
+		completed := authSession.hostSessions
+		if !proxyToHost(conn, config, authSession, host, "log off") {
+			return
+		}
+		if authSession.hostSessions == completed {
+			log.Printf("User %s gave up connecting to auto-connect host %s, logging off", authSession.username, host.Name)
+			showDisconnectScreen(conn, config, "No connection to "+host.Name+" was made.")
+			return
+		}
+
+		if !config.AutoConnectReconnect {
+			log.Printf("User %s left auto-connect host %s, logging off", authSession.username, host.Name)
+			showDisconnectScreen(conn, config, "Your session with "+host.Name+" has ended.")
+			return
+		}
+		log.Printf("Reconnecting %s to auto-connect host %s", authSession.username, host.Name)
+	}
+}
+
+// The selection field holds at least this many characters so host names
+// can be typed
+const minSelectionWidth = 12
+
+// matchHostName returns the indexes of the hosts a typed name selects,
+// ignoring case: an exact name match on its own, otherwise every host whose
+// name starts with it
+func matchHostName(hosts []Host, name string) []int {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil
+	}
+
+	var prefixed []int
+	for i, host := range hosts {
+		if strings.EqualFold(host.Name, name) {
+			return []int{i}
+		}
+		if len(host.Name) >= len(name) && strings.EqualFold(host.Name[:len(name)], name) {
+			prefixed = append(prefixed, i)
+		}
+	}
+	return prefixed
+}
+
+// defaultDisconnectTokens end the session when typed on the host menu
+var defaultDisconnectTokens = []string{"99", "X"}
+
+// disconnectTokens returns the configured disconnect selections, or the
+// defaults when disconnecttokens isn't set
+func disconnectTokens(config *Config) []string {
+	if len(config.DisconnectTokens) > 0 {
+		return config.DisconnectTokens
+	}
+	return defaultDisconnectTokens
+}
+
+// isDisconnectToken reports whether a menu selection is one of the
+// disconnect tokens, ignoring case
+func isDisconnectToken(tokens []string, selection string) bool {
+	selection = strings.TrimSpace(selection)
+	for _, token := range tokens {
+		if strings.EqualFold(token, selection) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinOr lists words as "a", "a or b" or "a, b or c"
+func joinOr(words []string) string {
+	if len(words) <= 1 {
+		return strings.Join(words, "")
+	}
+	return strings.Join(words[:len(words)-1], ", ") + " or " + words[len(words)-1]
+}
+
+// menuPages splits the host list into pages of the host menu, each holding
+// the hosts [start, end) that fit into rowsPerPage rows. A host takes one
+// row, or two when it has a description. There is always at least one page.
+func menuPages(hosts []Host, rowsPerPage int) [][2]int {
+	var pages [][2]int
+	start, used := 0, 0
+	for i, host := range hosts {
+		need := 1
+		if host.Description != "" {
+			need = 2
+		}
+		if used+need > rowsPerPage && i > start {
+			pages = append(pages, [2]int{start, i})
+			start, used = i, 0
+		}
+		used += need
+	}
+	return append(pages, [2]int{start, len(hosts)})
+}
+
+// Width of the host name column on the menu, and how far it may shrink to
+// make room for a long address
+const (
+	hostNameWidth    = 30
+	minHostNameWidth = 12
+)
+
+// fitHostLine formats a host menu entry whose name field attribute sits at
+// nameCol. The name is padded to hostNameWidth and the address follows it;
+// the name shrinks first and then the address is cut, with "..." marking
+// truncated text, so the last character lands in the last of cols columns.
+func fitHostLine(nameCol, cols int, name, addr string) (string, string) {
+	// Columns left for the name and the address. The address field's
+	// attribute byte takes the place of the name's last padding column.
+	room := cols - 1 - nameCol
+
+	nameWidth := hostNameWidth
+	if nameWidth+len(addr) > room {
+		nameWidth = room - len(addr)
+		if nameWidth < minHostNameWidth {
+			nameWidth = minHostNameWidth
+		}
+	}
+
+	// Leave the last column of the name for the address attribute
+	hostName := fmt.Sprintf("%-*s", nameWidth, truncateText(name, nameWidth-1))
+	hostAddr := truncateText(addr, room-nameWidth)
+	return hostName, hostAddr
+}
+
+// truncateText shortens s to at most width characters, ending in "..." when
+// anything was cut off
+func truncateText(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if len(s) <= width {
+		return s
+	}
+	if width <= 3 {
+		return s[:width]
+	}
+	return s[:width-3] + "..."
+}
+
+// confirmHost shows a host's warning and asks the user to confirm the
+// connection with Enter. PF3 (or any error) cancels back to the menu.
+func confirmHost(conn net.Conn, config *Config, host Host) bool {
+	title := "Connect to " + host.Name
+	screen := go3270.Screen{
+		{Row: 1, Col: getCenteredPosition(title, 79), Content: title, Color: config.Theme.title(go3270.White), Intense: true},
+		{Row: 3, Col: 1, Content: fmt.Sprintf("Host: %s:%d", host.Host, host.Port), Color: config.Theme.label(go3270.Turquoise)},
+	}
+	if host.CodePage != "" {
+		screen = append(screen, go3270.Field{Row: 4, Col: 1, Content: truncateText("Code page: "+host.CodePage+" (set your emulator to match)", 78), Color: config.Theme.label(go3270.Turquoise)})
+	}
+
+	// Long warnings wrap over several rows
+	warning := host.Warn
+	for row := 6; row <= 15 && warning != ""; row++ {
+		line := warning
+		if len(line) > 78 {
+			line = line[:78]
+		}
+		warning = warning[len(line):]
+		screen = append(screen, go3270.Field{Row: row, Col: 1, Content: line, Color: config.Theme.error(go3270.Red), Intense: true})
+	}
+
+	screen = append(screen, go3270.Field{
+		Row:     23,
+		Col:     1,
+		Content: "Press Enter to connect, F3 to cancel",
+		Color:   go3270.White,
+	})
+
+	if config.IdleTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(time.Duration(config.IdleTimeout) * time.Second))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+
+	resp, err := go3270.HandleScreen(
+		screen,
+		nil,
+		nil,
+		[]go3270.AID{go3270.AIDEnter},
+		[]go3270.AID{go3270.AIDPF3},
+		"",
+		23, 1,
+		conn,
+	)
+	return err == nil && resp.AID == go3270.AIDEnter
+}
 
-	// Un-negotiate telnet protocol before connecting to host
-	if err := go3270.UnNegotiateTelnet(clientConn, 2*time.Second); err != nil {
-		log.Printf("Warning: telnet un-negotiation failed: %v", err)
-		// Continue anyway - some clients may not require proper un-negotiation
+// showHostListError tells the user their host list couldn't be loaded. With
+// fallback set they can continue to the default host list; otherwise they are
+// disconnected. It returns true if the session should continue.
+func showHostListError(conn net.Conn, config *Config, reason string, fallback bool) bool {
+	screen := go3270.Screen{
+		{Row: 1, Col: 1, Content: "Secure3270Proxy", Color: config.Theme.title(go3270.White)},
+		{Row: 3, Col: 1, Content: "Your host list could not be loaded, contact your administrator.", Color: config.Theme.error(go3270.Red), Intense: true},
+		{Row: 4, Col: 1, Content: reason, Color: config.Theme.error(go3270.Red), Intense: true},
 	}
 
-	// Connect to the target host with a timeout
-	dialer := net.Dialer{Timeout: 15 * time.Second}
-	targetConn, err := dialer.Dial("tcp", fmt.Sprintf("%s:%d", host.Host, host.Port))
+	if !fallback {
+		screen = append(screen, go3270.Field{Row: 6, Col: 1, Content: "You are being disconnected.", Color: go3270.White})
+
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if err := go3270.ShowScreenNoResponse(screen, nil, 6, 1, conn); err != nil {
+			log.Printf("Failed to show host list error screen: %v", err)
+		}
+		conn.SetWriteDeadline(time.Time{})
+
+		// Let the terminal render the message before the connection closes
+		time.Sleep(2 * time.Second)
+		return false
+	}
+
+	screen = append(screen, go3270.Field{Row: 6, Col: 1, Content: "Press Enter to continue with the default host list", Color: go3270.White})
+
+	_, err := go3270.HandleScreen(
+		screen,
+		nil,
+		nil,
+		[]go3270.AID{go3270.AIDEnter},
+		[]go3270.AID{},
+		"",
+		6, 1,
+		conn,
+	)
+	return err == nil
+}
+
+// showDisconnectScreen tells the user why their session is about to be
+// closed and gives the terminal a moment to display it
+func showDisconnectScreen(conn net.Conn, config *Config, message string) {
+	screen := go3270.Screen{
+		{Row: 1, Col: 1, Content: "Secure3270Proxy", Color: config.Theme.title(go3270.White)},
+		{Row: 3, Col: 1, Content: message, Color: config.Theme.error(go3270.Red), Intense: true},
+		{Row: 5, Col: 1, Content: "You are being disconnected.", Color: go3270.White},
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if err := go3270.ShowScreenNoResponse(screen, nil, 5, 1, conn); err != nil {
+		log.Printf("Failed to show disconnect screen: %v", err)
+	}
+	conn.SetWriteDeadline(time.Time{})
+
+	// Let the terminal render the message before the connection closes
+	time.Sleep(2 * time.Second)
+}
+
+// showIdleWarning tells a user who left the host menu alone that they are
+// about to be disconnected at deadline. It returns true if they pressed a
+// key in time to stay logged on.
+func showIdleWarning(conn net.Conn, config *Config, deadline time.Time) bool {
+	seconds := int(time.Until(deadline).Round(time.Second) / time.Second)
+	screen := go3270.Screen{
+		{Row: 1, Col: 1, Content: "Secure3270Proxy", Color: config.Theme.title(go3270.White)},
+		{Row: 3, Col: 1, Content: fmt.Sprintf("You will be disconnected in %d seconds due to inactivity.", seconds), Color: config.Theme.error(go3270.Yellow), Intense: true},
+		{Row: 5, Col: 1, Content: "Press Enter to stay logged on.", Color: go3270.White},
+	}
+
+	conn.SetReadDeadline(deadline)
+	defer conn.SetReadDeadline(time.Time{})
+	if _, err := go3270.ShowScreenOpts(screen, nil, conn, go3270.ScreenOpts{CursorRow: 5, CursorCol: 1}); err != nil {
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			log.Printf("Failed to show idle warning: %v", err)
+		}
+		return false
+	}
+	return true
+}
+
+// showDialProgress tells the user that connecting to a host is taking more
+// than one attempt
+func showDialProgress(conn net.Conn, config *Config, host Host, attempt, attempts int) {
+	screen := go3270.Screen{
+		{Row: 1, Col: 1, Content: "Secure3270Proxy", Color: config.Theme.title(go3270.White)},
+		{Row: 3, Col: 1, Content: truncateText("Connecting to "+host.Name+"...", 78), Color: go3270.White},
+		{Row: 5, Col: 1, Content: fmt.Sprintf("Host not reachable yet, retrying (attempt %d of %d)", attempt, attempts), Color: config.Theme.error(go3270.Yellow)},
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if err := go3270.ShowScreenNoResponse(screen, nil, 5, 1, conn); err != nil {
+		log.Printf("Failed to show connection progress: %v", err)
+	}
+	conn.SetWriteDeadline(time.Time{})
+}
+
+// sessionEnd says why a proxied session stopped
+type sessionEnd int
+
+const (
+	endTargetClosed     sessionEnd = iota // Host closed the connection, e.g. after logoff
+	endClientClosed                       // Terminal closed the connection
+	endTargetReadError                    // Reading from the host failed
+	endClientReadError                    // Reading from the terminal failed
+	endTargetWriteError                   // Writing to the host failed
+	endClientWriteError                   // Writing to the terminal failed
+	endTimeLimit                          // The user reached maxsessionminutes
+	endTerminated                         // The session was terminated, e.g. from the admin console
+)
+
+func (e sessionEnd) String() string {
+	switch e {
+	case endTargetClosed:
+		return "host closed the connection"
+	case endClientClosed:
+		return "client closed the connection"
+	case endTargetReadError:
+		return "read from host failed"
+	case endClientReadError:
+		return "read from client failed"
+	case endTargetWriteError:
+		return "write to host failed"
+	case endClientWriteError:
+		return "write to client failed"
+	case endTimeLimit:
+		return "session time limit reached"
+	case endTerminated:
+		return "session terminated"
+	}
+	return "unknown"
+}
+
+// clientGone reports whether the terminal side of the session is dead
+func (e sessionEnd) clientGone() bool {
+	return e == endClientClosed || e == endClientReadError || e == endClientWriteError || e == endTerminated
+}
+
+// sessionResult describes how a proxied session ended
+type sessionResult struct {
+	reason      sessionEnd
+	err         error // Underlying error, nil for a clean close
+	clientBytes int64 // Bytes sent from the terminal to the host
+	targetBytes int64 // Bytes sent from the host to the terminal
+
+	terminalLost bool // The terminal couldn't be put back into 3270 mode afterwards
+}
+
+// sessionEvent is sent by a copy goroutine when it stops
+type sessionEvent struct {
+	reason sessionEnd
+	err    error
+}
+
+// closedOrFailed picks the end reason for a read error: EOF is a clean close
+func closedOrFailed(err error, closed, failed sessionEnd) sessionEvent {
+	if err == io.EOF {
+		return sessionEvent{reason: closed}
+	}
+	return sessionEvent{reason: failed, err: err}
+}
+
+// connectToHost proxies the terminal to a host until either side stops, the
+// session's context is cancelled, or until deadline if it isn't zero.
+// connected is called once the host has been reached. The error is only set
+// when the host couldn't be reached; otherwise the result says why the
+// session ended.
+func connectToHost(sessionCtx context.Context, clientConn net.Conn, config *Config, host Host, recorder *sessionRecorder, deadline time.Time, connected func()) (sessionResult, error) {
+	unNegotiateTimeout := secondsOrDefault(config.UnNegotiateTimeout, 10*time.Second)
+
+	// Connect to the target host while the terminal is still in 3270 mode,
+	// so retries can be shown and a failure needs no re-negotiation
+	dialStart := time.Now()
+	targetConn, err := dialHost(config, host, func(attempt, attempts int) {
+		showDialProgress(clientConn, config, host, attempt, attempts)
+	})
 	if err != nil {
-		// If connection failed, re-negotiate telnet to show error message
-		clientConn.SetDeadline(time.Now().Add(10 * time.Second))
-		_ = go3270.NegotiateTelnet(clientConn)
-		clientConn.SetDeadline(time.Time{}) // Remove deadline
-		return fmt.Errorf("failed to connect to target: %v", err)
+		recordHostFailure(host, err)
+		return sessionResult{}, fmt.Errorf("failed to connect to target: %v", err)
 	}
+	recordHostConnect(host, time.Since(dialStart))
+	defer recordHostDisconnect(host)
+	connected()
+	if host.CodePage != "" {
+		log.Printf("Connected to %s, host code page %s", host.Name, host.CodePage)
+	}
+
+	// Un-negotiate telnet protocol before connecting to host, unless the
+	// host expects a terminal that is already in TN3270 mode
+	if !host.rawNegotiation() {
+		// Set a timeout for the un-negotiation
+		clientConn.SetDeadline(time.Now().Add(unNegotiateTimeout))
+
+		if err := go3270.UnNegotiateTelnet(clientConn, 2*time.Second); err != nil {
+			log.Printf("Warning: telnet un-negotiation failed: %v", err)
+			// Continue anyway - some clients may not require proper un-negotiation
+		}
+	}
+	clientConn.SetDeadline(time.Time{})
 
 	// Create buffers for error handling and data transfer
 	clientBuffer := make([]byte, 32*1024)
 	targetBuffer := make([]byte, 32*1024)
 
-	// Create a cancel context for proper cleanup
-	ctx, cancel := context.WithCancel(context.Background())
+	// Create a cancel context for proper cleanup; terminating the user's
+	// session cancels it too
+	ctx, cancel := context.WithCancel(sessionCtx)
 	defer cancel()
 
+	// Bytes moved in each direction, and optional per-direction throttling
+	var clientBytes, targetBytes int64
+	clientLimiter := newRateLimiter(config.MaxKbps)
+	targetLimiter := newRateLimiter(config.MaxKbps)
+
 	// Use WaitGroup to ensure both goroutines finish
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	// Create error channel
-	errChan := make(chan error, 2)
+	// Each goroutine reports why it stopped, as do the session limit timer
+	// and termination of the session
+	errChan := make(chan sessionEvent, 4)
+	if !deadline.IsZero() {
+		limit := time.AfterFunc(time.Until(deadline), func() {
+			errChan <- sessionEvent{reason: endTimeLimit}
+			cancel()
+		})
+		defer limit.Stop()
+	}
+	stopTerminate := context.AfterFunc(sessionCtx, func() {
+		errChan <- sessionEvent{reason: endTerminated}
+	})
+	defer stopTerminate()
+
+	// Keys to send for the user as the host's first screens arrive. The
+	// host is then written to from both directions, so the writes take turns.
+	onConnect, _ := onConnectRecords(host.OnConnectSend)
+	var hostRecords recordScanner
+	var targetWriteLock sync.Mutex
 
 	// Forward data client -> target
 	go func() {
@@ -257,17 +1110,26 @@ func connectToHost(clientConn net.Conn, host Host) error {
 						continue // Just a timeout, try again
 					}
 					// Real error
-					errChan <- err
+					errChan <- closedOrFailed(err, endClientClosed, endClientReadError)
 					cancel() // Cancel other goroutine
 					return
 				}
 
 				if n > 0 {
+					recorder.record(recordFromClient, clientBuffer[:n])
+
+					if clientLimiter.wait(ctx, n) != nil {
+						return // Session is being torn down
+					}
+
 					// Try sending data with timeout
+					targetWriteLock.Lock()
 					targetConn.SetWriteDeadline(time.Now().Add(5 * time.Second))
-					_, err := targetConn.Write(clientBuffer[:n])
+					written, err := targetConn.Write(clientBuffer[:n])
+					targetWriteLock.Unlock()
+					clientBytes += int64(written)
 					if err != nil {
-						errChan <- err
+						errChan <- sessionEvent{reason: endTargetWriteError, err: err}
 						cancel()
 						return
 					}
@@ -293,31 +1155,63 @@ func connectToHost(clientConn net.Conn, host Host) error {
 						continue // Just a timeout, try again
 					}
 					// Real error
-					errChan <- err
+					errChan <- closedOrFailed(err, endTargetClosed, endTargetReadError)
 					cancel() // Cancel other goroutine
 					return
 				}
 
 				if n > 0 {
+					recorder.record(recordFromHost, targetBuffer[:n])
+
+					if targetLimiter.wait(ctx, n) != nil {
+						return // Session is being torn down
+					}
+
 					// Try sending data with timeout
 					clientConn.SetWriteDeadline(time.Now().Add(5 * time.Second))
-					_, err := clientConn.Write(targetBuffer[:n])
+					written, err := clientConn.Write(targetBuffer[:n])
+					targetBytes += int64(written)
 					if err != nil {
-						errChan <- err
+						errChan <- sessionEvent{reason: endClientWriteError, err: err}
 						cancel()
 						return
 					}
+
+					// A complete screen from the host, answer it with the
+					// next onconnectsend record. TN3270E records need a
+					// header these don't have, so they only go to TN3270.
+					ended := 0
+					if len(onConnect) > 0 {
+						ended = hostRecords.scan(targetBuffer[:n])
+						if hostRecords.tn3270e {
+							log.Printf("Not sending onconnectsend to %s: the host negotiated TN3270E", host.Name)
+							onConnect = nil
+						}
+					}
+					if len(onConnect) > 0 && ended > 0 {
+						recorder.record(recordFromClient, onConnect[0])
+						targetWriteLock.Lock()
+						targetConn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+						_, err := targetConn.Write(onConnect[0])
+						targetWriteLock.Unlock()
+						if err != nil {
+							errChan <- sessionEvent{reason: endTargetWriteError, err: err}
+							cancel()
+							return
+						}
+						onConnect = onConnect[1:]
+					}
 				}
 			}
 		}
 	}()
 
-	// Wait for an error or EOF
-	var finalErr error
-	select {
-	case finalErr = <-errChan:
-		// An error occurred, cancel both goroutines
-		cancel()
+	// Wait for the first side to stop. Terminating the session also closes
+	// the terminal, which the copy loop may notice first.
+	event := <-errChan
+	cancel()
+	if sessionCtx.Err() != nil {
+		event = sessionEvent{reason: endTerminated}
 	}
 
 	// Close the target connection
@@ -326,41 +1220,94 @@ func connectToHost(clientConn net.Conn, host Host) error {
 	// Wait for both goroutines to finish
 	wg.Wait()
 
+	result := sessionResult{
+		reason:      event.reason,
+		err:         event.err,
+		clientBytes: clientBytes,
+		targetBytes: targetBytes,
+	}
+
+	// Nothing to re-negotiate with if the terminal has gone away, and
+	// nothing to re-negotiate after a raw session
+	if result.reason.clientGone() || host.rawNegotiation() {
+		clientConn.SetDeadline(time.Time{})
+		return result, nil
+	}
+
 	// Reset the client connection to ensure clean state
-	if tcpConn, ok := clientConn.(*net.TCPConn); ok {
+	if tcpConn := tcpConnOf(clientConn); tcpConn != nil {
 		tcpConn.SetLinger(0) // Discard any pending data
 	}
 
 	// Give connections time to settle
 	time.Sleep(500 * time.Millisecond)
 
+	// A host closing the connection is the normal end of a session, e.g.
+	// after LOGOFF, so the re-negotiation that follows is only logged if it
+	// doesn't work out
+	quiet := result.reason == endTargetClosed
+	if quiet {
+		log.Printf("Host session with %s ended normally", host.Name)
+	}
+
 	// Re-negotiate telnet protocol with increased timeout and retry
+	attempts, negotiateErr := renegotiateTelnet(clientConn, config, quiet)
+
+	// The terminal is in no state for the host menu. It isn't in 3270 mode,
+	// so the notice goes out as plain text before the session is closed.
+	if negotiateErr != nil {
+		log.Printf("Warning: telnet re-negotiation failed after %d attempts: %v", attempts, negotiateErr)
+		clientConn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		clientConn.Write([]byte("\r\nThe host session has ended. Please reconnect.\r\n"))
+		clientConn.SetWriteDeadline(time.Time{})
+		result.terminalLost = true
+		return result, nil
+	}
+
+	// A session that ran is never an error, the user goes back to the host menu
+	return result, nil
+}
+
+// renegotiateTelnet puts the terminal back into TN3270 mode after a host
+// session, trying up to renegotiateattempts times. It returns the number of
+// attempts allowed and the error of the last one if none worked.
+func renegotiateTelnet(clientConn net.Conn, config *Config, quiet bool) (int, error) {
+	unNegotiateTimeout := secondsOrDefault(config.UnNegotiateTimeout, 10*time.Second)
+	attempts := defaultRenegotiateTries
+	if config.RenegotiateTries > 0 {
+		attempts = config.RenegotiateTries
+	}
+	delay := defaultRenegotiateDelay
+	if config.RenegotiateDelayMs > 0 {
+		delay = time.Duration(config.RenegotiateDelayMs) * time.Millisecond
+	}
+
 	var negotiateErr error
-	for attempts := 0; attempts < 3; attempts++ {
+	for attempt := 0; attempt < attempts; attempt++ {
 		// Use a fresh deadline for each attempt
-		clientConn.SetDeadline(time.Now().Add(10 * time.Second))
+		clientConn.SetDeadline(time.Now().Add(unNegotiateTimeout))
 
-		// Try to renegotiate telnet
-		negotiateErr = go3270.NegotiateTelnet(clientConn)
+		// go3270.NegotiateTelnet doesn't look at the replies, so a terminal
+		// that is gone would go unnoticed
+		_, negotiateErr = negotiateTelnet(clientConn, firstByteTimeout(config))
 		if negotiateErr == nil {
 			// Success!
 			clientConn.SetDeadline(time.Time{}) // Remove deadline
-			log.Printf("Successfully re-negotiated telnet after %d attempts", attempts+1)
+			if !quiet || attempt > 0 {
+				log.Printf("Successfully re-negotiated telnet after %d attempts", attempt+1)
+			}
 			break
 		}
 
-		log.Printf("Telnet re-negotiation attempt %d failed: %v", attempts+1, negotiateErr)
-		time.Sleep(1 * time.Second) // Wait before retry
-	}
-
-	// Log errors for debugging (only log non-EOF errors)
-	if finalErr != nil && finalErr != io.EOF {
-		log.Printf("DEBUG: Connection error: %v", finalErr)
+		if !quiet || attempt > 0 {
+			log.Printf("Telnet re-negotiation attempt %d failed: %v", attempt+1, negotiateErr)
+		}
+		if attempt < attempts-1 {
+			time.Sleep(delay) // Wait before retry
+		}
 	}
 
 	// Remove any deadlines
 	clientConn.SetDeadline(time.Time{})
-
-	// Always return nil to get back to the host menu
-	return nil
+	return attempts, negotiateErr
 }
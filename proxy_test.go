@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/racingmars/go3270"
+)
+
+// tcpPair returns both ends of a loopback TCP connection, which unlike
+// net.Pipe buffers writes the way a real terminal connection does
+func tcpPair(t *testing.T) (proxySide, terminalSide net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	terminalSide, err = net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxySide = <-accepted
+	if proxySide == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() { proxySide.Close(); terminalSide.Close() })
+	return proxySide, terminalSide
+}
+
+func TestRenegotiateTelnet(t *testing.T) {
+	doTermType := []byte{telnetIAC, telnetDo, optTermType}
+	answer := append([]byte{telnetIAC, telnetWill, optTermType, telnetIAC, telnetSB, optTermType, termTypeIs},
+		append([]byte("IBM-3278-2-E"), telnetIAC, telnetSE, telnetIAC, telnetWill, optEOR, telnetIAC, telnetWill, optBinary)...)
+
+	tests := []struct {
+		name     string
+		junk     int // Negotiations answered with data that isn't telnet
+		closed   bool
+		wantErr  bool
+		attempts int // Negotiations the terminal should see
+	}{
+		{"terminal answers", 0, false, false, 1},
+		{"stale data first", 1, false, false, 2},
+		{"not a terminal", 3, false, true, 3},
+		{"terminal gone", 0, true, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxySide, terminalSide := tcpPair(t)
+			config := &Config{RenegotiateTries: 3, RenegotiateDelayMs: 10, FirstByteTimeout: 1}
+			if tt.closed {
+				terminalSide.Close()
+			}
+
+			seen := make(chan int, 1)
+			go func() {
+				negotiations := 0
+				defer func() { seen <- negotiations }()
+				if tt.closed {
+					return
+				}
+				buffer := make([]byte, 1024)
+				for {
+					n, err := terminalSide.Read(buffer)
+					if err != nil {
+						return
+					}
+					for count := bytes.Count(buffer[:n], doTermType); count > 0; count-- {
+						negotiations++
+						if negotiations <= tt.junk {
+							terminalSide.Write([]byte{0x7d, 0x40, 0x40})
+						} else {
+							terminalSide.Write(answer)
+						}
+					}
+				}
+			}()
+
+			_, err := renegotiateTelnet(proxySide, config, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renegotiateTelnet error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, errNotTN3270) {
+				t.Errorf("renegotiateTelnet error = %v, want errNotTN3270", err)
+			}
+			proxySide.Close()
+			terminalSide.Close()
+			if got := <-seen; got != tt.attempts {
+				t.Errorf("terminal saw %d negotiations, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestFitHostLine(t *testing.T) {
+	longName := strings.Repeat("N", 45)
+	longAddr := "(" + strings.Repeat("h", 90) + ".example.com:3270)"
+
+	tests := []struct {
+		name string
+		addr string
+	}{
+		{"MVS1", "(mvs1:3270)"},
+		{strings.Repeat("N", 29), "(mvs1.example.com:3270)"},
+		{strings.Repeat("N", 30), "(mvs1.example.com:3270)"},
+		{longName, "(mvs1.example.com:3270)"},
+		{"MVS1", "(" + strings.Repeat("h", 50) + ":3270)"},
+		{longName, longAddr},
+		{"", ""},
+	}
+	for _, cols := range []int{80, 132} {
+		for _, nameCol := range []int{5, 7} { // 7 with the health marker
+			for _, tt := range tests {
+				hostName, hostAddr := fitHostLine(nameCol, cols, tt.name, tt.addr)
+
+				// The name's attribute is at nameCol and the address's takes
+				// the name's last column, as on the host menu
+				last := nameCol + len(hostName) + len(hostAddr)
+				if last > cols-1 {
+					t.Errorf("%d cols, name at %d, %d+%d chars: ends in column %d, past %d",
+						cols, nameCol, len(tt.name), len(tt.addr), last, cols-1)
+				}
+				if len(hostName) < minHostNameWidth || hostName[len(hostName)-1] != ' ' {
+					t.Errorf("%d cols, name at %d: name %q isn't padded to at least %d", cols, nameCol, hostName, minHostNameWidth)
+				}
+
+				shownName := strings.TrimRight(hostName, " ")
+				if shownName != tt.name && !(strings.HasSuffix(shownName, "...") && strings.HasPrefix(tt.name, strings.TrimSuffix(shownName, "..."))) {
+					t.Errorf("%d cols, name at %d: name %q shown as %q", cols, nameCol, tt.name, shownName)
+				}
+				if hostAddr != tt.addr && !(strings.HasSuffix(hostAddr, "...") && strings.HasPrefix(tt.addr, strings.TrimSuffix(hostAddr, "..."))) {
+					t.Errorf("%d cols, name at %d: address %q shown as %q", cols, nameCol, tt.addr, hostAddr)
+				}
+				// The name only shrinks when the address doesn't fit beside it
+				if len(tt.name) < hostNameWidth && len(tt.addr) <= cols-1-nameCol-hostNameWidth && shownName != tt.name {
+					t.Errorf("%d cols, name at %d: %q was shortened to %q", cols, nameCol, tt.name, shownName)
+				}
+			}
+		}
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"MVS1", 10, "MVS1"},
+		{"MVS1", 4, "MVS1"},
+		{"MVS1 PRODUCTION", 10, "MVS1 PR..."},
+		{"MVS1", 3, "MVS"},
+		{"MVS1", 0, ""},
+		{"MVS1", -5, ""},
+	}
+	for _, tt := range tests {
+		if got := truncateText(tt.s, tt.width); got != tt.want {
+			t.Errorf("truncateText(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestAutoConnectUnreachableLogsOff(t *testing.T) {
+	host, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := host.Addr().(*net.TCPAddr).Port
+	host.Close() // Nothing listens there any more
+
+	proxySide, terminalSide := tcpPair(t)
+	config := &Config{AutoConnectReconnect: true}
+	authSession := &authSession{username: "jdoe", remoteAddr: "192.0.2.1:1234", ctx: context.Background()}
+
+	done := make(chan struct{})
+	go func() {
+		handleAutoConnect(proxySide, config, authSession, Host{Name: "TEST", Host: "127.0.0.1", Port: port, Negotiation: "raw"})
+		close(done)
+	}()
+
+	// Wait for the error screen, then press PF3
+	var screen []byte
+	buffer := make([]byte, 4096)
+	for !bytes.HasSuffix(screen, []byte{telnetIAC, telnetEOR}) {
+		terminalSide.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := terminalSide.Read(buffer)
+		if err != nil {
+			t.Fatalf("no error screen: %v", err)
+		}
+		screen = append(screen, buffer[:n]...)
+	}
+	terminalSide.Write([]byte{byte(go3270.AIDPF3), 0x40, 0x40, telnetIAC, telnetEOR})
+
+	go io.Copy(io.Discard, terminalSide)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("PF3 on the error screen didn't log off, the auto-connect loop is still running")
+	}
+	if authSession.hostSessions != 0 {
+		t.Errorf("%d host sessions counted for a host that was never reached", authSession.hostSessions)
+	}
+}
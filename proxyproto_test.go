@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// proxyV2Header builds a v2 header with the given command, family and
+// address block
+func proxyV2Header(command, family byte, block []byte) []byte {
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:16], uint16(len(block)))
+	return append(header, block...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	ipv4Block := []byte{
+		192, 0, 2, 10, // source
+		198, 51, 100, 1, // destination
+		0x30, 0x39, // source port 12345
+		0x0c, 0xc6, // destination port 3270
+	}
+	ipv6Block := make([]byte, 36)
+	copy(ipv6Block, net.ParseIP("2001:db8::1"))
+	copy(ipv6Block[16:], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(ipv6Block[32:], 4000)
+	binary.BigEndian.PutUint16(ipv6Block[34:], 3270)
+
+	tests := []struct {
+		name    string
+		header  []byte
+		want    string // Expected client address, "" for the socket's own
+		wantErr bool
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 192.0.2.10 198.51.100.1 12345 3270\r\n"), "192.0.2.10:12345", false},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 4000 3270\r\n"), "[2001:db8::1]:4000", false},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 UNKNOWN with addresses", []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"), "", false},
+		{"v1 without CR", []byte("PROXY TCP4 192.0.2.10 198.51.100.1 12345 3270\n"), "", true},
+		{"v1 truncated", []byte("PROXY TCP4 192.0.2.10"), "", true},
+		{"v1 too long", append([]byte("PROXY TCP4 "), bytes.Repeat([]byte("1"), proxyV1MaxLength)...), "", true},
+		{"v1 missing fields", []byte("PROXY TCP4 192.0.2.10 198.51.100.1 12345\r\n"), "", true},
+		{"v1 wrong family", []byte("PROXY TCP4 2001:db8::1 2001:db8::2 4000 3270\r\n"), "", true},
+		{"v1 bad address", []byte("PROXY TCP4 192.0.2.300 198.51.100.1 12345 3270\r\n"), "", true},
+		{"v1 bad port", []byte("PROXY TCP4 192.0.2.10 198.51.100.1 70000 3270\r\n"), "", true},
+		{"v1 unknown protocol", []byte("PROXY UDP4 192.0.2.10 198.51.100.1 12345 3270\r\n"), "", true},
+		{"no header", []byte("GET / HTTP/1.0\r\n"), "", true},
+		{"v2 TCP4", proxyV2Header(0x1, 0x11, ipv4Block), "192.0.2.10:12345", false},
+		{"v2 TCP6", proxyV2Header(0x1, 0x21, ipv6Block), "[2001:db8::1]:4000", false},
+		{"v2 TCP4 with TLVs", proxyV2Header(0x1, 0x11, append(append([]byte(nil), ipv4Block...), 0x04, 0x00, 0x01, 0xff)), "192.0.2.10:12345", false},
+		{"v2 LOCAL", proxyV2Header(0x0, 0x00, nil), "", false},
+		{"v2 UNSPEC", proxyV2Header(0x1, 0x00, nil), "", false},
+		{"v2 short IPv4 block", proxyV2Header(0x1, 0x11, ipv4Block[:8]), "", true},
+		{"v2 short IPv6 block", proxyV2Header(0x1, 0x21, ipv6Block[:20]), "", true},
+		{"v2 truncated payload", proxyV2Header(0x1, 0x11, ipv4Block)[:20], "", true},
+		{"v2 truncated header", proxyV2Header(0x1, 0x11, ipv4Block)[:14], "", true},
+		{"v2 bad version", append(append([]byte(nil), proxyV2Signature...), 0x11, 0x11, 0, 0), "", true},
+		{"v2 bad command", proxyV2Header(0x2, 0x11, ipv4Block), "", true},
+		{"v2 UNIX family", proxyV2Header(0x1, 0x31, make([]byte, 216)), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+			go func() {
+				client.Write(tt.header)
+				client.Write([]byte("payload"))
+				client.Close()
+			}()
+
+			conn, err := readProxyHeader(server)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("readProxyHeader succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readProxyHeader: %v", err)
+			}
+
+			want := tt.want
+			if want == "" {
+				want = server.RemoteAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != want {
+				t.Errorf("RemoteAddr = %s, want %s", got, want)
+			}
+
+			// Whatever follows the header must still be readable
+			rest, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("reading after the header: %v", err)
+			}
+			if string(rest) != "payload" {
+				t.Errorf("data after the header = %q, want %q", rest, "payload")
+			}
+		})
+	}
+}
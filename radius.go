@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/racingmars/go3270"
+)
+
+const (
+	defaultRADIUSPort    = "1812"
+	defaultRADIUSRetries = 2
+	// Challenges answered in one logon before giving up
+	maxRADIUSChallenges = 5
+
+	radiusAccessRequest   = 1
+	radiusAccessAccept    = 2
+	radiusAccessReject    = 3
+	radiusAccessChallenge = 11
+
+	radiusAttrUserName       = 1
+	radiusAttrUserPassword   = 2
+	radiusAttrReplyMessage   = 18
+	radiusAttrState          = 24
+	radiusAttrVendorSpecific = 26
+	radiusAttrNASIdentifier  = 32
+	radiusAttrPrompt         = 76
+	radiusAttrMessageAuth    = 80
+
+	fieldChallenge = "challenge"
+)
+
+// radiusPacket is a decoded RADIUS packet (RFC 2865)
+type radiusPacket struct {
+	code          byte
+	id            byte
+	authenticator [16]byte
+	attributes    []radiusAttribute
+}
+
+type radiusAttribute struct {
+	typ   byte
+	value []byte
+}
+
+// parseRADIUSHostFileAttr reads radiushostfileattr, the vendor:type of the
+// Vendor-Specific attribute naming a user's host file, e.g. 27000:1
+func parseRADIUSHostFileAttr(value string) (uint32, byte, error) {
+	vendor, typ, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("%q is not vendor:type", value)
+	}
+	vendorID, err := strconv.ParseUint(strings.TrimSpace(vendor), 10, 32)
+	if err != nil || vendorID == 0 {
+		return 0, 0, fmt.Errorf("invalid vendor id %q", vendor)
+	}
+	typeID, err := strconv.ParseUint(strings.TrimSpace(typ), 10, 8)
+	if err != nil || typeID == 0 {
+		return 0, 0, fmt.Errorf("invalid attribute type %q", typ)
+	}
+	return uint32(vendorID), byte(typeID), nil
+}
+
+// checkRADIUSConfig makes sure the RADIUS settings can work and resolves
+// the shared secret, which may be an @file: or @exec: reference
+func checkRADIUSConfig(config *Config) error {
+	if config.LDAPURL != "" {
+		return fmt.Errorf("use either ldapurl or radiusservers, not both")
+	}
+	if config.RADIUSSecret == "" {
+		return fmt.Errorf("radiusservers needs a radiussecret")
+	}
+	if isPasswordRef(config.RADIUSSecret) {
+		secret, err := resolvePasswordRef(config.RADIUSSecret)
+		if err != nil {
+			return fmt.Errorf("radiussecret: %v", err)
+		}
+		config.RADIUSSecret = secret
+	}
+	for i, server := range config.RADIUSServers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			config.RADIUSServers[i] = net.JoinHostPort(server, defaultRADIUSPort)
+		}
+	}
+	return nil
+}
+
+// authenticateRADIUS sends an Access-Request with the user's password to
+// the RADIUS servers in turn. An Access-Challenge, e.g. for the next token
+// code, is answered on a follow-up screen. On Access-Accept the user's
+// profile from users.cnf is returned, if there is one, with the host file
+// from radiushostfileattr if the server sent it. errAuthRejected means the
+// login was refused; any other error means no server could be reached.
+func authenticateRADIUS(config *Config, conn net.Conn, username, password string) (User, error) {
+	if username == "" || password == "" {
+		return User{}, errAuthRejected
+	}
+
+	attributes := []radiusAttribute{{radiusAttrUserName, []byte(username)}}
+	server := ""
+	for challenges := 0; ; challenges++ {
+		response, used, err := radiusExchange(config, server, attributes, password)
+		if err != nil {
+			return User{}, err
+		}
+		server = used
+
+		switch response.code {
+		case radiusAccessAccept:
+			user := localProfile(config, username)
+			user.Password = ""
+			user.MustChangePassword = false
+			if config.RADIUSHostFileVendor != 0 {
+				if hostFile := response.vendorAttribute(config.RADIUSHostFileVendor, config.RADIUSHostFileType); hostFile != "" {
+					user.HostFile = hostFile
+				}
+			}
+			return user, nil
+
+		case radiusAccessReject:
+			log.Printf("RADIUS server %s rejected %s: %s", server, username, response.replyMessage())
+			return User{}, errAuthRejected
+
+		case radiusAccessChallenge:
+			if challenges >= maxRADIUSChallenges {
+				log.Printf("RADIUS server %s sent too many challenges for %s", server, username)
+				return User{}, errAuthRejected
+			}
+			reply, ok := HandleRADIUSChallenge(conn, config, response.replyMessage(), !response.echo())
+			if !ok {
+				return User{}, errAuthRejected
+			}
+			// The answer goes to the same server, with its State
+			attributes = []radiusAttribute{{radiusAttrUserName, []byte(username)}}
+			if state := response.attribute(radiusAttrState); state != nil {
+				attributes = append(attributes, radiusAttribute{radiusAttrState, state})
+			}
+			password = reply
+
+		default:
+			return User{}, fmt.Errorf("unexpected RADIUS response code %d from %s", response.code, server)
+		}
+	}
+}
+
+// HandleRADIUSChallenge shows the RADIUS server's challenge and returns the
+// user's answer, or false when the user pressed PF3 or the screen failed
+func HandleRADIUSChallenge(conn net.Conn, config *Config, message string, hidden bool) (string, bool) {
+	if message == "" {
+		message = "Enter the response to the challenge:"
+	}
+
+	th := config.Theme
+	screen := go3270.Screen{
+		{Row: 0, Col: 0, Content: strings.Repeat("-", 16) + " SECURE3270PROXY - CHALLENGE " + strings.Repeat("-", 16), Color: th.title(go3270.White)},
+		{Row: 2, Col: 0, Content: "PF3 ==> Back to logon", Color: go3270.White},
+	}
+	row := 4
+	for _, line := range strings.Split(strings.ReplaceAll(message, "\r", ""), "\n") {
+		if row > 10 {
+			break
+		}
+		screen = append(screen, go3270.Field{Row: row, Col: 3, Content: truncateText(line, 76), Color: go3270.White})
+		row++
+	}
+	screen = append(screen,
+		go3270.Field{Row: 12, Col: 3, Content: "RESPONSE  ", Color: th.label(go3270.Turquoise)},
+		go3270.Field{Row: 12, Col: 13, Content: "===>", Color: go3270.White},
+		go3270.Field{Row: 12, Col: 19, Name: fieldChallenge, Write: true, Hidden: hidden, Color: th.input(go3270.Red)},
+		go3270.Field{Row: 12, Col: 60, Autoskip: true},
+		go3270.Field{Row: 19, Col: 3, Name: fieldErrorMsg, Color: th.error(go3270.Red), Intense: true},
+	)
+	rules := go3270.Rules{
+		fieldChallenge: {Validator: go3270.NonBlank, Reset: true},
+	}
+
+	conn.SetReadDeadline(time.Now().Add(secondsOrDefault(config.AuthTimeout, 300*time.Second)))
+	defer conn.SetReadDeadline(time.Time{})
+	resp, err := go3270.HandleScreen(
+		screen,
+		rules,
+		nil,
+		[]go3270.AID{go3270.AIDEnter},
+		[]go3270.AID{go3270.AIDPF3},
+		fieldErrorMsg,
+		12, 20,
+		conn,
+	)
+	if err != nil {
+		log.Printf("Error showing RADIUS challenge to %s: %v", conn.RemoteAddr(), err)
+		return "", false
+	}
+	if resp.AID == go3270.AIDPF3 {
+		return "", false
+	}
+	return strings.TrimSpace(resp.Values[fieldChallenge]), true
+}
+
+// radiusExchange sends one Access-Request and waits for its answer. With
+// server empty each configured server is tried in order; a challenge answer
+// goes back to the server that sent the challenge. Unanswered requests are
+// resent radiusretries times per server.
+func radiusExchange(config *Config, server string, attributes []radiusAttribute, password string) (*radiusPacket, string, error) {
+	servers := config.RADIUSServers
+	if server != "" {
+		servers = []string{server}
+	}
+	retries := config.RADIUSRetries
+	timeout := secondsOrDefault(config.RADIUSTimeout, 5*time.Second)
+
+	var lastErr error
+	for _, addr := range servers {
+		request, err := newAccessRequest(config.RADIUSSecret, attributes, password)
+		if err != nil {
+			return nil, "", err
+		}
+		response, err := radiusSend(addr, request, config.RADIUSSecret, retries, timeout)
+		if err == nil {
+			return response, addr, nil
+		}
+		log.Printf("RADIUS server %s failed: %v", addr, err)
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no RADIUS servers configured")
+	}
+	return nil, "", lastErr
+}
+
+// radiusSend sends a request to one server and returns its verified answer.
+// Packets with the wrong id or authenticator, e.g. forged answers, are
+// dropped.
+func radiusSend(addr string, request *radiusPacket, secret string, retries int, timeout time.Duration) (*radiusPacket, error) {
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	data := request.encode(secret)
+	buf := make([]byte, 4096)
+	for attempt := 0; attempt <= retries; attempt++ {
+		if _, err := conn.Write(data); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			response, err := decodeRADIUSResponse(buf[:n], request, secret)
+			if err != nil {
+				log.Printf("Dropped RADIUS packet from %s: %v", addr, err)
+				continue
+			}
+			return response, nil
+		}
+	}
+	return nil, fmt.Errorf("no answer after %d attempts", retries+1)
+}
+
+// newAccessRequest builds an Access-Request with a random authenticator,
+// the PAP encrypted password and a Message-Authenticator
+func newAccessRequest(secret string, attributes []radiusAttribute, password string) (*radiusPacket, error) {
+	if len(password) > 128 {
+		return nil, fmt.Errorf("password too long for RADIUS")
+	}
+	request := &radiusPacket{code: radiusAccessRequest}
+	var id [1]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(request.authenticator[:]); err != nil {
+		return nil, err
+	}
+	request.id = id[0]
+
+	request.attributes = append(request.attributes, attributes...)
+	request.attributes = append(request.attributes,
+		radiusAttribute{radiusAttrUserPassword, encryptPAP([]byte(password), secret, request.authenticator)},
+		radiusAttribute{radiusAttrNASIdentifier, []byte("secure3270proxy")},
+	)
+	return request, nil
+}
+
+// encryptPAP hides the User-Password as described in RFC 2865 section 5.2
+func encryptPAP(password []byte, secret string, authenticator [16]byte) []byte {
+	padded := make([]byte, max((len(password)+15)/16, 1)*16)
+	copy(padded, password)
+
+	previous := authenticator[:]
+	for i := 0; i < len(padded); i += 16 {
+		hash := md5.Sum(append([]byte(secret), previous...))
+		for j := 0; j < 16; j++ {
+			padded[i+j] ^= hash[j]
+		}
+		previous = padded[i : i+16]
+	}
+	return padded
+}
+
+// encode serializes a request, signing it with a Message-Authenticator
+func (p *radiusPacket) encode(secret string) []byte {
+	var body bytes.Buffer
+	for _, attr := range p.attributes {
+		body.WriteByte(attr.typ)
+		body.WriteByte(byte(len(attr.value) + 2))
+		body.Write(attr.value)
+	}
+	// Message-Authenticator, filled in once the packet is complete
+	body.Write([]byte{radiusAttrMessageAuth, 18})
+	macOffset := 20 + body.Len()
+	body.Write(make([]byte, 16))
+
+	data := make([]byte, 20, 20+body.Len())
+	data[0] = p.code
+	data[1] = p.id
+	binary.BigEndian.PutUint16(data[2:4], uint16(20+body.Len()))
+	copy(data[4:20], p.authenticator[:])
+	data = append(data, body.Bytes()...)
+
+	mac := hmac.New(md5.New, []byte(secret))
+	mac.Write(data)
+	copy(data[macOffset:], mac.Sum(nil))
+	return data
+}
+
+// decodeRADIUSResponse parses an answer and checks it belongs to request:
+// same id, a Response Authenticator made with the shared secret and a valid
+// Message-Authenticator. The request always carries one, so an answer
+// without it is refused; otherwise a forged Access-Accept could slip through
+// (Blast-RADIUS, CVE-2024-3596).
+func decodeRADIUSResponse(data []byte, request *radiusPacket, secret string) (*radiusPacket, error) {
+	if len(data) < 20 {
+		return nil, fmt.Errorf("packet too short")
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if length < 20 || length > len(data) {
+		return nil, fmt.Errorf("invalid length %d", length)
+	}
+	data = data[:length]
+	if data[1] != request.id {
+		return nil, fmt.Errorf("unexpected id %d", data[1])
+	}
+
+	// MD5(Code+ID+Length+RequestAuth+Attributes+Secret)
+	hash := md5.New()
+	hash.Write(data[:4])
+	hash.Write(request.authenticator[:])
+	hash.Write(data[20:])
+	hash.Write([]byte(secret))
+	if !hmac.Equal(hash.Sum(nil), data[4:20]) {
+		return nil, fmt.Errorf("bad response authenticator (wrong radiussecret?)")
+	}
+
+	response := &radiusPacket{code: data[0], id: data[1]}
+	copy(response.authenticator[:], data[4:20])
+	hasMessageAuth := false
+	for rest := data[20:]; len(rest) > 0; {
+		if len(rest) < 2 || int(rest[1]) < 2 || int(rest[1]) > len(rest) {
+			return nil, fmt.Errorf("invalid attribute")
+		}
+		attrLen := int(rest[1])
+		if rest[0] == radiusAttrMessageAuth {
+			if attrLen != 18 {
+				return nil, fmt.Errorf("invalid Message-Authenticator")
+			}
+			offset := length - len(rest) + 2
+			signed := append([]byte(nil), data...)
+			copy(signed[4:20], request.authenticator[:])
+			copy(signed[offset:offset+16], make([]byte, 16))
+			mac := hmac.New(md5.New, []byte(secret))
+			mac.Write(signed)
+			if !hmac.Equal(mac.Sum(nil), rest[2:18]) {
+				return nil, fmt.Errorf("bad Message-Authenticator")
+			}
+			hasMessageAuth = true
+		}
+		response.attributes = append(response.attributes, radiusAttribute{rest[0], rest[2:attrLen]})
+		rest = rest[attrLen:]
+	}
+
+	switch response.code {
+	case radiusAccessAccept, radiusAccessReject, radiusAccessChallenge:
+		if !hasMessageAuth {
+			return nil, fmt.Errorf("response has no Message-Authenticator")
+		}
+	}
+	return response, nil
+}
+
+// attribute returns the first value of an attribute, or nil
+func (p *radiusPacket) attribute(typ byte) []byte {
+	for _, attr := range p.attributes {
+		if attr.typ == typ {
+			return attr.value
+		}
+	}
+	return nil
+}
+
+// replyMessage joins all Reply-Message attributes, one per line
+func (p *radiusPacket) replyMessage() string {
+	var lines []string
+	for _, attr := range p.attributes {
+		if attr.typ == radiusAttrReplyMessage {
+			lines = append(lines, strings.TrimRight(string(attr.value), "\r\n"))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// echo reports whether a challenge asks for the response to be shown as
+// it is typed (Prompt attribute, RFC 2869)
+func (p *radiusPacket) echo() bool {
+	prompt := p.attribute(radiusAttrPrompt)
+	return len(prompt) == 4 && binary.BigEndian.Uint32(prompt) == 1
+}
+
+// vendorAttribute returns a Vendor-Specific sub-attribute as text, or ""
+func (p *radiusPacket) vendorAttribute(vendor uint32, typ byte) string {
+	for _, attr := range p.attributes {
+		if attr.typ != radiusAttrVendorSpecific || len(attr.value) < 4 || binary.BigEndian.Uint32(attr.value) != vendor {
+			continue
+		}
+		for rest := attr.value[4:]; len(rest) >= 2 && int(rest[1]) >= 2 && int(rest[1]) <= len(rest); rest = rest[rest[1]:] {
+			if rest[0] == typ {
+				return strings.TrimSpace(string(rest[2:rest[1]]))
+			}
+		}
+	}
+	return ""
+}
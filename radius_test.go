@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Example from RFC 2865 section 7.1: user nemo, password arctangent
+const (
+	rfc2865Secret        = "xyzzy5461"
+	rfc2865Authenticator = "0f403f9473978057bd83d5cb98f4227a"
+	rfc2865Password      = "0dbe708d93d413ce3196e43f782a0aee"
+	rfc2865Accept        = "02 00 00 26 86 fe 22 0e 76 24 ba 2a 10 05 f6 bf 9b 55 e0 b2" +
+		"06 06 00 00 00 01 0f 06 00 00 00 00 0e 06 c0 a8 01 03"
+)
+
+// rfc2865Request is the Access-Request of the RFC example
+func rfc2865Request(t *testing.T) *radiusPacket {
+	request := &radiusPacket{code: radiusAccessRequest, id: 0}
+	copy(request.authenticator[:], unhex(t, rfc2865Authenticator))
+	return request
+}
+
+// signResponse fills in a response's Message-Authenticator (RFC 3579
+// section 3.2), then its Response Authenticator (RFC 2865 section 3)
+func signResponse(data []byte, request *radiusPacket, secret string) []byte {
+	data = append([]byte(nil), data...)
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+
+	for rest := data[20:]; len(rest) >= 2; rest = rest[rest[1]:] {
+		if rest[0] == radiusAttrMessageAuth {
+			copy(data[4:20], request.authenticator[:])
+			mac := hmac.New(md5.New, []byte(secret))
+			mac.Write(data)
+			copy(rest[2:18], mac.Sum(nil))
+		}
+	}
+
+	hash := md5.New()
+	hash.Write(data[:4])
+	hash.Write(request.authenticator[:])
+	hash.Write(data[20:])
+	hash.Write([]byte(secret))
+	copy(data[4:20], hash.Sum(nil))
+	return data
+}
+
+// radiusResponse builds an unsigned response with the given attributes
+// and a zeroed Message-Authenticator if withMAC is set
+func radiusResponse(code, id byte, withMAC bool, attributes ...radiusAttribute) []byte {
+	data := make([]byte, 20)
+	data[0], data[1] = code, id
+	for _, attr := range attributes {
+		data = append(data, attr.typ, byte(len(attr.value)+2))
+		data = append(data, attr.value...)
+	}
+	if withMAC {
+		data = append(data, radiusAttrMessageAuth, 18)
+		data = append(data, make([]byte, 16)...)
+	}
+	return data
+}
+
+func TestEncryptPAP(t *testing.T) {
+	var authenticator [16]byte
+	copy(authenticator[:], unhex(t, rfc2865Authenticator))
+
+	got := encryptPAP([]byte("arctangent"), rfc2865Secret, authenticator)
+	if !bytes.Equal(got, unhex(t, rfc2865Password)) {
+		t.Errorf("encryptPAP = %x, want %s", got, rfc2865Password)
+	}
+
+	// Longer passwords are chained over 16 byte blocks
+	tests := []struct {
+		password string
+		length   int
+	}{
+		{"", 16},
+		{"0123456789abcdef", 16},
+		{"0123456789abcdefg", 32},
+		{strings.Repeat("x", 128), 128},
+	}
+	for _, tt := range tests {
+		got := encryptPAP([]byte(tt.password), rfc2865Secret, authenticator)
+		if len(got) != tt.length {
+			t.Errorf("encryptPAP of %d bytes is %d bytes long, want %d", len(tt.password), len(got), tt.length)
+		}
+
+		// Undo it as a RADIUS server would
+		plain := make([]byte, len(got))
+		previous := authenticator[:]
+		for i := 0; i < len(got); i += 16 {
+			hash := md5.Sum(append([]byte(rfc2865Secret), previous...))
+			for j := 0; j < 16; j++ {
+				plain[i+j] = got[i+j] ^ hash[j]
+			}
+			previous = got[i : i+16]
+		}
+		if string(bytes.TrimRight(plain, "\x00")) != tt.password {
+			t.Errorf("encryptPAP of %q decrypts to %q", tt.password, plain)
+		}
+	}
+}
+
+func TestRADIUSEncode(t *testing.T) {
+	request := rfc2865Request(t)
+	request.attributes = []radiusAttribute{
+		{radiusAttrUserName, []byte("nemo")},
+		{radiusAttrUserPassword, unhex(t, rfc2865Password)},
+		{4, unhex(t, "c0a80110")}, // NAS-IP-Address
+		{5, unhex(t, "00000003")}, // NAS-Port
+	}
+	data := request.encode(rfc2865Secret)
+
+	// The RFC example, plus the Message-Authenticator that is always added
+	want := unhex(t, "01 00 00 4a 0f 40 3f 94 73 97 80 57 bd 83 d5 cb 98 f4 22 7a"+
+		"01 06 6e 65 6d 6f 02 12 0d be 70 8d 93 d4 13 ce 31 96 e4 3f 78 2a 0a ee"+
+		"04 06 c0 a8 01 10 05 06 00 00 00 03 50 12")
+	if !bytes.Equal(data[:len(want)], want) || len(data) != len(want)+16 {
+		t.Fatalf("encode = % x\nwant    % x and 16 bytes of Message-Authenticator", data, want)
+	}
+
+	// HMAC-MD5 over the packet with the Message-Authenticator zeroed
+	zeroed := append(append([]byte(nil), want...), make([]byte, 16)...)
+	mac := hmac.New(md5.New, []byte(rfc2865Secret))
+	mac.Write(zeroed)
+	if !bytes.Equal(data[len(want):], mac.Sum(nil)) {
+		t.Errorf("Message-Authenticator = %x, want %x", data[len(want):], mac.Sum(nil))
+	}
+}
+
+func TestDecodeRADIUSResponse(t *testing.T) {
+	request := rfc2865Request(t)
+	reply := radiusAttribute{radiusAttrReplyMessage, []byte("Welcome")}
+
+	signed := func(code byte) []byte {
+		return signResponse(radiusResponse(code, 0, true, reply), request, rfc2865Secret)
+	}
+	tampered := func(data []byte, offset int) []byte {
+		data = append([]byte(nil), data...)
+		data[offset] ^= 0x01
+		return data
+	}
+	accept := signed(radiusAccessAccept)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		code    byte
+		wantErr string
+	}{
+		{"accept", accept, radiusAccessAccept, ""},
+		{"reject", signed(radiusAccessReject), radiusAccessReject, ""},
+		{"challenge", signed(radiusAccessChallenge), radiusAccessChallenge, ""},
+		{"trailing padding is ignored", append(append([]byte(nil), accept...), 0, 0, 0), radiusAccessAccept, ""},
+		// Response Authenticator of RFC 2865 section 7.1 is right, but the
+		// answer has no Message-Authenticator (Blast-RADIUS)
+		{"RFC accept without Message-Authenticator", unhex(t, rfc2865Accept), 0, "no Message-Authenticator"},
+		{"accept without Message-Authenticator", signResponse(radiusResponse(radiusAccessAccept, 0, false, reply), request, rfc2865Secret), 0, "no Message-Authenticator"},
+		{"reject without Message-Authenticator", signResponse(radiusResponse(radiusAccessReject, 0, false), request, rfc2865Secret), 0, "no Message-Authenticator"},
+		{"wrong secret", signResponse(radiusResponse(radiusAccessAccept, 0, true, reply), request, "guess"), 0, "bad response authenticator"},
+		{"changed attribute", tampered(accept, 22), 0, "bad response authenticator"},
+		{"changed response authenticator", tampered(accept, 4), 0, "bad response authenticator"},
+		{"bad Message-Authenticator", signResponse(tampered(signed(radiusAccessAccept), len(accept)-1), request, rfc2865Secret), 0, "bad Message-Authenticator"},
+		{"other id", signResponse(radiusResponse(radiusAccessAccept, 7, true), request, rfc2865Secret), 0, "unexpected id"},
+		{"too short", accept[:19], 0, "too short"},
+		{"length past the end", accept[:len(accept)-1], 0, "invalid length"},
+		{"bad attribute length", signResponse(append(radiusResponse(radiusAccessAccept, 0, true), 18, 1), request, rfc2865Secret), 0, "invalid attribute"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := decodeRADIUSResponse(tt.data, request, rfc2865Secret)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("decodeRADIUSResponse error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeRADIUSResponse: %v", err)
+			}
+			if response.code != tt.code || response.replyMessage() != "Welcome" {
+				t.Errorf("decoded code %d, reply %q", response.code, response.replyMessage())
+			}
+		})
+	}
+}
+
+func TestRADIUSVendorAttribute(t *testing.T) {
+	vsa := []byte{0, 0, 0x69, 0x78, 1, 6, 'o', 'p', 's', ' ', 2, 4, 'x', 'y'} // vendor 27000
+	packet := &radiusPacket{attributes: []radiusAttribute{{radiusAttrVendorSpecific, vsa}}}
+
+	if got := packet.vendorAttribute(27000, 1); got != "ops" {
+		t.Errorf("vendorAttribute(27000, 1) = %q, want ops", got)
+	}
+	if got := packet.vendorAttribute(27000, 2); got != "xy" {
+		t.Errorf("vendorAttribute(27000, 2) = %q, want xy", got)
+	}
+	if got := packet.vendorAttribute(9, 1); got != "" {
+		t.Errorf("vendorAttribute of another vendor = %q", got)
+	}
+}
+
+// fakeRADIUSServer accepts every Access-Request and returns its address
+func fakeRADIUSServer(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 20 {
+				continue
+			}
+			request := &radiusPacket{id: buf[1]}
+			copy(request.authenticator[:], buf[4:20])
+			conn.WriteTo(signResponse(radiusResponse(radiusAccessAccept, request.id, true), request, rfc2865Secret), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestRADIUSLocalProfile(t *testing.T) {
+	useUsers(t, []User{
+		{Username: "*", Password: "x", HostFile: "guest.list"},
+		{Username: "jdoe", Password: "x", TOTPSecret: rfc6238Secret, ReadOnly: true, AllowedHosts: []string{"mvs1"}, AfterHost: "disconnect"},
+	})
+	config := &Config{RADIUSServers: []string{fakeRADIUSServer(t)}, RADIUSSecret: rfc2865Secret, RADIUSTimeout: 2}
+
+	tests := []struct {
+		name          string
+		username      string
+		caseSensitive bool
+		want          string // Expected userid; "" when the wildcard entry is used
+	}{
+		{"exact", "jdoe", false, "jdoe"},
+		{"uppercase", "JDOE", false, "jdoe"},
+		{"mixed case", "jDoE", false, "jdoe"},
+		{"case sensitive", "JDOE", true, ""},
+		{"unlisted user", "alice", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.CaseSensitiveUsers = tt.caseSensitive
+			user, err := authenticateRADIUS(config, nil, tt.username, "secret")
+			if err != nil {
+				t.Fatalf("authenticateRADIUS: %v", err)
+			}
+			if user.Password != "" {
+				t.Errorf("profile keeps the users.cnf password")
+			}
+			if tt.want == "" {
+				if user.Username != tt.username || user.HostFile != "guest.list" || user.TOTPSecret != "" {
+					t.Errorf("authenticateRADIUS(%q) = %+v, want the wildcard profile", tt.username, user)
+				}
+				return
+			}
+			if user.Username != tt.want || user.TOTPSecret != rfc6238Secret || !user.ReadOnly ||
+				len(user.AllowedHosts) != 1 || user.AfterHost != "disconnect" {
+				t.Errorf("authenticateRADIUS(%q) = %+v, want the profile of %s", tt.username, user, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// rateLimiter is a simple token bucket used to throttle one direction of a
+// proxied session. It is only used by a single goroutine and so needs no lock.
+type rateLimiter struct {
+	rate   float64 // bytes per second
+	burst  float64 // maximum tokens that can be saved up
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter for the given rate in kilobits per
+// second, or nil if the rate is unlimited
+func newRateLimiter(kbps int) *rateLimiter {
+	if kbps <= 0 {
+		return nil
+	}
+	rate := float64(kbps) * 1000 / 8
+	return &rateLimiter{
+		rate:   rate,
+		burst:  rate, // allow up to one second worth of data at once
+		tokens: rate,
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until n bytes may be sent. Large writes are allowed to go
+// into debt, which is then paid off by sleeping. It returns early with the
+// context's error if the session is cancelled while waiting.
+func (r *rateLimiter) wait(ctx context.Context, n int) error {
+	if r == nil {
+		return nil
+	}
+
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+
+	r.tokens -= float64(n)
+	if r.tokens >= 0 {
+		return nil
+	}
+
+	delay := time.Duration(-r.tokens / r.rate * float64(time.Second))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
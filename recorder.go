@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Chunks of session data that may queue up for the disk before new data is dropped
+const recorderQueueSize = 1024
+
+// Directions stored in each recording frame
+const (
+	recordFromClient byte = 'C' // Terminal to host
+	recordFromHost   byte = 'H' // Host to terminal
+)
+
+// sessionRecorder writes the raw 3270 data stream of a proxied session to a
+// file. Each frame is a direction byte, the time as 8 bytes of Unix
+// nanoseconds, a 4 byte length and the data, all integers big endian.
+// Writing happens in the background so a slow disk never stalls the session;
+// if the queue fills up, data is dropped and counted instead.
+type sessionRecorder struct {
+	filename string
+	frames   chan []byte
+	done     chan struct{}
+	dropped  int64
+}
+
+// newSessionRecorder creates a timestamped recording file named after the
+// user and host in dir
+func newSessionRecorder(dir, username, host string) (*sessionRecorder, error) {
+	name := fmt.Sprintf("%s-%s-%s.3270rec", time.Now().Format("20060102-150405"),
+		safeFileName(username), safeFileName(host))
+	filename := filepath.Join(dir, name)
+
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session recording %s: %v", filename, err)
+	}
+
+	r := &sessionRecorder{
+		filename: filename,
+		frames:   make(chan []byte, recorderQueueSize),
+		done:     make(chan struct{}),
+	}
+
+	go func() {
+		defer close(r.done)
+		writer := bufio.NewWriter(file)
+		failed := false
+		for frame := range r.frames {
+			if failed {
+				continue
+			}
+			if _, err := writer.Write(frame); err != nil {
+				log.Printf("Session recording %s failed: %v", filename, err)
+				failed = true
+			}
+		}
+		if err := writer.Flush(); err != nil && !failed {
+			log.Printf("Session recording %s failed: %v", filename, err)
+		}
+		file.Close()
+	}()
+
+	return r, nil
+}
+
+// record queues a copy of data. It never blocks; a nil recorder does nothing.
+func (r *sessionRecorder) record(direction byte, data []byte) {
+	if r == nil || len(data) == 0 {
+		return
+	}
+
+	frame := make([]byte, 13+len(data))
+	frame[0] = direction
+	binary.BigEndian.PutUint64(frame[1:9], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(frame[9:13], uint32(len(data)))
+	copy(frame[13:], data)
+
+	select {
+	case r.frames <- frame:
+	default:
+		atomic.AddInt64(&r.dropped, int64(len(data)))
+	}
+}
+
+// close flushes the recording once the session is over
+func (r *sessionRecorder) close() {
+	if r == nil {
+		return
+	}
+	close(r.frames)
+	<-r.done
+
+	if dropped := atomic.LoadInt64(&r.dropped); dropped > 0 {
+		log.Printf("Warning: session recording %s is incomplete, %d bytes dropped", r.filename, dropped)
+	}
+}
+
+// safeFileName keeps letters, digits, dots and dashes so user and host
+// names can't escape the recording directory
+func safeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"reflect"
+)
+
+// restartSettings are read once when the listeners and background workers
+// start. A reload can't change them; they need a restart.
+var restartSettings = []struct {
+	name  string
+	value func(*Config) interface{}
+}{
+	{"port", func(c *Config) interface{} { return c.Port }},
+	{"bindaddress", func(c *Config) interface{} { return c.BindAddress }},
+	{"tlsbindaddress", func(c *Config) interface{} { return c.TLSBindAddress }},
+	{"unixsocket", func(c *Config) interface{} { return c.UnixSocket }},
+	{"tls", func(c *Config) interface{} { return c.TLSEnabled }},
+	{"tlsport", func(c *Config) interface{} { return c.TLSPort }},
+	{"tlsminversion", func(c *Config) interface{} { return c.TLSMinVersion }},
+	{"tlsmaxversion", func(c *Config) interface{} { return c.TLSMaxVersion }},
+	{"tlsciphers", func(c *Config) interface{} { return c.TLSCiphers }},
+	{"tlsmodern", func(c *Config) interface{} { return c.TLSModern }},
+	{"tlscert", func(c *Config) interface{} { return c.TLSCert }},
+	{"tlskey", func(c *Config) interface{} { return c.TLSKey }},
+	{"tlssnicerts", func(c *Config) interface{} { return c.TLSSNICerts }},
+	{"tlslistener", func(c *Config) interface{} { return c.TLSListeners }},
+	{"clientcafile", func(c *Config) interface{} { return c.ClientCAFile }},
+	{"tlsclientauth", func(c *Config) interface{} { return c.ClientAuth }},
+	{"maxconcurrenthandshakes", func(c *Config) interface{} { return c.MaxHandshakes }},
+	{"healthport", func(c *Config) interface{} { return c.HealthPort }},
+	{"healthcheckinterval", func(c *Config) interface{} { return c.HealthCheckInterval }},
+	{"statefile", func(c *Config) interface{} { return c.StateFile }},
+	{"lastloginfile", func(c *Config) interface{} { return c.LastLoginFile }},
+	{"logfile", func(c *Config) interface{} { return c.LogFile }},
+	{"logmaxsize", func(c *Config) interface{} { return c.LogMaxSize }},
+	{"logkeep", func(c *Config) interface{} { return c.LogKeep }},
+}
+
+// restartOnlyChanges lists the restart-only settings that differ between
+// the running and the newly loaded configuration
+func restartOnlyChanges(old, new *Config) []string {
+	var changed []string
+	for _, setting := range restartSettings {
+		if !reflect.DeepEqual(setting.value(old), setting.value(new)) {
+			changed = append(changed, setting.name)
+		}
+	}
+	return changed
+}
+
+// reloadMainConfig re-reads the main config file on SIGHUP. The new
+// configuration is parsed and validated completely before it replaces the
+// running one, so a broken file leaves everything as it was. It returns
+// false if the file could not be loaded.
+func reloadMainConfig(configFile string) bool {
+	newConfig, err := loadConfig(configFile)
+	if err != nil {
+		log.Printf("Failed to reload %s, keeping previous configuration: %v", configFile, err)
+		return false
+	}
+
+	old := getConfig()
+	for _, name := range restartOnlyChanges(old, newConfig) {
+		log.Printf("Warning: %s changed, restart the proxy to apply it", name)
+	}
+
+	if newConfig.AuditFile != old.AuditFile && newConfig.AuditFile != "" {
+		if err := openAuditLog(newConfig.AuditFile); err != nil {
+			log.Printf("Warning: %v, keeping the previous audit file", err)
+		}
+	} else if newConfig.AuditFile == "" && old.AuditFile != "" {
+		closeAuditLog()
+		log.Printf("Audit log %s closed, auditfile is no longer set", old.AuditFile)
+	}
+
+	// New connections pick up the rest: timeouts, host list, limits, screens
+	setConfig(newConfig)
+	log.Printf("Reloaded %s", configFile)
+	return true
+}
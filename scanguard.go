@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// errNotTN3270 is returned for clients that don't answer the telnet
+// negotiation like a 3270 emulator would: port scanners, bots, browsers
+var errNotTN3270 = errors.New("client did not negotiate TN3270")
+
+// Rejected connections are logged individually up to rejectLogBurst times
+// per rejectLogWindow; the rest are counted and summarized once per window
+// so a scan doesn't flood the log.
+const (
+	rejectLogWindow = time.Minute
+	rejectLogBurst  = 5
+)
+
+var (
+	rejectLock        sync.Mutex
+	rejectWindowStart time.Time
+	rejectLogged      int
+	rejectSuppressed  int
+	rejectOffenses    = make(map[string][]time.Time) // recent rejections per IP
+	rejectBanned      = make(map[string]time.Time)   // IP -> banned until
+)
+
+// noteRejected logs a connection dropped as scanner traffic, rate limited,
+// and bans the address once it reaches scanbanthreshold rejections within
+// scanbanminutes
+func noteRejected(config *Config, addr net.Addr, reason string) {
+	rejectLock.Lock()
+	defer rejectLock.Unlock()
+
+	now := time.Now()
+	rollRejectWindow(config, now)
+	if rejectLogged < rejectLogBurst {
+		log.Printf("SECURITY: rejected %s: %s", addr, reason)
+		rejectLogged++
+	} else {
+		rejectSuppressed++
+	}
+
+	ip := addrIP(addr)
+	if config.ScanBanThreshold <= 0 || ip == nil {
+		return
+	}
+	key := ip.String()
+	banTime := scanBanDuration(config)
+
+	// Only offenses within the ban period count towards the threshold
+	recent := rejectOffenses[key][:0]
+	for _, t := range rejectOffenses[key] {
+		if now.Sub(t) < banTime {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) < config.ScanBanThreshold {
+		rejectOffenses[key] = recent
+		return
+	}
+
+	delete(rejectOffenses, key)
+	rejectBanned[key] = now.Add(banTime)
+	log.Printf("SECURITY: banning %s for %v after %d rejected connections", key, banTime, len(recent))
+	auditLog("SCAN_BAN", "ip=%s minutes=%d", key, int(banTime/time.Minute))
+}
+
+// rollRejectWindow starts a new logging window when the current one is
+// over, summarizing what was suppressed and forgetting stale offenses.
+// rejectLock must be held.
+func rollRejectWindow(config *Config, now time.Time) {
+	if now.Sub(rejectWindowStart) < rejectLogWindow {
+		return
+	}
+	if rejectSuppressed > 0 {
+		log.Printf("SECURITY: %d more non-3270 connections rejected in the last %v", rejectSuppressed, rejectLogWindow)
+	}
+	rejectWindowStart, rejectLogged, rejectSuppressed = now, 0, 0
+
+	banTime := scanBanDuration(config)
+	for ip, times := range rejectOffenses {
+		if now.Sub(times[len(times)-1]) >= banTime {
+			delete(rejectOffenses, ip)
+		}
+	}
+	for ip, until := range rejectBanned {
+		if now.After(until) {
+			delete(rejectBanned, ip)
+		}
+	}
+}
+
+// isBanned reports whether an address is on the automatic denylist. Banned
+// connections are only counted, never logged one by one.
+func isBanned(config *Config, addr net.Addr) bool {
+	ip := addrIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	rejectLock.Lock()
+	defer rejectLock.Unlock()
+
+	rollRejectWindow(config, time.Now())
+	until, ok := rejectBanned[ip.String()]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(rejectBanned, ip.String())
+		return false
+	}
+	rejectSuppressed++
+	return true
+}
+
+// scanBanDuration is how long a scanner stays banned (default one hour)
+func scanBanDuration(config *Config) time.Duration {
+	if config.ScanBanMinutes > 0 {
+		return time.Duration(config.ScanBanMinutes) * time.Minute
+	}
+	return time.Hour
+}
+
+// firstByteTimeout is how long a new client has to answer the telnet
+// negotiation (default 5 seconds)
+func firstByteTimeout(config *Config) time.Duration {
+	return secondsOrDefault(config.FirstByteTimeout, 5*time.Second)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// How long an @exec: password command may run
+const secretCommandTimeout = 10 * time.Second
+
+// isPasswordRef reports whether a password field points at an external secret
+func isPasswordRef(password string) bool {
+	return strings.HasPrefix(password, "@file:") || strings.HasPrefix(password, "@exec:")
+}
+
+// resolvePasswordRef returns the password an external reference points at.
+// "@file:<path>" reads the file, "@exec:<command> <args>" runs the command
+// directly (no shell, so nothing in the arguments is interpreted) and uses
+// its output. Surrounding whitespace, such as a trailing newline, is removed.
+func resolvePasswordRef(ref string) (string, error) {
+	var secret []byte
+
+	if path, ok := strings.CutPrefix(ref, "@file:"); ok {
+		data, err := os.ReadFile(strings.TrimSpace(path))
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %v", err)
+		}
+		secret = data
+	} else if command, ok := strings.CutPrefix(ref, "@exec:"); ok {
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			return "", fmt.Errorf("empty password command")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+		defer cancel()
+
+		output, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("password command %s failed: %v", args[0], err)
+		}
+		secret = output
+	} else {
+		return ref, nil
+	}
+
+	password := strings.TrimSpace(string(secret))
+	if password == "" {
+		return "", fmt.Errorf("external password is empty")
+	}
+	return password, nil
+}
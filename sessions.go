@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	userSessionCounts = make(map[string]int)
+	totalSessionCount int
+	sessionCountLock  sync.Mutex
+)
+
+// acquireSession reserves a session slot for the user, returning false if
+// either the per-user or the global session limit has been reached
+func acquireSession(username string, config *Config) bool {
+	sessionCountLock.Lock()
+	defer sessionCountLock.Unlock()
+
+	if config.MaxTotalSessions > 0 && totalSessionCount >= config.MaxTotalSessions {
+		log.Printf("Rejecting session for %s: global limit of %d sessions reached",
+			username, config.MaxTotalSessions)
+		return false
+	}
+
+	if config.MaxSessionsPerUser > 0 && userSessionCounts[username] >= config.MaxSessionsPerUser {
+		log.Printf("Rejecting session for %s: per-user limit of %d sessions reached",
+			username, config.MaxSessionsPerUser)
+		return false
+	}
+
+	userSessionCounts[username]++
+	totalSessionCount++
+	return true
+}
+
+// releaseSession frees a slot previously reserved with acquireSession
+func releaseSession(username string) {
+	sessionCountLock.Lock()
+	defer sessionCountLock.Unlock()
+
+	userSessionCounts[username]--
+	if userSessionCounts[username] <= 0 {
+		delete(userSessionCounts, username)
+	}
+	totalSessionCount--
+}
+
+// sessionDeadline returns when the user's session must end because of
+// maxsessionminutes, or the zero time if there is no limit
+func sessionDeadline(config *Config, authSession *authSession) time.Time {
+	if config.MaxSessionMinutes <= 0 {
+		return time.Time{}
+	}
+	return authSession.loginTime.Add(time.Duration(config.MaxSessionMinutes) * time.Minute)
+}
+
+// sessionExpired reports whether the user has reached maxsessionminutes
+func sessionExpired(config *Config, authSession *authSession) bool {
+	deadline := sessionDeadline(config, authSession)
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// errSessionIdle is returned by screens that redraw themselves, like the
+// clock, when the user hasn't pressed a key for idletimeout seconds
+var errSessionIdle = errors.New("session idle")
+
+// screenDeadline caps the read deadline of a screen that redraws itself at
+// the idle timeout, counted from the last key press, and the session limit.
+// A zero deadline means the screen doesn't redraw on its own.
+func screenDeadline(config *Config, authSession *authSession, deadline, lastInput time.Time) time.Time {
+	if config.IdleTimeout > 0 {
+		idle := lastInput.Add(time.Duration(config.IdleTimeout) * time.Second)
+		if deadline.IsZero() || idle.Before(deadline) {
+			deadline = idle
+		}
+	}
+	if limit := sessionDeadline(config, authSession); !limit.IsZero() && (deadline.IsZero() || limit.Before(deadline)) {
+		deadline = limit
+	}
+	return deadline
+}
+
+// idleExpired reports whether no key was pressed for idletimeout seconds
+func idleExpired(config *Config, lastInput time.Time) bool {
+	return config.IdleTimeout > 0 && !time.Now().Before(lastInput.Add(time.Duration(config.IdleTimeout)*time.Second))
+}
+
+// activeSession describes a logged in user for the admin console
+type activeSession struct {
+	id         int
+	username   string
+	remoteAddr string
+	started    time.Time
+	host       string             // Host currently connected to, empty while in the menu
+	conn       net.Conn           // The user's terminal
+	cancel     context.CancelFunc // Cancels the session's context
+}
+
+// SessionRegistry keeps track of the logged in users, for the admin console
+// and anything else that needs to find or end a session. It is safe for
+// concurrent use.
+type SessionRegistry struct {
+	lock     sync.Mutex
+	sessions map[int]*activeSession
+	nextID   int
+}
+
+// newSessionRegistry returns an empty registry; session ids start at 1
+func newSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{sessions: make(map[int]*activeSession), nextID: 1}
+}
+
+// The sessions of this proxy
+var sessionRegistry = newSessionRegistry()
+
+// Register adds a logged in user and returns the session id. cancel
+// cancels the context the session runs under.
+func (r *SessionRegistry) Register(authSession *authSession, conn net.Conn, cancel context.CancelFunc) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	id := r.nextID
+	r.nextID++
+	r.sessions[id] = &activeSession{
+		id:         id,
+		username:   authSession.username,
+		remoteAddr: authSession.remoteAddr,
+		started:    authSession.loginTime,
+		conn:       conn,
+		cancel:     cancel,
+	}
+	return id
+}
+
+// Deregister removes a session when its connection handler returns
+func (r *SessionRegistry) Deregister(id int) {
+	r.lock.Lock()
+	delete(r.sessions, id)
+	r.lock.Unlock()
+}
+
+// SetHost records which host a session is connected to
+func (r *SessionRegistry) SetHost(id int, host string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if session, ok := r.sessions[id]; ok {
+		session.host = host
+	}
+}
+
+// List returns a snapshot of all active sessions, oldest first
+func (r *SessionRegistry) List() []activeSession {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	sessions := make([]activeSession, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		sessions = append(sessions, *session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].id < sessions[j].id
+	})
+	return sessions
+}
+
+// Lookup returns the session with the given id
+func (r *SessionRegistry) Lookup(id int) (activeSession, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	session, ok := r.sessions[id]
+	if !ok {
+		return activeSession{}, false
+	}
+	return *session, true
+}
+
+// LookupUser returns the sessions of a user, oldest first
+func (r *SessionRegistry) LookupUser(username string) []activeSession {
+	var sessions []activeSession
+	for _, session := range r.List() {
+		if session.username == username {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+// Terminate ends a session, reporting whether it was found. Cancelling its
+// context stops a proxied host session, and closing the terminal unblocks
+// any screen waiting for input; the handler then cleans up and deregisters
+// the session.
+func (r *SessionRegistry) Terminate(id int) bool {
+	r.lock.Lock()
+	session, ok := r.sessions[id]
+	r.lock.Unlock()
+
+	if !ok {
+		return false
+	}
+	session.cancel()
+	session.conn.Close()
+	return true
+}
+
+// TerminateUser ends all sessions of a user and returns how many there were
+func (r *SessionRegistry) TerminateUser(username string) int {
+	count := 0
+	for _, session := range r.LookupUser(username) {
+		if r.Terminate(session.id) {
+			count++
+		}
+	}
+	return count
+}
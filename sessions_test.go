@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/racingmars/go3270"
+)
+
+// testSession registers a session for username on one end of a pipe and
+// returns its id, the terminal's end, and the session's context
+func testSession(t *testing.T, r *SessionRegistry, username string) (int, net.Conn, context.Context) {
+	t.Helper()
+	proxySide, terminalSide := net.Pipe()
+	t.Cleanup(func() { proxySide.Close(); terminalSide.Close() })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	id := r.Register(&authSession{username: username, remoteAddr: "192.0.2.1:1234", loginTime: time.Now()}, proxySide, cancel)
+	return id, terminalSide, ctx
+}
+
+func TestSessionRegistryConcurrent(t *testing.T) {
+	r := newSessionRegistry()
+	var wg sync.WaitGroup
+	ids := make(chan int, 400)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			username := fmt.Sprintf("user%d", i%3)
+			for j := 0; j < 50; j++ {
+				id := r.Register(&authSession{username: username}, nil, func() {})
+				ids <- id
+				if session, ok := r.Lookup(id); !ok || session.username != username {
+					t.Errorf("Lookup(%d) = %+v, %v", id, session, ok)
+				}
+				r.SetHost(id, "MVS1")
+				r.LookupUser(username)
+				r.List()
+				r.Deregister(id)
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("session id %d handed out twice", id)
+		}
+		seen[id] = true
+	}
+	if sessions := r.List(); len(sessions) != 0 {
+		t.Errorf("%d sessions left after deregistering all of them", len(sessions))
+	}
+}
+
+func TestSessionRegistryLookup(t *testing.T) {
+	r := newSessionRegistry()
+	first, _, firstCtx := testSession(t, r, "jdoe")
+	other, _, otherCtx := testSession(t, r, "alice")
+	second, terminal, secondCtx := testSession(t, r, "jdoe")
+
+	if first != 1 || other != 2 || second != 3 {
+		t.Fatalf("session ids %d, %d, %d, want 1, 2, 3", first, other, second)
+	}
+	r.SetHost(second, "MVS1")
+	if session, ok := r.Lookup(second); !ok || session.username != "jdoe" || session.host != "MVS1" {
+		t.Errorf("Lookup(%d) = %+v, %v", second, session, ok)
+	}
+	if _, ok := r.Lookup(42); ok {
+		t.Errorf("Lookup found a session that was never registered")
+	}
+
+	sessions := r.LookupUser("jdoe")
+	if len(sessions) != 2 || sessions[0].id != first || sessions[1].id != second {
+		t.Errorf("LookupUser(jdoe) = %+v, want sessions %d and %d", sessions, first, second)
+	}
+	if sessions := r.LookupUser("JDOE"); len(sessions) != 0 {
+		t.Errorf("LookupUser(JDOE) = %+v, userids are stored canonical", sessions)
+	}
+
+	if n := r.TerminateUser("jdoe"); n != 2 {
+		t.Errorf("TerminateUser(jdoe) = %d, want 2", n)
+	}
+	if firstCtx.Err() == nil || secondCtx.Err() == nil {
+		t.Errorf("TerminateUser didn't cancel the sessions")
+	}
+	if otherCtx.Err() != nil {
+		t.Errorf("TerminateUser(jdoe) cancelled alice's session")
+	}
+	if _, err := terminal.Write([]byte{0x7d}); err == nil {
+		t.Errorf("the terminal connection is still open after TerminateUser")
+	}
+
+	// The handlers deregister terminated sessions themselves
+	if len(r.List()) != 3 {
+		t.Errorf("List has %d sessions before the handlers deregistered", len(r.List()))
+	}
+	r.Deregister(first)
+	r.Deregister(second)
+	if r.Terminate(first) {
+		t.Errorf("Terminate found a deregistered session")
+	}
+	if sessions := r.List(); len(sessions) != 1 || sessions[0].id != other {
+		t.Errorf("List = %+v, want only session %d", sessions, other)
+	}
+}
+
+func TestTerminateUnblocksSession(t *testing.T) {
+	const prompt = 2 * time.Second
+	r := newSessionRegistry()
+
+	t.Run("menu", func(t *testing.T) {
+		id, terminal, _ := testSession(t, r, "jdoe")
+		session, _ := r.Lookup(id)
+		go io.Copy(io.Discard, terminal)
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := go3270.HandleScreen(go3270.Screen{{Row: 0, Col: 0, Content: "MENU"}}, nil, nil,
+				[]go3270.AID{go3270.AIDEnter}, nil, "", 0, 0, session.conn)
+			done <- err
+		}()
+
+		time.Sleep(50 * time.Millisecond) // Let the screen wait for input
+		r.Terminate(id)
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("the menu read succeeded after Terminate")
+			}
+		case <-time.After(prompt):
+			t.Fatalf("the menu read is still blocked %v after Terminate", prompt)
+		}
+	})
+
+	t.Run("host session", func(t *testing.T) {
+		host, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer host.Close()
+		go func() {
+			conn, err := host.Accept()
+			if err == nil {
+				defer conn.Close()
+				conn.Write([]byte("screen"))
+				io.Copy(io.Discard, conn)
+			}
+		}()
+		port := host.Addr().(*net.TCPAddr).Port
+
+		id, terminal, ctx := testSession(t, r, "jdoe")
+		session, _ := r.Lookup(id)
+		go io.Copy(io.Discard, terminal)
+
+		connected := make(chan struct{})
+		done := make(chan sessionResult, 1)
+		go func() {
+			result, err := connectToHost(ctx, session.conn, &Config{}, Host{Name: "TEST", Host: "127.0.0.1", Port: port, Negotiation: "raw"},
+				nil, time.Time{}, func() { close(connected) })
+			if err != nil {
+				t.Errorf("connectToHost: %v", err)
+			}
+			done <- result
+		}()
+
+		select {
+		case <-connected:
+		case <-time.After(prompt):
+			t.Fatalf("no connection to the fake host")
+		}
+		time.Sleep(50 * time.Millisecond) // Let the copy loops run
+		r.Terminate(id)
+		select {
+		case result := <-done:
+			if result.reason != endTerminated {
+				t.Errorf("session ended with %v, want endTerminated", result.reason)
+			}
+		case <-time.After(prompt):
+			t.Fatalf("the copy loops are still running %v after Terminate", prompt)
+		}
+	})
+}
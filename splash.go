@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/racingmars/go3270"
+)
+
+// Rows available for splash art, leaving room for the product name and hint
+const splashMaxLines = 16
+
+var (
+	splashArt  []string // Custom art from splashfile, nil for the IBM logo
+	splashLock sync.RWMutex
+)
+
+// loadSplashFile reads the ASCII art shown on the splash screen. An empty
+// filename, or a file with only blank lines, brings back the IBM logo.
+func loadSplashFile(filename string) error {
+	var lines []string
+
+	if filename != "" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return fmt.Errorf("failed to open splash file %s: %v", filename, err)
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("error reading splash file %s: %v", filename, err)
+		}
+
+		lines = trimBlankLines(lines)
+		if len(lines) > splashMaxLines {
+			lines = lines[:splashMaxLines]
+		}
+	}
+
+	splashLock.Lock()
+	splashArt = lines
+	splashLock.Unlock()
+
+	return nil
+}
+
+// ShowSplash displays the splash screen on a new connection for
+// splashseconds, or until the user presses a key. A client that doesn't take
+// the screen is dropped after the negotiation timeout.
+func ShowSplash(conn net.Conn, config *Config) error {
+	if config.SplashSeconds <= 0 {
+		return nil
+	}
+
+	splashLock.RLock()
+	art := splashArt
+	splashLock.RUnlock()
+	if len(art) == 0 {
+		art = ibmLogo
+	}
+
+	// Center the art as one block so its lines stay aligned
+	width := 0
+	for _, line := range art {
+		width = max(width, len(line))
+	}
+	artCol := max((79-width)/2, 0)
+	startRow := (20 - len(art)) / 2
+
+	th := config.Theme
+	screen := go3270.Screen{}
+	for i, line := range art {
+		screen = append(screen, go3270.Field{
+			Row:     startRow + i,
+			Col:     artCol,
+			Content: truncateText(line, 79-artCol),
+			Color:   go3270.Blue,
+			Intense: true,
+		})
+	}
+
+	name := "Secure3270Proxy " + version
+	screen = append(screen,
+		go3270.Field{Row: startRow + len(art) + 2, Col: getCenteredPosition(name, 79), Content: name, Color: th.title(go3270.White), Intense: true},
+		go3270.Field{Row: 23, Col: 1, Content: "Press any key to continue", Color: go3270.White},
+	)
+
+	// A client that doesn't take the screen within the negotiation timeout
+	// is stuck and gets dropped
+	conn.SetWriteDeadline(time.Now().Add(secondsOrDefault(config.NegotiateTimeout, 30*time.Second)))
+	defer conn.SetDeadline(time.Time{})
+	if _, err := go3270.ShowScreenOpts(screen, nil, conn, go3270.ScreenOpts{NoResponse: true, CursorRow: 23, CursorCol: 1}); err != nil {
+		return fmt.Errorf("error showing splash screen: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Duration(config.SplashSeconds) * time.Second))
+	if err := skipRecord(conn); err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			// Nobody pressed a key, on to the logon screen
+			return nil
+		}
+		return fmt.Errorf("error showing splash screen: %v", err)
+	}
+	return nil
+}
+
+// skipRecord reads and discards one inbound 3270 record, i.e. a key press
+func skipRecord(conn net.Conn) error {
+	buf := make([]byte, 1)
+	iac := false
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			return err
+		}
+		if iac && buf[0] == telnetEOR {
+			return nil
+		}
+		iac = buf[0] == telnetIAC && !iac
+	}
+}
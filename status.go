@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/racingmars/go3270"
+)
+
+// ShowStatus displays a read-only screen describing the proxy and the
+// user's connection to it. It returns when the user presses Enter or F3.
+func ShowStatus(conn net.Conn, config *Config, authSession *authSession) error {
+	connection := "Plain telnet (no TLS)"
+	cipher := ""
+	if state := authSession.tlsState; state != nil {
+		connection = "TLS " + tlsVersionToString(state.Version)
+		cipher = tls.CipherSuiteName(state.CipherSuite)
+	}
+
+	rows := [][2]string{
+		{"Proxy version", version},
+		{"User", authSession.username},
+		{"Connected from", authSession.remoteAddr},
+		{"Connection", connection},
+		{"Cipher suite", cipher},
+		{"Terminal", authSession.terminal.String()},
+		{"Hosts available", fmt.Sprintf("%d", len(config.Hosts))},
+		{"Code pages", hostCodePages(config.Hosts)},
+		{"Logged in for", time.Since(authSession.loginTime).Round(time.Second).String()},
+		{"Proxy uptime", time.Since(startTime).Round(time.Second).String()},
+	}
+
+	title := "Secure3270Proxy - Status"
+	screen := go3270.Screen{
+		{Row: 0, Col: getCenteredPosition(title, 79), Content: title, Color: config.Theme.title(go3270.White), Intense: true},
+	}
+
+	row := 3
+	for _, r := range rows {
+		if r[1] == "" {
+			continue
+		}
+		screen = append(screen,
+			go3270.Field{Row: row, Col: 3, Content: fmt.Sprintf("%-16s", r[0]), Color: config.Theme.label(go3270.Turquoise)},
+			go3270.Field{Row: row, Col: 21, Content: truncateText(r[1], 58), Color: go3270.Green},
+		)
+		row += 2
+	}
+
+	screen = append(screen, go3270.Field{
+		Row:     23,
+		Col:     1,
+		Content: "Press Enter or F3 to return",
+		Color:   go3270.White,
+	})
+
+	if config.IdleTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(time.Duration(config.IdleTimeout) * time.Second))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+
+	_, err := go3270.HandleScreen(
+		screen,
+		nil,
+		nil,
+		[]go3270.AID{go3270.AIDEnter},
+		[]go3270.AID{go3270.AIDPF3},
+		"",
+		23, 1,
+		conn,
+	)
+	return err
+}
+
+// hostCodePages lists the code page of each host that has one, e.g.
+// "MVS1 037, VM1 1047", so users know how to set up their emulator
+func hostCodePages(hosts []Host) string {
+	var pages []string
+	for _, host := range hosts {
+		if host.CodePage != "" {
+			pages = append(pages, host.Name+" "+host.CodePage)
+		}
+	}
+	return strings.Join(pages, ", ")
+}
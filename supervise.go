@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Process exit codes, for systemd, runit and scripts
+const (
+	exitOK            = 0 // Stopped with SIGINT or SIGTERM
+	exitConfigError   = 1 // Configuration could not be loaded (log.Fatalf)
+	exitListenerError = 2 // A listener could not be opened, even after retries
+)
+
+// A listener that fails to open this many times in a row is given up on and
+// the proxy exits, instead of retrying a port that will never free up
+const maxListenFailures = 5
+
+// listenError marks a server error that happened while opening its
+// listener, as opposed to one on a listener that was working
+type listenError struct {
+	err error
+}
+
+func (e *listenError) Error() string {
+	return e.err.Error()
+}
+
+// Unrecoverable server failures, reported to main
+var fatalErrors = make(chan error, 1)
+
+// Restart timing unless configured otherwise
+const (
+	defaultRestartDelay      = 30 * time.Second // After a server failed quickly
+	defaultRestartAfterStop  = 10 * time.Second // After a server stopped without an error
+	defaultRestartStableTime = 5 * time.Minute  // Uptime after which a failure restarts at once
+)
+
+// restartDelay adds up to jitter percent to a restart delay, so proxies
+// that lost the network together don't all come back at the same moment
+func restartDelay(delay time.Duration, jitter int) time.Duration {
+	if jitter <= 0 || delay <= 0 {
+		return delay
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)*int64(jitter)/100+1))
+}
+
+// superviseServer runs a server and restarts it whenever it stops: at once
+// if it ran for a while, after a pause if it failed quickly. When the
+// listener can't be opened maxListenFailures times in a row, the failure is
+// reported to main and the server is not restarted again.
+func superviseServer(name string, run func() error) {
+	listenFailures := 0
+	for {
+		startTime := time.Now()
+		err := run()
+
+		var le *listenError
+		if errors.As(err, &le) {
+			listenFailures++
+			if listenFailures >= maxListenFailures {
+				select {
+				case fatalErrors <- fmt.Errorf("%s gave up after %d attempts: %v", name, listenFailures, err):
+				default:
+				}
+				return
+			}
+		} else {
+			listenFailures = 0
+		}
+
+		config := getConfig()
+		if err != nil {
+			log.Printf("%s error: %v", name, err)
+
+			// If the server ran for a reasonable amount of time before failing,
+			// it's likely a temporary issue, so we can restart immediately
+			if time.Since(startTime) > secondsOrDefault(config.RestartStableTime, defaultRestartStableTime) {
+				log.Printf("%s restarting immediately...", name)
+			} else {
+				// If it failed quickly, there might be a more serious issue
+				// Wait before retrying to avoid rapid restart loops
+				delay := restartDelay(secondsOrDefault(config.RestartDelay, defaultRestartDelay), config.RestartJitter)
+				log.Printf("%s will restart in %v...", name, delay.Round(100*time.Millisecond))
+				time.Sleep(delay)
+			}
+		} else {
+			// Normal shutdown - wait before restarting
+			delay := restartDelay(secondsOrDefault(config.RestartAfterStop, defaultRestartAfterStop), config.RestartJitter)
+			log.Printf("%s shut down, restarting in %v...", name, delay.Round(100*time.Millisecond))
+			time.Sleep(delay)
+		}
+	}
+}
+
+// waitForShutdown blocks until the proxy is told to stop or a server fails
+// for good, cleans up and returns the exit code
+func waitForShutdown() int {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	code := exitOK
+	select {
+	case sig := <-signals:
+		log.Printf("%s received, shutting down", sig)
+	case err := <-fatalErrors:
+		log.Printf("FATAL: %v", err)
+		code = exitListenerError
+	}
+
+	removeUnixSocket()
+	return code
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// Telnet bytes used when negotiating with the terminal (RFC 854, 856, 885,
+// 1091 and 2355)
+const (
+	telnetIAC    = 255
+	telnetDont   = 254
+	telnetDo     = 253
+	telnetWont   = 252
+	telnetWill   = 251
+	telnetSB     = 250
+	telnetSE     = 240
+	telnetNOP    = 241
+	telnetEOR    = 239
+	optBinary    = 0
+	optTermType  = 24
+	optEOR       = 25
+	optTN3270E   = 40
+	termTypeIs   = 0
+	termTypeSend = 1
+)
+
+// terminal describes the 3270 device the client said it is during telnet
+// negotiation. Rows and Cols are the model's alternate screen size; the
+// default size of every 3270 model is 24x80.
+type terminal struct {
+	Type string // e.g. IBM-3278-2-E, empty if the client didn't say
+	Rows int
+	Cols int
+}
+
+// modelSizes maps the 3278/3279 model number to its alternate screen size
+var modelSizes = map[string][2]int{
+	"2": {24, 80},
+	"3": {32, 80},
+	"4": {43, 80},
+	"5": {27, 132},
+}
+
+// terminalFor works out the screen size from a telnet terminal type such as
+// IBM-3279-5-E. Unknown types are assumed to be 24x80.
+func terminalFor(termType string) terminal {
+	term := terminal{Type: termType, Rows: 24, Cols: 80}
+	parts := strings.Split(strings.ToUpper(termType), "-")
+	if len(parts) >= 3 && parts[0] == "IBM" && (parts[1] == "3278" || parts[1] == "3279") {
+		if size, ok := modelSizes[parts[2]]; ok {
+			term.Rows, term.Cols = size[0], size[1]
+		}
+	}
+	return term
+}
+
+// Screens are laid out for the 24x80 default screen every 3270 model has.
+// go3270 writes them with Erase/Write and 24x80 buffer addresses, so larger
+// models show that screen too.
+const (
+	screenRows    = 24
+	screenColumns = 80
+)
+
+// standardSize reports whether the terminal is a plain 24x80 model 2
+func (t terminal) standardSize() bool {
+	return t.Rows == 24 && t.Cols == 80
+}
+
+func (t terminal) String() string {
+	name := t.Type
+	if name == "" {
+		name = "unknown type"
+	}
+	return fmt.Sprintf("%s (%dx%d)", name, t.Rows, t.Cols)
+}
+
+// negotiateTelnet sends the same tn3270 negotiation as go3270.NegotiateTelnet,
+// but reads the replies instead of discarding them so the terminal type the
+// client reports can be logged. Clients that stay silent for firstByte or
+// answer with something other than a telnet command get errNotTN3270.
+func negotiateTelnet(conn net.Conn, firstByte time.Duration) (terminal, error) {
+	conn.Write([]byte{telnetIAC, telnetDo, optTermType})
+	conn.Write([]byte{telnetIAC, telnetSB, optTermType, termTypeSend, telnetIAC, telnetSE})
+	conn.Write([]byte{telnetIAC, telnetDo, optEOR})
+	conn.Write([]byte{telnetIAC, telnetDo, optBinary})
+	conn.Write([]byte{telnetIAC, telnetWill, optEOR, telnetIAC, telnetWill, optBinary})
+
+	replies, err := readNegotiation(conn, firstByte)
+	term := terminalFor(parseTermType(replies))
+	switch {
+	case len(replies) > 0 && replies[0] != telnetIAC:
+		return term, fmt.Errorf("%w: sent %q", errNotTN3270, truncateText(strings.ToValidUTF8(string(replies), "?"), 20))
+	case len(replies) == 0 && err != nil:
+		return term, fmt.Errorf("%w: closed during negotiation: %v", errNotTN3270, err)
+	case err != nil:
+		return term, err
+	case len(replies) == 0:
+		return term, fmt.Errorf("%w: no reply within %v", errNotTN3270, firstByte)
+	}
+
+	if term.Type == "" {
+		log.Printf("Client %s did not report a terminal type, assuming 24x80", conn.RemoteAddr())
+	} else if !term.standardSize() {
+		log.Printf("Warning: client %s negotiated %s, screens are laid out for %dx%d", conn.RemoteAddr(), term, screenRows, screenColumns)
+	} else {
+		log.Printf("Client %s negotiated terminal type %s", conn.RemoteAddr(), term.Type)
+	}
+	return term, nil
+}
+
+// readNegotiation collects what the client sends in reply to the
+// negotiation, waiting up to timeout for the first byte like go3270 does
+func readNegotiation(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	defer conn.SetReadDeadline(time.Time{})
+	var replies []byte
+	buffer := make([]byte, 1024)
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, err := conn.Read(buffer)
+		replies = append(replies, buffer[:n]...)
+		if len(replies) > 0 && replies[0] != telnetIAC {
+			// Not a telnet client, no point waiting for more
+			return replies, nil
+		}
+		if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+			return replies, nil
+		}
+		if err != nil {
+			return replies, err
+		}
+		// Follow-up replies arrive quickly
+		timeout = time.Second / 2
+	}
+}
+
+// parseTermType finds IAC SB TERMINAL-TYPE IS <name> IAC SE in the replies
+func parseTermType(replies []byte) string {
+	start := bytes.Index(replies, []byte{telnetIAC, telnetSB, optTermType, termTypeIs})
+	if start < 0 {
+		return ""
+	}
+	rest := replies[start+4:]
+	end := bytes.Index(rest, []byte{telnetIAC, telnetSE})
+	if end < 0 {
+		return ""
+	}
+	return strings.TrimSpace(string(rest[:end]))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/racingmars/go3270"
+)
+
+// theme overrides the colors used on the proxy's screens. A nil entry keeps
+// each screen's built-in color, so an empty theme looks like it always has.
+type theme struct {
+	Title *go3270.Color // Screen titles and banners
+	Label *go3270.Color // Field labels and prompts
+	Input *go3270.Color // Fields the user types into
+	Error *go3270.Color // Error and warning messages
+}
+
+func (t theme) title(def go3270.Color) go3270.Color { return themeColor(t.Title, def) }
+func (t theme) label(def go3270.Color) go3270.Color { return themeColor(t.Label, def) }
+func (t theme) input(def go3270.Color) go3270.Color { return themeColor(t.Input, def) }
+func (t theme) error(def go3270.Color) go3270.Color { return themeColor(t.Error, def) }
+
+func themeColor(color *go3270.Color, def go3270.Color) go3270.Color {
+	if color != nil {
+		return *color
+	}
+	return def
+}
+
+// parseColor maps a color name to a 3270 color. "default" leaves the choice
+// to the terminal, which suits monochrome displays.
+func parseColor(name string) (*go3270.Color, error) {
+	var color go3270.Color
+	switch strings.ToLower(name) {
+	case "default":
+		color = go3270.DefaultColor
+	case "blue":
+		color = go3270.Blue
+	case "red":
+		color = go3270.Red
+	case "pink":
+		color = go3270.Pink
+	case "green":
+		color = go3270.Green
+	case "turquoise":
+		color = go3270.Turquoise
+	case "yellow":
+		color = go3270.Yellow
+	case "white":
+		color = go3270.White
+	default:
+		return nil, fmt.Errorf("unknown color %q", name)
+	}
+	return &color, nil
+}
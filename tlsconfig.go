@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// defaultCipherSuites is used when tlsciphers isn't set. It keeps the ECDHE
+// CBC suites so TLS 1.0/1.1 clients can still connect, but drops static RSA
+// key exchange and 3DES.
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+}
+
+// modernCipherSuites is used with tlsmodern=true: forward secret AEAD suites only
+var modernCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// parseCipherSuites maps a comma separated list of cipher suite names (as
+// used by crypto/tls, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) to their IDs.
+// TLS 1.3 suites are always enabled by Go and can't be chosen here.
+func parseCipherSuites(value string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	var suites []uint16
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+
+	if len(suites) == 0 {
+		return nil, fmt.Errorf("no cipher suites listed")
+	}
+	return suites, nil
+}
+
+// cipherSuites returns the cipher suites the TLS listener should offer
+func cipherSuites(config *Config) []uint16 {
+	if len(config.TLSCiphers) > 0 {
+		return config.TLSCiphers
+	}
+	if config.TLSModern {
+		return modernCipherSuites
+	}
+	return defaultCipherSuites
+}
+
+// cipherSuiteNames returns a comma separated list of suite names for logging
+func cipherSuiteNames(suites []uint16) string {
+	names := make([]string, len(suites))
+	for i, id := range suites {
+		names[i] = tls.CipherSuiteName(id)
+	}
+	return strings.Join(names, ", ")
+}
+
+// isAEADSuite reports whether a TLS 1.2 cipher suite uses GCM or ChaCha20-Poly1305
+func isAEADSuite(id uint16) bool {
+	name := tls.CipherSuiteName(id)
+	return strings.Contains(name, "_GCM_") || strings.Contains(name, "CHACHA20_POLY1305")
+}
+
+// clientAuthType maps the tlsclientauth setting to a crypto/tls mode. Requested
+// certificates are still verified when a client presents one, so the CN used
+// for the login screen can always be trusted.
+func clientAuthType(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("invalid tlsclientauth %q (use none, request or require)", mode)
+	}
+}
+
+// errSSLv3 rejects an explicit request for SSL 3.0, which crypto/tls no
+// longer implements and which is broken anyway (POODLE)
+var errSSLv3 = errors.New("SSLv3 is insecure and not supported, use TLS1.2 or later")
+
+// parseTLSVersion maps a tlsminversion/tlsmaxversion value to the crypto/tls
+// constant
+func parseTLSVersion(name string) (uint16, error) {
+	switch strings.ToLower(name) {
+	case "tls1.0", "tls1", "tlsv1.0", "tlsv1":
+		return tls.VersionTLS10, nil
+	case "tls1.1", "tlsv1.1":
+		return tls.VersionTLS11, nil
+	case "tls1.2", "tlsv1.2":
+		return tls.VersionTLS12, nil
+	case "tls1.3", "tlsv1.3":
+		return tls.VersionTLS13, nil
+	case "ssl3", "sslv3", "ssl3.0", "sslv3.0":
+		return 0, errSSLv3
+	}
+	return 0, fmt.Errorf("unrecognized TLS version %q", name)
+}
+
+// tlsVersionRange works out the TLS versions the listener accepts, TLS 1.0
+// to 1.3 unless configured otherwise. An inverted range is an error rather
+// than a listener that can't complete a single handshake.
+func tlsVersionRange(config *Config) (uint16, uint16, error) {
+	var minVersion uint16 = tls.VersionTLS10
+	var maxVersion uint16 = tls.VersionTLS13
+	if config.TLSMinVersion != "" {
+		if v, err := parseTLSVersion(config.TLSMinVersion); err == nil {
+			minVersion = v
+		}
+	}
+	if config.TLSMaxVersion != "" {
+		if v, err := parseTLSVersion(config.TLSMaxVersion); err == nil {
+			maxVersion = v
+		}
+	}
+
+	if maxVersion < minVersion {
+		return 0, 0, fmt.Errorf("tlsmaxversion %s is lower than tlsminversion %s",
+			tlsVersionToString(maxVersion), tlsVersionToString(minVersion))
+	}
+
+	// Modern mode never allows anything older than TLS 1.2
+	if config.TLSModern && minVersion < tls.VersionTLS12 {
+		minVersion = tls.VersionTLS12
+		if maxVersion < minVersion {
+			maxVersion = minVersion
+		}
+	}
+	return minVersion, maxVersion, nil
+}
+
+// tlsListener is an extra TLS listener defined by tlslistener.<name>.<setting>
+// keys, e.g. a legacy port for old emulators next to a strict modern one.
+// Its settings override the main TLS settings; everything else, including
+// logon and the host menu, is shared with the main listener.
+type tlsListener struct {
+	Name     string
+	Settings map[string]string // Main config key -> value, e.g. "tlsport" -> "12003"
+}
+
+// tlsListenerSettings maps the settings of a listener profile to the main
+// config keys they override
+var tlsListenerSettings = map[string]string{
+	"port":        "tlsport",
+	"bindaddress": "tlsbindaddress",
+	"cert":        "tlscert",
+	"key":         "tlskey",
+	"snicerts":    "tlssnicerts",
+	"minversion":  "tlsminversion",
+	"maxversion":  "tlsmaxversion",
+	"ciphers":     "tlsciphers",
+	"modern":      "tlsmodern",
+	"clientauth":  "tlsclientauth",
+}
+
+// setTLSListenerValue stores one tlslistener.<name>.<setting> key. Listeners
+// keep the order in which they first appear in the config.
+func setTLSListenerValue(config *Config, key, value string) error {
+	name, setting, ok := strings.Cut(strings.TrimPrefix(key, "tlslistener."), ".")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid config key %s (use tlslistener.<name>.<setting>)", key)
+	}
+	mainKey, ok := tlsListenerSettings[setting]
+	if !ok {
+		return fmt.Errorf("invalid config key %s (use port, bindaddress, cert, key, snicerts, minversion, maxversion, ciphers, modern or clientauth)", key)
+	}
+
+	// Check the value right away so a typo is reported against this key
+	if err := applyConfigValue(&Config{}, mainKey, value); err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+
+	for _, listener := range config.TLSListeners {
+		if listener.Name == name {
+			listener.Settings[mainKey] = value
+			return nil
+		}
+	}
+	config.TLSListeners = append(config.TLSListeners, &tlsListener{
+		Name:     name,
+		Settings: map[string]string{mainKey: value},
+	})
+	return nil
+}
+
+// config returns the main configuration with the listener's settings applied
+func (l *tlsListener) config(main *Config) *Config {
+	listenerConfig := *main
+	listenerConfig.TLSPort = 0
+	listenerConfig.TLSListeners = nil
+	for key, value := range l.Settings {
+		// Already checked by setTLSListenerValue
+		applyConfigValue(&listenerConfig, key, value)
+	}
+	return &listenerConfig
+}
+
+// checkTLSListeners makes sure every listener profile can start: it needs a
+// port of its own and a usable TLS version range
+func checkTLSListeners(config *Config) error {
+	addresses := map[string]string{
+		listenAddress(config.BindAddress, config.Port): "port",
+	}
+	if config.TLSPort > 0 {
+		addresses[listenAddress(config.tlsBindAddress(), config.TLSPort)] = "tlsport"
+	}
+
+	for _, listener := range config.TLSListeners {
+		listenerConfig := listener.config(config)
+		if listenerConfig.TLSPort == 0 {
+			return fmt.Errorf("tlslistener.%s.port is not set", listener.Name)
+		}
+		address := listenAddress(listenerConfig.tlsBindAddress(), listenerConfig.TLSPort)
+		if other, ok := addresses[address]; ok {
+			return fmt.Errorf("tlslistener.%s listens on %s, which is already used by %s", listener.Name, address, other)
+		}
+		addresses[address] = "tlslistener." + listener.Name
+		if _, _, err := tlsVersionRange(listenerConfig); err != nil {
+			return fmt.Errorf("tlslistener.%s: %v", listener.Name, err)
+		}
+	}
+	return nil
+}
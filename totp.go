@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/racingmars/go3270"
+)
+
+// Field name for the TOTP code on the second factor screen
+const fieldTOTPCode = "totpCode"
+
+// RFC 6238 parameters used by common authenticator apps
+const (
+	totpStep   = 30 * time.Second
+	totpDigits = 6
+)
+
+var (
+	totpLastCounter = make(map[string]uint64) // username -> time step of the last accepted code
+	totpLock        sync.Mutex
+)
+
+// decodeTOTPSecret decodes a base32 secret, ignoring case, spaces and padding
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	secret = strings.TrimRight(secret, "=")
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+}
+
+// totpCode computes the HOTP value (RFC 4226) for the given counter
+func totpCode(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// validateTOTP checks a code against the secret, allowing one time step
+// of clock drift in either direction. It returns the time step the code
+// belongs to.
+func validateTOTP(secret, code string, now time.Time) (uint64, bool) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	counter := uint64(now.Unix() / int64(totpStep/time.Second))
+	for _, c := range []uint64{counter - 1, counter, counter + 1} {
+		if hmac.Equal([]byte(totpCode(key, c)), []byte(code)) {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// acceptTOTP checks a user's code like validateTOTP, and refuses codes that
+// were seen before. A code stays valid for about 90 seconds, so an observed
+// one could otherwise be replayed; only time steps after the last accepted
+// one are allowed.
+func acceptTOTP(username, secret, code string, now time.Time) bool {
+	counter, ok := validateTOTP(secret, code, now)
+	if !ok {
+		return false
+	}
+
+	totpLock.Lock()
+	defer totpLock.Unlock()
+
+	if last, seen := totpLastCounter[username]; seen && counter <= last {
+		log.Printf("SECURITY: TOTP code for user %s was already used", username)
+		return false
+	}
+	totpLastCounter[username] = counter
+	return true
+}
+
+// generateTOTPSecret returns a new random base32 secret
+func generateTOTPSecret() (string, error) {
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key), nil
+}
+
+// printTOTPSecret generates a secret for the user and prints it along with
+// the otpauth:// URL that authenticator apps can import
+func printTOTPSecret(username string) error {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return err
+	}
+
+	label := url.PathEscape("Secure3270Proxy:" + username)
+	fmt.Printf("Secret: %s\n", secret)
+	fmt.Printf("URL:    otpauth://totp/%s?secret=%s&issuer=Secure3270Proxy&digits=%d&period=%d\n",
+		label, secret, totpDigits, int(totpStep/time.Second))
+	fmt.Printf("Add the secret as the fourth field in users.cnf: %s/password/hostfile/%s\n", username, secret)
+	return nil
+}
+
+// HandleTOTP asks for the 6-digit code of a user with a TOTP secret.
+// It returns nil once a valid code was entered.
+func HandleTOTP(conn net.Conn, config *Config, user User) error {
+	fieldValues := make(map[string]string)
+
+	th := config.Theme
+	screen := go3270.Screen{
+		{Row: 0, Col: 0, Content: strings.Repeat("-", 15) + " SECURE3270PROXY - VERIFICATION " + strings.Repeat("-", 15), Color: th.title(go3270.White)},
+		{Row: 2, Col: 0, Content: "PF3 ==> Logoff", Color: go3270.White},
+
+		{Row: 4, Col: 3, Content: "ENTER THE 6-DIGIT CODE FROM YOUR AUTHENTICATOR APP:", Color: go3270.White},
+
+		{Row: 6, Col: 3, Content: "CODE      ", Color: th.label(go3270.Turquoise)},
+		{Row: 6, Col: 13, Content: "===>", Color: go3270.White},
+		{Row: 6, Col: 19, Name: fieldTOTPCode, Write: true, NumericOnly: true, Color: th.input(go3270.Red)},
+		{Row: 6, Col: 26, Autoskip: true},
+
+		{Row: 19, Col: 3, Name: fieldErrorMsg, Color: th.error(go3270.Red), Intense: true},
+	}
+
+	rules := go3270.Rules{
+		fieldTOTPCode: {Validator: go3270.NonBlank, Reset: true},
+	}
+
+	authTimeout := secondsOrDefault(config.AuthTimeout, 300*time.Second)
+	for {
+		// The user isn't logged on yet, so the screen gets authtimeout
+		// like the logon screen
+		conn.SetReadDeadline(time.Now().Add(authTimeout))
+		resp, err := go3270.HandleScreen(
+			screen,
+			rules,
+			fieldValues,
+			[]go3270.AID{go3270.AIDEnter},
+			[]go3270.AID{go3270.AIDPF3},
+			fieldErrorMsg,
+			6, 20,
+			conn,
+		)
+		conn.SetReadDeadline(time.Time{})
+
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			log.Printf("TOTP prompt timed out after %v for %s", authTimeout, user.Username)
+			showDisconnectScreen(conn, config, "No code received in time.")
+			return fmt.Errorf("TOTP prompt timed out")
+		}
+		if err != nil {
+			return fmt.Errorf("screen show error: %v", err)
+		}
+
+		if resp.AID == go3270.AIDPF3 {
+			return fmt.Errorf("user %s logged off at TOTP prompt", user.Username)
+		}
+
+		if acceptTOTP(user.Username, user.TOTPSecret, resp.Values[fieldTOTPCode], time.Now()) {
+			return nil
+		}
+
+		log.Printf("SECURITY: invalid TOTP code for user %s", user.Username)
+		auditLog("TOTP", "result=failure user=%q ip=%s", user.Username, conn.RemoteAddr())
+
+		if isLockedOut(user.Username) || recordFailedLogin(user.Username, config) {
+			return fmt.Errorf("user %s locked out after invalid TOTP codes", user.Username)
+		}
+		fieldValues[fieldErrorMsg] = "Invalid code. Please try again."
+	}
+}
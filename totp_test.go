@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA-1 secret of the RFC 6238 test vectors, "12345678901234567890"
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 appendix B lists 8 digit codes; the 6 digit ones used here are
+// their last six digits
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCode(t *testing.T) {
+	key, err := decodeTOTPSecret(rfc6238Secret)
+	if err != nil {
+		t.Fatalf("decodeTOTPSecret: %v", err)
+	}
+	for _, tt := range rfc6238Vectors {
+		if got := totpCode(key, uint64(tt.unix/30)); got != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestDecodeTOTPSecret(t *testing.T) {
+	for _, secret := range []string{
+		rfc6238Secret,
+		"gezdgnbvgy3tqojqgezdgnbvgy3tqojq",
+		"GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ",
+		rfc6238Secret + "====",
+	} {
+		key, err := decodeTOTPSecret(secret)
+		if err != nil || string(key) != "12345678901234567890" {
+			t.Errorf("decodeTOTPSecret(%q) = %q, %v", secret, key, err)
+		}
+	}
+	if _, err := decodeTOTPSecret("not base32!"); err == nil {
+		t.Errorf("decodeTOTPSecret accepted an invalid secret")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		at := time.Unix(tt.unix, 0)
+		step := uint64(tt.unix / 30)
+
+		tests := []struct {
+			name    string
+			secret  string
+			code    string
+			now     time.Time
+			ok      bool
+			counter uint64
+		}{
+			{"current step", rfc6238Secret, tt.code, at, true, step},
+			{"client one step slow", rfc6238Secret, tt.code, at.Add(30 * time.Second), true, step},
+			{"client one step fast", rfc6238Secret, tt.code, at.Add(-30 * time.Second), true, step},
+			{"two steps slow", rfc6238Secret, tt.code, at.Add(60 * time.Second), false, 0},
+			{"two steps fast", rfc6238Secret, tt.code, at.Add(-60 * time.Second), false, 0},
+			{"eight digits", rfc6238Secret, "00" + tt.code, at, false, 0},
+			{"five digits", rfc6238Secret, tt.code[1:], at, false, 0},
+			{"bad secret", "not base32!", tt.code, at, false, 0},
+		}
+		for _, test := range tests {
+			if test.now.Unix() < 0 {
+				continue // Before 1970, no real clock is that far off
+			}
+			counter, ok := validateTOTP(test.secret, test.code, test.now)
+			if ok != test.ok || counter != test.counter {
+				t.Errorf("%d %s: validateTOTP = %d, %v, want %d, %v", tt.unix, test.name, counter, ok, test.counter, test.ok)
+			}
+		}
+	}
+}
+
+func TestAcceptTOTPReplay(t *testing.T) {
+	key, _ := decodeTOTPSecret(rfc6238Secret)
+	now := time.Unix(1111111111, 0)
+	step := uint64(now.Unix() / 30)
+	code := func(counter uint64) string { return totpCode(key, counter) }
+
+	totpLock.Lock()
+	delete(totpLastCounter, "replay")
+	delete(totpLastCounter, "other")
+	totpLock.Unlock()
+
+	tests := []struct {
+		name     string
+		username string
+		code     string
+		ok       bool
+	}{
+		{"first use", "replay", code(step), true},
+		{"same code again", "replay", code(step), false},
+		{"earlier step still in the window", "replay", code(step - 1), false},
+		{"other user with the same code", "other", code(step), true},
+		{"next step", "replay", code(step + 1), true},
+		{"the step before it", "replay", code(step), false},
+		{"invalid code", "replay", "000000", false},
+	}
+	for _, tt := range tests {
+		if ok := acceptTOTP(tt.username, rfc6238Secret, tt.code, now); ok != tt.ok {
+			t.Errorf("%s: acceptTOTP = %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// unixClientConn is a connection accepted on the UNIX socket. Such clients
+// have no address of their own, so the socket path is reported instead.
+type unixClientConn struct {
+	net.Conn
+	path string
+}
+
+// RemoteAddr returns the socket path, so logs show where the client came in
+func (c *unixClientConn) RemoteAddr() net.Addr {
+	return &net.UnixAddr{Name: c.path, Net: "unix"}
+}
+
+// The UNIX socket the proxy has opened, empty if none
+var (
+	unixSocketPath string
+	unixSocketLock sync.Mutex
+)
+
+// isLocalConn reports whether a client came in over the UNIX socket. Access
+// to it is controlled by file permissions, not by client address.
+func isLocalConn(conn net.Conn) bool {
+	return conn.RemoteAddr().Network() == "unix"
+}
+
+// startUnixServer runs the UNIX socket listener with the same auto-recovery
+// loop as the TCP listeners
+func startUnixServer(config *Config, debug, debug3270, trace bool) {
+	superviseServer("UNIX socket server", func() error {
+		return runUnixServer(config, debug, debug3270, trace)
+	})
+}
+
+func runUnixServer(config *Config, debug, debug3270, trace bool) error {
+	path := config.UnixSocket
+	if err := removeStaleSocket(path); err != nil {
+		return &listenError{err}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return &listenError{fmt.Errorf("failed to start UNIX socket listener: %v", err)}
+	}
+	defer listener.Close()
+
+	// Remember the socket so it is removed when the proxy exits
+	unixSocketLock.Lock()
+	unixSocketPath = path
+	unixSocketLock.Unlock()
+	defer func() {
+		unixSocketLock.Lock()
+		unixSocketPath = ""
+		unixSocketLock.Unlock()
+	}()
+
+	log.Printf("Proxy3270 listening on UNIX socket %s", path)
+	listenerStarted()
+	defer listenerStopped()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return fmt.Errorf("UNIX socket accept error: %v", err)
+		}
+
+		// Plain telnet only, TLS doesn't apply to a local socket
+		go handleStandardConnection(&unixClientConn{Conn: conn, path: path}, getConfig(), debug, debug3270, trace)
+	}
+}
+
+// removeStaleSocket deletes a socket file left behind by a proxy that
+// didn't shut down cleanly. A socket that still accepts connections belongs
+// to a running process and is left alone, as is anything that isn't a socket.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to check UNIX socket %s: %v", path, err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("UNIX socket %s is in use by another process", path)
+	}
+
+	log.Printf("Removing stale UNIX socket %s", path)
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove stale UNIX socket %s: %v", path, err)
+	}
+	return nil
+}
+
+// removeUnixSocket deletes the socket file the proxy is listening on, so
+// the next start finds a clean path. Closing the listener would do the same,
+// but exiting doesn't close it.
+func removeUnixSocket() {
+	unixSocketLock.Lock()
+	defer unixSocketLock.Unlock()
+
+	if unixSocketPath != "" {
+		log.Printf("Removing UNIX socket %s", unixSocketPath)
+		os.Remove(unixSocketPath)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/racingmars/go3270"
+)
+
+// Most host files listed on the who am I screen
+const whoAmIMaxFiles = 8
+
+// userHostFiles returns the host files a user can switch between: the one
+// from users.cnf (or the default list) first, then the hostfile= flags
+func userHostFiles(config *Config, authSession *authSession) []string {
+	first := authSession.hostFile
+	if first == "" {
+		first = config.HostFile
+	}
+	files := []string{first}
+	for _, file := range authSession.hostFiles {
+		if file != first {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// ShowWhoAmI tells the user who they are logged on as and which host file
+// the menu shows. Users with more than one host file can switch to another
+// one by number; the chosen file is returned, or "" to keep the current one.
+func ShowWhoAmI(conn net.Conn, config *Config, authSession *authSession) (string, error) {
+	th := config.Theme
+	files := userHostFiles(config, authSession)
+	if len(files) > whoAmIMaxFiles {
+		files = files[:whoAmIMaxFiles]
+	}
+	current := config.HostFile
+
+	roles := []string{}
+	if authSession.admin {
+		roles = append(roles, "admin")
+	}
+	if authSession.readOnly {
+		roles = append(roles, "view-only")
+	}
+
+	rows := [][2]string{
+		{"User", authSession.username},
+		{"Connected from", authSession.remoteAddr},
+		{"Logged on since", authSession.loginTime.Format("2006-01-02 15:04:05")},
+		{"Roles", strings.Join(roles, ", ")},
+		{"Host file", current},
+		{"Hosts", fmt.Sprintf("%d", len(config.Hosts))},
+	}
+
+	message := ""
+	for {
+		title := "Secure3270Proxy - Who Am I"
+		screen := go3270.Screen{
+			{Row: 0, Col: getCenteredPosition(title, 79), Content: title, Color: th.title(go3270.White), Intense: true},
+		}
+
+		row := 2
+		for _, r := range rows {
+			if r[1] == "" {
+				continue
+			}
+			screen = append(screen,
+				go3270.Field{Row: row, Col: 3, Content: fmt.Sprintf("%-16s", r[0]), Color: th.label(go3270.Turquoise)},
+				go3270.Field{Row: row, Col: 21, Content: truncateText(r[1], 58), Color: go3270.Green},
+			)
+			row++
+		}
+
+		// Offer the other host files, if there are any
+		switchable := len(files) > 1
+		if switchable {
+			row++
+			screen = append(screen, go3270.Field{Row: row, Col: 3, Content: "Your host files:", Color: th.label(go3270.Turquoise)})
+			row++
+			for i, file := range files {
+				marker := ""
+				if file == current {
+					marker = "  (active)"
+				}
+				screen = append(screen, go3270.Field{Row: row, Col: 5, Content: truncateText(fmt.Sprintf("%d. %s%s", i+1, file, marker), 74), Color: go3270.Blue})
+				row++
+			}
+		}
+
+		if message != "" {
+			screen = append(screen, go3270.Field{Row: 21, Col: 1, Content: truncateText(message, 78), Color: th.error(go3270.Red), Intense: true})
+		}
+
+		cursorRow, cursorCol := 23, 1
+		if switchable {
+			screen = append(screen,
+				go3270.Field{Row: 22, Col: 1, Content: "F3=Return", Color: go3270.Blue},
+				go3270.Field{Row: 23, Col: 1, Content: "Switch to host file number:", Color: th.label(go3270.White)},
+				go3270.Field{Row: 23, Col: 29, Name: "file", Write: true, NumericOnly: true, Color: th.input(go3270.Green), Highlighting: go3270.Underscore},
+				go3270.Field{Row: 23, Col: 32, Autoskip: true},
+			)
+			cursorCol = 30
+		} else {
+			screen = append(screen, go3270.Field{Row: 23, Col: 1, Content: "Press Enter or F3 to return", Color: go3270.White})
+		}
+
+		if config.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(time.Duration(config.IdleTimeout) * time.Second))
+		}
+		resp, err := go3270.HandleScreen(
+			screen,
+			nil,
+			nil,
+			[]go3270.AID{go3270.AIDEnter},
+			[]go3270.AID{go3270.AIDPF3},
+			"",
+			cursorRow, cursorCol,
+			conn,
+		)
+		conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			return "", err
+		}
+
+		selection := strings.TrimSpace(resp.Values["file"])
+		if resp.AID == go3270.AIDPF3 || !switchable || selection == "" {
+			return "", nil
+		}
+
+		num, err := strconv.Atoi(selection)
+		if err != nil || num < 1 || num > len(files) {
+			message = "Invalid host file number"
+			continue
+		}
+		if files[num-1] == current {
+			return "", nil
+		}
+		return files[num-1], nil
+	}
+}
+
+// switchHostFile makes the menu show another of the user's host files for
+// the rest of the session. The allowed hosts still apply.
+func switchHostFile(config *Config, authSession *authSession, filename string) error {
+	hosts, err := loadHostFiles(config, filename)
+	if err != nil {
+		return err
+	}
+	if len(authSession.allowedHosts) > 0 {
+		hosts = filterHosts(hosts, authSession.allowedHosts)
+	}
+	if len(hosts) == 0 {
+		return fmt.Errorf("no hosts available in %s", filename)
+	}
+
+	log.Printf("User %s switched to host file %s (%d hosts)", authSession.username, filename, len(hosts))
+	auditLog("HOSTFILE_SWITCH", "user=%q ip=%s from=%s to=%s", authSession.username,
+		authSession.remoteAddr, config.HostFile, filename)
+	config.HostFile = filename
+	config.Hosts = hosts
+	return nil
+}
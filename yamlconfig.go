@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// isYAMLFile reports whether a file should be parsed as YAML based on its extension
+func isYAMLFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// parseYAMLConfig loads server settings from a YAML document. Every scalar
+// top level key is handled exactly like the same key in secure3270.cnf;
+// lists are joined with commas. An inline hosts section makes the YAML file
+// its own host file.
+func parseYAMLConfig(filename string, config *Config) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %v", err)
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse YAML config %s: %v", filename, err)
+	}
+
+	for key, raw := range doc {
+		switch strings.ToLower(key) {
+		case "users":
+			// Loaded separately by LoadAuthConfig
+			continue
+		case "hosts":
+			config.HostFile = filename
+			continue
+		}
+
+		if err := applyYAMLValue(config, key, raw); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyYAMLValue applies one decoded YAML value. Nested maps become dotted
+// keys, so a "legacy" map under "tlslistener" sets tlslistener.legacy.port
+// and so on.
+func applyYAMLValue(config *Config, key string, raw interface{}) error {
+	if nested, ok := raw.(map[string]interface{}); ok {
+		for name, value := range nested {
+			if err := applyYAMLValue(config, key+"."+name, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return applyConfigValue(config, key, yamlValueString(raw))
+}
+
+// yamlValueString converts a decoded YAML value to the string form used by
+// the key=value config format
+func yamlValueString(raw interface{}) string {
+	switch value := raw.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// parseYAMLHosts extracts the hosts section of a YAML document
+func parseYAMLHosts(filename string, data []byte) ([]Host, error) {
+	var doc struct {
+		Hosts []Host `yaml:"hosts"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse proxy config %s: %v", filename, err)
+	}
+	return doc.Hosts, nil
+}
+
+// loadYAMLUsers reads the users section of a YAML config file
+func loadYAMLUsers(filename string) ([]User, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open users file: %v", err)
+	}
+
+	var doc struct {
+		Users []User `yaml:"users"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse users in %s: %v", filename, err)
+	}
+
+	var users []User
+	for i, user := range doc.Users {
+		user.Line = i + 1
+		if !normalizeUser(&user) {
+			continue
+		}
+		// A changed password is written back to users.cnf, not to the YAML
+		// file, so these users could never get past the change screen
+		if user.MustChangePassword {
+			log.Printf("Warning: user %s is in a YAML config and can't be forced to change the password, ignoring the change marker", user.Username)
+			user.MustChangePassword = false
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}